@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+// Entry is one row change captured by the audit triggers of ACCOUNT, THREAD,
+// COMMENT and REACTION. Before is null for an INSERT, After for a DELETE. The
+// images of ACCOUNT leave hashed_password out.
+type Entry struct {
+	Seq       int64           `db:"seq"`
+	Table     string          `db:"table_name"`
+	RowId     string          `db:"row_id"`
+	Operation string          `db:"operation"`
+	Before    json.RawMessage `db:"before"`
+	After     json.RawMessage `db:"after"`
+	Actor     *string         `db:"actor"`
+	Txid      int64           `db:"txid"`
+	ChangedOn time.Time       `db:"changed_on"`
+}
+
+// SetActor records actor as the author of the changes the transaction of conn
+// makes without setting an updated_by column.
+func SetActor(ctx context.Context, conn db.Connection, actor string) error {
+	_, err := conn.Exec(ctx, `SELECT set_config('audit.actor', $1, true)`, actor)
+	return err
+}
+
+// GetHistory returns the changes of the row id of table, oldest first. Rows
+// changed before the audit migration only have the changes made since.
+func GetHistory(ctx context.Context, conn db.Connection, table, id string) ([]Entry, error) {
+	var entries []Entry
+	err := pgxscan.Select(ctx, conn, &entries, `
+	SELECT
+		seq,
+		table_name,
+		row_id,
+		operation,
+		before,
+		after,
+		actor,
+		txid,
+		changed_on
+	FROM AUDIT_LOG
+	WHERE table_name = lower($1) AND row_id = $2
+	ORDER BY seq ASC`,
+		table, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Change is what a ThreadVersion was created by.
+type Change struct {
+	Seq       int64     `db:"seq"`
+	Operation string    `db:"operation"`
+	Actor     *string   `db:"actor"`
+	Txid      int64     `db:"txid"`
+	ChangedOn time.Time `db:"changed_on"`
+}
+
+// ThreadVersion is the thread as a change left it, as it was before being
+// deleted for a DELETE.
+type ThreadVersion struct {
+	Change
+	repository.Thread
+}
+
+// GetThreadHistory returns every version of the thread, oldest first.
+func GetThreadHistory(ctx context.Context, conn db.Connection, id string) ([]ThreadVersion, error) {
+	var versions []ThreadVersion
+	err := pgxscan.Select(ctx, conn, &versions, `
+	SELECT
+		seq,
+		operation,
+		actor,
+		txid,
+		changed_on,
+		`+repository.Threads.Columns()+`
+	FROM AUDIT_LOG,
+		jsonb_populate_record(NULL::THREAD, COALESCE(after, before))
+	WHERE table_name = 'thread' AND row_id = $1
+	ORDER BY seq ASC`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// AsOf selects a past version of a row, by version number or by time.
+type AsOf struct {
+	version int
+	time    time.Time
+}
+
+// AtVersion selects the row as it was when its version column was version.
+func AtVersion(version int) AsOf {
+	return AsOf{version: version}
+}
+
+// AtTime selects the row as the last change written no later than t left it.
+// Changes are timed when written, not when their transaction commits.
+func AtTime(t time.Time) AsOf {
+	return AsOf{time: t}
+}
+
+var (
+	ErrNoVersion = errors.New("no such version in the audit log")
+	ErrDeleted   = errors.New("row was deleted at that time")
+)
+
+// GetThreadAsOf returns the thread as it was at the version or time of asOf.
+func GetThreadAsOf(ctx context.Context, conn db.Connection, id string, asOf AsOf) (repository.Thread, error) {
+	history, err := GetThreadHistory(ctx, conn, id)
+	if err != nil {
+		return repository.Thread{}, err
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		v := history[i]
+		if asOf.time.IsZero() {
+			if v.Operation != "DELETE" && v.Version == asOf.version {
+				return v.Thread, nil
+			}
+			continue
+		}
+
+		if v.ChangedOn.After(asOf.time) {
+			continue
+		}
+		if v.Operation == "DELETE" {
+			return repository.Thread{}, ErrDeleted
+		}
+		return v.Thread, nil
+	}
+
+	return repository.Thread{}, ErrNoVersion
+}
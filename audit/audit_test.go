@@ -0,0 +1,223 @@
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/audit"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestThreadHistory(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	authorId, err := helper.CreateUser()
+	require.NoError(t, err)
+	editorId, err := helper.CreateUser()
+	require.NoError(t, err)
+	beforeCreation := time.Now().Add(-time.Minute)
+	threadId, err := helper.CreateThread(authorId)
+	require.NoError(t, err)
+
+	thread, err := repository.GetThread(ctx, conn, threadId)
+	require.NoError(t, err)
+	original := thread.Title
+	thread.Title = "edited"
+	thread.UpdatedBy = &editorId
+	require.NoError(t, repository.Threads.Patch(ctx, conn, &thread, []string{"title", "updated_by"}))
+	thread.TotalReaction++
+	require.NoError(t, repository.Threads.Patch(ctx, conn, &thread, []string{"total_reaction"}))
+
+	history, err := audit.GetThreadHistory(ctx, conn, threadId)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	for i, v := range history {
+		assert.Equal(t, i+1, v.Version)
+		assert.NotZero(t, v.Txid)
+	}
+	assert.Equal(t, "INSERT", history[0].Operation)
+	assert.Nil(t, history[0].Actor)
+	assert.Equal(t, original, history[0].Title)
+	assert.Equal(t, "UPDATE", history[1].Operation)
+	assert.Equal(t, &editorId, history[1].Actor)
+	assert.Equal(t, "edited", history[1].Title)
+	assert.Nil(t, history[2].Actor)
+	assert.Equal(t, 1, history[2].TotalReaction)
+
+	tests := []struct {
+		name    string
+		asOf    audit.AsOf
+		want    int
+		wantErr error
+	}{
+		{name: "first version", asOf: audit.AtVersion(1), want: 1},
+		{name: "edited version", asOf: audit.AtVersion(2), want: 2},
+		{name: "unknown version", asOf: audit.AtVersion(10), wantErr: audit.ErrNoVersion},
+		{name: "now", asOf: audit.AtTime(time.Now()), want: 3},
+		{name: "before creation", asOf: audit.AtTime(beforeCreation), wantErr: audit.ErrNoVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := audit.GetThreadAsOf(ctx, conn, threadId, tt.asOf)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Version)
+			assert.Equal(t, history[tt.want-1].Thread, got)
+		})
+	}
+}
+
+func TestThreadHistoryActorOfReaction(t *testing.T) {
+	ctx := context.Background()
+	authorId, err := helper.CreateUser()
+	require.NoError(t, err)
+	editorId, err := helper.CreateUser()
+	require.NoError(t, err)
+	reactorId, err := helper.CreateUser()
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(authorId)
+	require.NoError(t, err)
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	thread, err := repository.GetThread(ctx, conn, threadId)
+	require.NoError(t, err)
+	thread.Title = "edited"
+	thread.UpdatedBy = &editorId
+	require.NoError(t, repository.Threads.Patch(ctx, conn, &thread, []string{"title", "updated_by"}))
+
+	// the counter update leaves updated_by to the editor
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		err := audit.SetActor(ctx, tx, reactorId)
+		if err != nil {
+			return err
+		}
+		thread, err := repository.GetThread(ctx, tx, threadId)
+		if err != nil {
+			return err
+		}
+		thread.TotalReaction++
+		return repository.Threads.Patch(ctx, tx, &thread, []string{"total_reaction"})
+	})
+	require.NoError(t, err)
+
+	history, err := audit.GetThreadHistory(ctx, conn, threadId)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, &editorId, history[1].Actor)
+	assert.Equal(t, &reactorId, history[2].Actor)
+	assert.Equal(t, &editorId, history[2].UpdatedBy)
+}
+
+func TestChangedOnIsWriteTime(t *testing.T) {
+	ctx := context.Background()
+	authorId, err := helper.CreateUser()
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(authorId)
+	require.NoError(t, err)
+
+	// both changes share a transaction, so its start time cannot tell them apart
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		thread, err := repository.GetThread(ctx, tx, threadId)
+		if err != nil {
+			return err
+		}
+		thread.TotalReaction++
+		err = repository.Threads.Patch(ctx, tx, &thread, []string{"total_reaction"})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `SELECT pg_sleep(0.05)`)
+		if err != nil {
+			return err
+		}
+		thread.TotalReaction++
+		return repository.Threads.Patch(ctx, tx, &thread, []string{"total_reaction"})
+	})
+	require.NoError(t, err)
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	history, err := audit.GetThreadHistory(ctx, conn, threadId)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, history[1].Txid, history[2].Txid)
+	assert.True(t, history[2].ChangedOn.After(history[1].ChangedOn))
+
+	got, err := audit.GetThreadAsOf(ctx, conn, threadId, audit.AtTime(history[1].ChangedOn))
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.TotalReaction)
+}
+
+func TestAccountHistoryActor(t *testing.T) {
+	ctx := context.Background()
+	accountId, err := helper.CreateUser()
+	require.NoError(t, err)
+
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		err := audit.SetActor(ctx, tx, "support")
+		if err != nil {
+			return err
+		}
+		account, err := repository.GetAccount(ctx, tx, accountId)
+		if err != nil {
+			return err
+		}
+		account.IsDeleted = true
+		return repository.Accounts.Patch(ctx, tx, &account, []string{"is_deleted"})
+	})
+	require.NoError(t, err)
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	history, err := audit.GetHistory(ctx, conn, "ACCOUNT", accountId)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Nil(t, history[0].Actor)
+	assert.Nil(t, history[0].Before)
+	require.NotNil(t, history[1].Actor)
+	assert.Equal(t, "support", *history[1].Actor)
+	assert.JSONEq(t, `false`, jsonField(t, history[1].Before, "is_deleted"))
+	assert.JSONEq(t, `true`, jsonField(t, history[1].After, "is_deleted"))
+
+	for _, entry := range history {
+		assert.NotContains(t, string(entry.Before), "hashed_password")
+		assert.NotContains(t, string(entry.After), "hashed_password")
+	}
+}
+
+func jsonField(t *testing.T, image []byte, name string) string {
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(image, &fields))
+	return string(fields[name])
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/xyedo/db-concurency-problem/report"
+)
+
+var errRegression = errors.New("regression detected")
+
+func runCompare(args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	baseline := flags.String("baseline", "", "JSON report file or directory of saved reports to compare against")
+	current := flags.String("current", "", "JSON report file or directory of saved reports of the new run")
+	threshold := flags.Float64("threshold", 0.1, "relative change counted as a regression, 0.1 is 10%")
+	alpha := flags.Float64("alpha", 0.05, "significance level a latency change must reach")
+	_ = flags.Parse(args)
+
+	if *baseline == "" || *current == "" {
+		return errors.New("compare needs -baseline and -current")
+	}
+
+	base, err := report.Load(*baseline)
+	if err != nil {
+		return err
+	}
+	cur, err := report.Load(*current)
+	if err != nil {
+		return err
+	}
+
+	comparison := report.Compare(base, cur, report.CompareOptions{Threshold: *threshold, Alpha: *alpha})
+	if err := report.WriteComparison(os.Stdout, comparison); err != nil {
+		return err
+	}
+	if comparison.Regressed() {
+		return errRegression
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/fixture"
+)
+
+func runLoadFixtures(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("load needs at least one .jsonl or .csv fixture file")
+	}
+
+	var set fixture.Set
+	for _, path := range args {
+		s, err := fixture.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		set.Add(s)
+	}
+
+	err := db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		return fixture.Load(ctx, tx, set)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("loaded %d accounts, %d threads, %d reactions\n", len(set.Accounts), len(set.Threads), len(set.Reactions))
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+	isolationlevelbenchmark "github.com/xyedo/db-concurency-problem/isolation-level-benchmark"
+	"github.com/xyedo/db-concurency-problem/report"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+func runIsolation(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("run isolation", flag.ExitOnError)
+	level := flags.String("level", "read-committed", "one of "+fmt.Sprint(keys(isoLevels)))
+	object := flags.String("object", "single", "single (update one account) or multiple (comment and bump the thread counter)")
+	workers := flags.Int("workers", 8, "closed loop: concurrent workers hitting the same row")
+	duration := flags.Duration("duration", 10*time.Second, "how long to run")
+	open := openLoopFlags(flags)
+	out := outputFlags(flags)
+	_ = flags.Parse(args)
+
+	isoLevel, err := parseIsoLevel(*level)
+	if err != nil {
+		return err
+	}
+	txOpt := pgx.TxOptions{IsoLevel: isoLevel}
+
+	userId, err := helper.CreateUser()
+	if err != nil {
+		return err
+	}
+	threadId, err := helper.CreateThread(userId)
+	if err != nil {
+		return err
+	}
+
+	var op func(ctx context.Context) error
+	switch *object {
+	case "single":
+		op = func(ctx context.Context) error {
+			return isolationlevelbenchmark.ReadModifyWriteUser(ctx, txOpt, userId)
+		}
+	case "multiple":
+		op = func(ctx context.Context) error {
+			return isolationlevelbenchmark.ReadModifyWriteComment(ctx, txOpt, userId, threadId)
+		}
+	default:
+		return fmt.Errorf("unknown object %q", *object)
+	}
+
+	rec := report.NewRecorder("isolation "+*object, *level)
+	var stats *report.OpenLoop
+	if open.enabled() {
+		stats, err = open.run(ctx, *duration, rec, func(ctx context.Context, _ int) error {
+			return op(ctx)
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		deadline := time.Now().Add(*duration)
+
+		var wg sync.WaitGroup
+		for i := 0; i < *workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil && time.Now().Before(deadline) {
+					_ = rec.Do(ctx, op)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	res := rec.Result()
+	res.OpenLoop = stats
+
+	res.Correct = true
+	res.Detail = "no invariant checked"
+	if *object == "multiple" {
+		conn, err := db.GetConnection(context.Background())
+		if err != nil {
+			return err
+		}
+		defer conn.Release()
+
+		thread, err := repository.GetThread(context.Background(), conn, threadId)
+		if err != nil {
+			return err
+		}
+		comments, err := repository.CountThreadComments(context.Background(), conn, threadId)
+		if err != nil {
+			return err
+		}
+		res.Correct = thread.TotalComment == comments
+		res.Detail = fmt.Sprintf("thread %s total_comment=%d, comments=%d", threadId, thread.TotalComment, comments)
+	}
+
+	return out.write(res)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/xyedo/db-concurency-problem/loadgen"
+	"github.com/xyedo/db-concurency-problem/report"
+)
+
+type openLoop struct {
+	rate        float64
+	maxInFlight int
+	maxQueue    int
+}
+
+func openLoopFlags(flags *flag.FlagSet) *openLoop {
+	o := &openLoop{}
+	flags.Float64Var(&o.rate, "rate", 0, "operations per second, switches from the closed loop to an open loop")
+	flags.IntVar(&o.maxInFlight, "max-in-flight", 0, "open loop: operations running at once, 0 is unbounded")
+	flags.IntVar(&o.maxQueue, "max-queue", 1000, "open loop: operations waiting for -max-in-flight, beyond that they are dropped")
+	return o
+}
+
+func (o *openLoop) enabled() bool {
+	return o.rate > 0
+}
+
+func (o *openLoop) run(ctx context.Context, duration time.Duration, rec *report.Recorder, op loadgen.Op) (*report.OpenLoop, error) {
+	if duration <= 0 {
+		return nil, errors.New("-rate needs a positive -duration")
+	}
+
+	stats, err := loadgen.Run(ctx, loadgen.Config{
+		Rate:        o.rate,
+		Duration:    duration,
+		MaxInFlight: o.maxInFlight,
+		MaxQueue:    o.maxQueue,
+	}, rec, op)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+	lostupdatebenchmark "github.com/xyedo/db-concurency-problem/lost-update-benchmark"
+	"github.com/xyedo/db-concurency-problem/report"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+type reaction interface {
+	Do(ctx context.Context, threadId, userId string) error
+}
+
+// settler is a reaction whose THREAD row lags behind until settled.
+type settler interface {
+	Settle(ctx context.Context, threadId string) error
+}
+
+var strategies = map[string]reaction{
+	"for-update":      lostupdatebenchmark.ForUpdate{},
+	"repeatable-read": lostupdatebenchmark.RepeatableRead{},
+	"cas":             lostupdatebenchmark.CompareAndSet{},
+	"saga":            lostupdatebenchmark.Saga{},
+	"event-sourced":   lostupdatebenchmark.EventSourced{},
+}
+
+func runLostUpdate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("run lost-update", flag.ExitOnError)
+	strategyName := flags.String("strategy", "for-update", "one of "+fmt.Sprint(keys(strategies)))
+	users := flags.Int("users", 100, "concurrent users reacting to the same thread")
+	duration := flags.Duration("duration", 0, "keep reacting for this long, 0 reacts once per user")
+	open := openLoopFlags(flags)
+	out := outputFlags(flags)
+	_ = flags.Parse(args)
+
+	strategy, ok := strategies[*strategyName]
+	if !ok {
+		return fmt.Errorf("unknown strategy %q, want one of %v", *strategyName, keys(strategies))
+	}
+
+	authorId, err := helper.CreateUser()
+	if err != nil {
+		return err
+	}
+	threadId, err := helper.CreateThread(authorId)
+	if err != nil {
+		return err
+	}
+	userIds, err := helper.CreateUsers(*users)
+	if err != nil {
+		return err
+	}
+
+	rec := report.NewRecorder("lost-update", *strategyName)
+	var stats *report.OpenLoop
+	if open.enabled() {
+		stats, err = open.run(ctx, *duration, rec, func(ctx context.Context, i int) error {
+			return strategy.Do(ctx, threadId, userIds[i%len(userIds)])
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		deadline := time.Now().Add(*duration)
+
+		var wg sync.WaitGroup
+		for _, userId := range userIds {
+			wg.Add(1)
+			go func(userId string) {
+				defer wg.Done()
+				for {
+					_ = rec.Do(ctx, func(ctx context.Context) error {
+						return strategy.Do(ctx, threadId, userId)
+					})
+					if ctx.Err() != nil || time.Now().After(deadline) {
+						return
+					}
+				}
+			}(userId)
+		}
+		wg.Wait()
+	}
+	res := rec.Result()
+	res.OpenLoop = stats
+	if s, ok := strategy.(settler); ok {
+		err = s.Settle(context.Background(), threadId)
+		if err != nil {
+			return err
+		}
+	}
+
+	conn, err := db.GetConnection(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	thread, err := repository.GetThread(context.Background(), conn, threadId)
+	if err != nil {
+		return err
+	}
+	res.Correct = thread.TotalReaction == res.Succeeded
+	res.Detail = fmt.Sprintf("thread %s total_reaction=%d, succeeded reactions=%d", threadId, thread.TotalReaction, res.Succeeded)
+
+	return out.write(res)
+}
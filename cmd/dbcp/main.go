@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/config"
+)
+
+const usage = `usage: dbcp [-env file] <command> [flags]
+
+commands:
+  run lost-update   add reactions to one thread with a lost update strategy
+  run write-skew    race account sign ups or fake table numbering
+  run isolation     read-modify-write users or comments at an isolation level
+  run workload      mixed reads, reactions and comments over many threads
+  compare           compare saved JSON reports against a baseline
+  migrate           up, down, to <version>, version or force <version>
+  load              copy accounts, threads and reactions from fixture files
+
+run "dbcp run <scenario> -h" or "dbcp compare -h" for the flags of a command
+`
+
+type scenario func(ctx context.Context, args []string) error
+
+var scenarios = map[string]scenario{
+	"lost-update": runLostUpdate,
+	"write-skew":  runWriteSkew,
+	"isolation":   runIsolation,
+	"workload":    runWorkload,
+}
+
+func main() {
+	flags := flag.NewFlagSet("dbcp", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+	envFile := flags.String("env", ".env", "env file holding the PG_* settings")
+	_ = flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) > 0 && args[0] == "compare" {
+		if err := runCompare(args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var run scenario
+	var rest []string
+	switch {
+	case len(args) > 0 && args[0] == "migrate":
+		run, rest = runMigrate, args[1:]
+	case len(args) > 0 && args[0] == "load":
+		run, rest = runLoadFixtures, args[1:]
+	case len(args) >= 2 && args[0] == "run":
+		var ok bool
+		run, ok = scenarios[args[1]]
+		rest = args[2:]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown scenario %q\n\n", args[1])
+			flags.Usage()
+			os.Exit(2)
+		}
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	config.Get(*envFile)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, rest); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+var isoLevels = map[string]pgx.TxIsoLevel{
+	"read-uncommitted": pgx.ReadUncommitted,
+	"read-committed":   pgx.ReadCommitted,
+	"repeatable-read":  pgx.RepeatableRead,
+	"serializable":     pgx.Serializable,
+}
+
+func parseIsoLevel(level string) (pgx.TxIsoLevel, error) {
+	isoLevel, ok := isoLevels[level]
+	if !ok {
+		return "", fmt.Errorf("unknown isolation level %q, want one of %s", level, strings.Join(keys(isoLevels), ", "))
+	}
+
+	return isoLevel, nil
+}
+
+func keys[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+const migrateUsage = "migrate needs one of: up, down, to <version>, version, force <version>"
+
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	version := func() (uint, error) {
+		if len(args) != 2 {
+			return 0, errors.New(migrateUsage)
+		}
+		v, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid version %q: %w", args[1], err)
+		}
+		return uint(v), nil
+	}
+
+	d := db.Default(ctx)
+	switch args[0] {
+	case "up":
+		return d.Migrate(ctx, db.Up)
+	case "down":
+		return d.Migrate(ctx, db.Down)
+	case "to":
+		v, err := version()
+		if err != nil {
+			return err
+		}
+		return d.Migrate(ctx, db.To(v))
+	case "force":
+		v, err := version()
+		if err != nil {
+			return err
+		}
+		return d.ForceMigrationVersion(ctx, v)
+	case "version":
+		v, dirty, err := d.MigrationVersion(ctx)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			fmt.Printf("%d (dirty)\n", v)
+		} else {
+			fmt.Println(v)
+		}
+		return nil
+	}
+	return errors.New(migrateUsage)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xyedo/db-concurency-problem/report"
+)
+
+type output struct {
+	format string
+	path   string
+	save   string
+}
+
+func outputFlags(flags *flag.FlagSet) *output {
+	o := &output{}
+	flags.StringVar(&o.format, "format", "", fmt.Sprintf("report format, one of %v (default text, or guessed from -out)", report.Formats))
+	flags.StringVar(&o.path, "out", "", "write the report to this file instead of stdout")
+	flags.StringVar(&o.save, "save", "", "also store the JSON report in this directory, for dbcp compare")
+	return o
+}
+
+func (o *output) write(results ...report.Result) error {
+	if o.save != "" {
+		paths, err := report.Save(o.save, results...)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fmt.Fprintln(os.Stderr, "saved", path)
+		}
+	}
+
+	format := report.Text
+	if o.path != "" {
+		format = report.FormatOf(o.path)
+	}
+	if o.format != "" {
+		var err error
+		format, err = report.ParseFormat(o.format)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.path == "" {
+		return report.Write(os.Stdout, format, results...)
+	}
+	return report.WriteFile(o.path, format, results...)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/report"
+	"github.com/xyedo/db-concurency-problem/workload"
+)
+
+func runWorkload(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("run workload", flag.ExitOnError)
+	strategyName := flags.String("strategy", "for-update", "reaction strategy, one of "+fmt.Sprint(keys(strategies)))
+	threads := flags.Int("threads", 100, "threads to seed")
+	users := flags.Int("users", 1000, "users to seed")
+	distribution := flags.String("dist", "zipf", "thread distribution: uniform, zipf[:s] or hotspot[:fraction:probability]")
+	mixSpec := flags.String("mix", "read:50,react:40,comment:10", "operation weights")
+	level := flags.String("level", "read-committed", "isolation level of the comment transaction, one of "+fmt.Sprint(keys(isoLevels)))
+	concurrency := flags.Int("concurrency", 32, "workers of the closed loop")
+	open := openLoopFlags(flags)
+	duration := flags.Duration("duration", 30*time.Second, "how long to run")
+	seed := flags.Int64("seed", 1, "random seed of the key and operation picks")
+	out := outputFlags(flags)
+	_ = flags.Parse(args)
+
+	strategy, ok := strategies[*strategyName]
+	if !ok {
+		return fmt.Errorf("unknown strategy %q, want one of %v", *strategyName, keys(strategies))
+	}
+	mix, err := workload.ParseMix(*mixSpec)
+	if err != nil {
+		return err
+	}
+	isoLevel, err := parseIsoLevel(*level)
+	if err != nil {
+		return err
+	}
+
+	fixture, err := workload.Seed(ctx, *threads, *users)
+	if err != nil {
+		return err
+	}
+
+	cfg := workload.Config{
+		Distribution:     *distribution,
+		Mix:              mix,
+		Reaction:         strategy,
+		CommentTxOptions: pgx.TxOptions{IsoLevel: isoLevel},
+		Concurrency:      *concurrency,
+		Rate:             open.rate,
+		MaxInFlight:      open.maxInFlight,
+		MaxQueue:         open.maxQueue,
+		Duration:         *duration,
+		Seed:             *seed,
+	}
+	rec := report.NewRecorder("workload "+*distribution, *strategyName)
+	summary, err := workload.Run(ctx, cfg, fixture, rec)
+	if err != nil {
+		return err
+	}
+	res := rec.Result()
+	res.OpenLoop = summary.OpenLoop
+	if s, ok := strategy.(settler); ok {
+		for _, threadId := range fixture.ThreadIds {
+			err = s.Settle(context.Background(), threadId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	res.Correct, res.Detail, err = workload.Verify(context.Background(), fixture)
+	if err != nil {
+		return err
+	}
+	res.Detail = fmt.Sprintf("hottest thread got %.1f%% of the operations, %s", summary.Hottest*100, res.Detail)
+
+	return out.write(res)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/go-faker/faker/v4"
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/report"
+	skewwriteproblem "github.com/xyedo/db-concurency-problem/skew-write-problem"
+)
+
+func runWriteSkew(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("run write-skew", flag.ExitOnError)
+	scenarioName := flags.String("scenario", "account", "account (concurrent sign up with one username) or numbering (FAKE_TABLE sequence, wipes the table)")
+	level := flags.String("level", "read-committed", "one of "+fmt.Sprint(keys(isoLevels)))
+	concurrency := flags.Int("concurrency", 2, "transactions racing in each round")
+	rounds := flags.Int("rounds", 10, "number of rounds")
+	out := outputFlags(flags)
+	_ = flags.Parse(args)
+
+	isoLevel, err := parseIsoLevel(*level)
+	if err != nil {
+		return err
+	}
+	txOpt := pgx.TxOptions{IsoLevel: isoLevel}
+
+	rec := report.NewRecorder("write-skew "+*scenarioName, *level)
+	var res report.Result
+	switch *scenarioName {
+	case "account":
+		res, err = raceAccount(ctx, rec, txOpt, *concurrency, *rounds)
+	case "numbering":
+		res, err = raceNumbering(ctx, rec, txOpt, *concurrency, *rounds)
+	default:
+		err = fmt.Errorf("unknown write skew scenario %q", *scenarioName)
+	}
+	if err != nil {
+		return err
+	}
+
+	return out.write(res)
+}
+
+func race(ctx context.Context, rec *report.Recorder, concurrency int, op func(ctx context.Context) error) []error {
+	var wg sync.WaitGroup
+	errs := make([]error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = rec.Do(ctx, op)
+		}(i)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func raceAccount(ctx context.Context, rec *report.Recorder, txOpt pgx.TxOptions, concurrency, rounds int) (report.Result, error) {
+	duplicated := 0
+	for round := 0; round < rounds && ctx.Err() == nil; round++ {
+		username := faker.Username() + helper.AccountId()
+		errs := race(ctx, rec, concurrency, func(ctx context.Context) error {
+			return skewwriteproblem.InsertNewAccount(ctx, txOpt, skewwriteproblem.Account{
+				Username: &username,
+				Password: faker.Password(),
+			})
+		})
+
+		created := 0
+		for _, err := range errs {
+			if err == nil {
+				created++
+			}
+		}
+		if created > 1 {
+			duplicated++
+		}
+	}
+
+	res := rec.Result()
+	res.Correct = duplicated == 0
+	res.Detail = fmt.Sprintf("%d rounds created the same username more than once", duplicated)
+	return res, nil
+}
+
+func raceNumbering(ctx context.Context, rec *report.Recorder, txOpt pgx.TxOptions, concurrency, rounds int) (report.Result, error) {
+	err := helper.DeleteFakeTable(ctx)
+	if err != nil {
+		return report.Result{}, err
+	}
+
+	for round := 0; round < rounds && ctx.Err() == nil; round++ {
+		race(ctx, rec, concurrency, func(ctx context.Context) error {
+			return skewwriteproblem.InsertNewFakeTable(ctx, txOpt)
+		})
+	}
+	res := rec.Result()
+
+	numbers, err := helper.SelectFakeTable(context.Background())
+	if err != nil {
+		return report.Result{}, err
+	}
+	seen := make(map[string]bool, len(numbers))
+	duplicated := 0
+	for _, number := range numbers {
+		if seen[number] {
+			duplicated++
+		}
+		seen[number] = true
+	}
+
+	res.Correct = duplicated == 0
+	res.Detail = fmt.Sprintf("%d numbers issued, %d duplicated", len(numbers), duplicated)
+	return res, nil
+}
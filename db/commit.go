@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type CommitStatus string
+
+const (
+	CommitStatusCommitted  CommitStatus = "committed"
+	CommitStatusAborted    CommitStatus = "aborted"
+	CommitStatusInProgress CommitStatus = "in progress"
+)
+
+var (
+	// ErrCommitAborted means the commit failed on the wire but Postgres
+	// confirmed the transaction aborted, so running it again is safe.
+	ErrCommitAborted = errors.New("commit failed and the transaction was aborted")
+	// ErrCommitUnknown means the commit failed on the wire and its outcome
+	// could not be verified, the transaction may or may not have committed.
+	ErrCommitUnknown = errors.New("commit outcome unknown")
+)
+
+var (
+	// VerifyTimeout bounds how long an ambiguous commit is verified.
+	VerifyTimeout = 5 * time.Second
+	// VerifyInterval is how often a transaction still in progress is checked
+	// again.
+	VerifyInterval = 50 * time.Millisecond
+)
+
+type commitVerificationKey struct{}
+
+// WithCommitVerification returns a context that makes Atomic and
+// AtomicWithAutoRetry capture the transaction id of every attempt, so a commit
+// that fails on the wire (connection reset, lost response, timeout) is
+// verified with VerifyCommit instead of being reported as a plain error. It
+// costs one round trip and assigns a transaction id even to read only
+// transactions.
+func WithCommitVerification(ctx context.Context) context.Context {
+	return context.WithValue(ctx, commitVerificationKey{}, true)
+}
+
+func verifyCommit(ctx context.Context) bool {
+	verify, _ := ctx.Value(commitVerificationKey{}).(bool)
+	return verify
+}
+
+// VerifyCommit asks Postgres, on a fresh connection, what happened to the
+// transaction txid returned by txid_current().
+func VerifyCommit(ctx context.Context, txid int64) (CommitStatus, error) {
+	conn, err := GetConnection(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Release()
+
+	var status *string
+	err = conn.QueryRow(ctx, `SELECT txid_status($1)`, txid).Scan(&status)
+	if err != nil {
+		return "", err
+	}
+	if status == nil {
+		return "", fmt.Errorf("transaction %d is too old to verify", txid)
+	}
+
+	return CommitStatus(*status), nil
+}
+
+// currentTxid returns the transaction id of tx when commit verification is on,
+// 0 otherwise.
+func currentTxid(ctx context.Context, tx pgx.Tx) (int64, error) {
+	if !verifyCommit(ctx) {
+		return 0, nil
+	}
+
+	var txid int64
+	err := tx.QueryRow(ctx, `SELECT txid_current()`).Scan(&txid)
+	return txid, err
+}
+
+// commit commits tx. When the commit fails without an answer from Postgres and
+// txid is known, it waits for the outcome: nil once committed, ErrCommitAborted
+// once aborted, ErrCommitUnknown if it cannot tell.
+func commit(ctx context.Context, tx pgx.Tx, txid int64) error {
+	err := tx.Commit(ctx)
+	if err == nil || txid == 0 || !ambiguous(err) {
+		return err
+	}
+
+	// the caller context may be what broke the commit
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), VerifyTimeout)
+	defer cancel()
+
+	for {
+		// a failed verification is retried too, the pool may hand out idle
+		// connections broken by the same network failure
+		status, verifyErr := VerifyCommit(ctx, txid)
+		switch {
+		case verifyErr == nil && status == CommitStatusCommitted:
+			return nil
+		case verifyErr == nil && status == CommitStatusAborted:
+			return fmt.Errorf("%w: %w", ErrCommitAborted, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if verifyErr != nil {
+				return fmt.Errorf("%w: %w (verification: %w)", ErrCommitUnknown, err, verifyErr)
+			}
+			return fmt.Errorf("%w: %w (still %s)", ErrCommitUnknown, err, status)
+		case <-time.After(VerifyInterval):
+		}
+	}
+}
+
+// ambiguous reports whether a commit error leaves the outcome unknown, that is
+// Postgres did not answer it.
+func ambiguous(err error) bool {
+	var pgErr *pgconn.PgError
+	return !errors.As(err, &pgErr) && !errors.Is(err, pgx.ErrTxCommitRollback)
+}
@@ -0,0 +1,53 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+func TestVerifyCommit(t *testing.T) {
+	ctx := context.Background()
+	txid := func(tx db.Connection) int64 {
+		var id int64
+		require.NoError(t, tx.QueryRow(ctx, `SELECT txid_current()`).Scan(&id))
+		return id
+	}
+
+	var committed int64
+	err := db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		committed = txid(tx)
+		return nil
+	})
+	require.NoError(t, err)
+
+	var aborted int64
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		aborted = txid(tx)
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+
+	var inProgress db.CommitStatus
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		var err error
+		inProgress, err = db.VerifyCommit(ctx, txid(tx))
+		return err
+	})
+	require.NoError(t, err)
+
+	status, err := db.VerifyCommit(ctx, committed)
+	require.NoError(t, err)
+	assert.Equal(t, db.CommitStatusCommitted, status)
+
+	status, err = db.VerifyCommit(ctx, aborted)
+	require.NoError(t, err)
+	assert.Equal(t, db.CommitStatusAborted, status)
+
+	assert.Equal(t, db.CommitStatusInProgress, inProgress)
+}
@@ -0,0 +1,58 @@
+package db
+
+import "context"
+
+// TxHook observes every attempt made by Atomic, AtomicWithAutoRetry and
+// RetryMatchAndSet. Begin may wrap the connection handed to the callback,
+// BeforeCommit runs once the callback succeeded (RetryMatchAndSet has no
+// commit, so it is skipped there) and End receives the attempt outcome.
+// Every field is optional.
+type TxHook struct {
+	Begin        func(ctx context.Context, conn Connection) Connection
+	BeforeCommit func(ctx context.Context) error
+	End          func(err error)
+}
+
+type txHooksKey struct{}
+
+type txHooks []TxHook
+
+// WithTxHook returns a context that makes every transaction started with it
+// run through hook, after the hooks already registered on ctx.
+func WithTxHook(ctx context.Context, hook TxHook) context.Context {
+	hooks := hooksFrom(ctx)
+	return context.WithValue(ctx, txHooksKey{}, append(hooks[:len(hooks):len(hooks)], hook))
+}
+
+func hooksFrom(ctx context.Context) txHooks {
+	hooks, _ := ctx.Value(txHooksKey{}).(txHooks)
+	return hooks
+}
+
+func (hooks txHooks) begin(ctx context.Context, conn Connection) Connection {
+	for _, hook := range hooks {
+		if hook.Begin != nil {
+			conn = hook.Begin(ctx, conn)
+		}
+	}
+	return conn
+}
+
+func (hooks txHooks) beforeCommit(ctx context.Context) error {
+	for _, hook := range hooks {
+		if hook.BeforeCommit != nil {
+			if err := hook.BeforeCommit(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (hooks txHooks) end(err error) {
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].End != nil {
+			hooks[i].End(err)
+		}
+	}
+}
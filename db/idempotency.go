@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type IdempotencyKey struct {
+	Key       string
+	Operation string
+	Request   any
+}
+
+const (
+	idempotencyInProgress = "in_progress"
+	idempotencyCompleted  = "completed"
+)
+
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key was used with a different request")
+
+// Idempotent runs cb at most once per (Key, Operation). The key row is inserted
+// in the same transaction as cb, so a concurrent duplicate blocks on it until
+// the first request commits and then replays the stored response; if the
+// first request fails its key is rolled back and the duplicate runs cb itself.
+func Idempotent[T any](ctx context.Context, key IdempotencyKey, cb func(tx Connection) (T, error)) (T, error) {
+	var result T
+
+	request, err := json.Marshal(key.Request)
+	if err != nil {
+		return result, err
+	}
+	sum := sha256.Sum256(request)
+	requestHash := hex.EncodeToString(sum[:])
+
+	err = Atomic(ctx, pgx.TxOptions{}, func(tx Connection) error {
+		tag, err := tx.Exec(ctx, `INSERT INTO IDEMPOTENCY_KEY (
+			key,
+			operation,
+			request_hash,
+			status,
+			created_on
+			) VALUES
+			($1,$2,$3,$4,$5)
+			ON CONFLICT (key, operation) DO NOTHING`,
+			key.Key,
+			key.Operation,
+			requestHash,
+			idempotencyInProgress,
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+
+		if tag.RowsAffected() == 0 {
+			var (
+				storedHash string
+				response   []byte
+			)
+			err := tx.QueryRow(ctx, `
+			SELECT
+				request_hash,
+				response
+			FROM IDEMPOTENCY_KEY
+			WHERE key = $1 AND operation = $2
+			FOR UPDATE`,
+				key.Key,
+				key.Operation,
+			).Scan(&storedHash, &response)
+			if err != nil {
+				return err
+			}
+
+			if storedHash != requestHash {
+				return ErrIdempotencyKeyMismatch
+			}
+
+			return json.Unmarshal(response, &result)
+		}
+
+		result, err = cb(tx)
+		if err != nil {
+			return err
+		}
+
+		response, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `
+		UPDATE IDEMPOTENCY_KEY SET
+			status = $3,
+			response = $4,
+			updated_on = $5
+		WHERE key = $1 AND operation = $2`,
+			key.Key,
+			key.Operation,
+			idempotencyCompleted,
+			response,
+			time.Now(),
+		)
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return result, nil
+}
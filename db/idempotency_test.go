@@ -0,0 +1,91 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestIdempotent(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("replay return stored response", func(t *testing.T) {
+		key := db.IdempotencyKey{Key: helper.JobId(), Operation: "test", Request: map[string]int{"n": 1}}
+		var calls int32
+		cb := func(tx db.Connection) (int, error) {
+			return int(atomic.AddInt32(&calls, 1)) * 10, nil
+		}
+
+		first, err := db.Idempotent(ctx, key, cb)
+		require.NoError(t, err)
+		second, err := db.Idempotent(ctx, key, cb)
+		require.NoError(t, err)
+
+		assert.Equal(t, 10, first)
+		assert.Equal(t, first, second)
+		assert.EqualValues(t, 1, calls)
+	})
+
+	t.Run("reject different request with same key", func(t *testing.T) {
+		key := db.IdempotencyKey{Key: helper.JobId(), Operation: "test", Request: "a"}
+		_, err := db.Idempotent(ctx, key, func(tx db.Connection) (string, error) { return "ok", nil })
+		require.NoError(t, err)
+
+		key.Request = "b"
+		_, err = db.Idempotent(ctx, key, func(tx db.Connection) (string, error) { return "ok", nil })
+		require.ErrorIs(t, err, db.ErrIdempotencyKeyMismatch)
+	})
+
+	t.Run("failed attempt can be retried", func(t *testing.T) {
+		key := db.IdempotencyKey{Key: helper.JobId(), Operation: "test"}
+		_, err := db.Idempotent(ctx, key, func(tx db.Connection) (string, error) { return "", errors.New("timeout") })
+		require.Error(t, err)
+
+		res, err := db.Idempotent(ctx, key, func(tx db.Connection) (string, error) { return "ok", nil })
+		require.NoError(t, err)
+		assert.Equal(t, "ok", res)
+	})
+
+	t.Run("concurrent duplicates run once", func(t *testing.T) {
+		key := db.IdempotencyKey{Key: helper.JobId(), Operation: "test"}
+		var calls int32
+
+		const concurrent = 10
+		var wg sync.WaitGroup
+		results := make([]int32, concurrent)
+		errs := make([]error, concurrent)
+		for i := 0; i < concurrent; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], errs[i] = db.Idempotent(ctx, key, func(tx db.Connection) (int32, error) {
+					return atomic.AddInt32(&calls, 1), nil
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		for i := 0; i < concurrent; i++ {
+			require.NoError(t, errs[i])
+			assert.EqualValues(t, 1, results[i])
+		}
+		assert.EqualValues(t, 1, calls)
+	})
+}
@@ -0,0 +1,403 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one numbered pair of files of db/migrations.
+type Migration struct {
+	Version  uint
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	return readMigrations(migrationFiles, "migrations")
+}
+
+func readMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if version == 0 {
+			return nil, fmt.Errorf("migration %s: version must be greater than 0", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[uint(version)] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Target is the version Migrate brings the database to.
+type Target int
+
+const (
+	// Up applies every migration.
+	Up Target = -1
+	// Down reverts every migration.
+	Down Target = 0
+)
+
+// To migrates up or down to version.
+func To(version uint) Target {
+	return Target(version)
+}
+
+var (
+	ErrDirty            = errors.New("database is dirty, a migration failed halfway: fix it by hand then force its version")
+	ErrChecksumMismatch = errors.New("applied migration was modified")
+	ErrUnknownVersion   = errors.New("unknown migration version")
+)
+
+// migrateLock is the first key of the advisory lock migrators take, the
+// second one is the schema so isolated test schemas migrate concurrently.
+const migrateLock = 7_342_000
+
+// Migrate brings the default database to target, see DB.Migrate.
+func Migrate(ctx context.Context, target Target) error {
+	return Default(ctx).Migrate(ctx, target)
+}
+
+// Migrate applies or reverts the embedded migrations until the database is at
+// target. The version is kept in schema_migrations like the migrate CLI does,
+// so databases migrated by either one can be taken over by the other, and the
+// checksum of every applied up file in schema_migration_checksum: a database
+// whose applied migrations were edited since is refused with
+// ErrChecksumMismatch, one left dirty by a failed migration with ErrDirty.
+// Concurrent migrators of the same schema wait for each other.
+func (d *DB) Migrate(ctx context.Context, target Target) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	return d.withMigrateLock(ctx, func(conn *pgxpool.Conn) error {
+		return migrate(ctx, conn, migrations, target)
+	})
+}
+
+// MigrationVersion returns the version of the database, 0 when no migration
+// was applied, and whether it is dirty.
+func (d *DB) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	var version uint
+	var dirty bool
+	err := d.withMigrateLock(ctx, func(conn *pgxpool.Conn) error {
+		var err error
+		version, dirty, err = migrationVersion(ctx, conn)
+		return err
+	})
+	return version, dirty, err
+}
+
+// ForceMigrationVersion sets the version of the database and clears its dirty
+// flag without running anything, once a failed migration was fixed by hand.
+// The checksums of the migrations up to version are recorded again.
+func (d *DB) ForceMigrationVersion(ctx context.Context, version uint) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	return d.withMigrateLock(ctx, func(conn *pgxpool.Conn) error {
+		if version != 0 {
+			if _, ok := findMigration(migrations, version); !ok {
+				return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+			}
+		}
+		return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			err := setMigrationVersion(ctx, tx, version, false)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, `DELETE FROM schema_migration_checksum`)
+			if err != nil {
+				return err
+			}
+			for _, m := range migrations {
+				if m.Version > version {
+					break
+				}
+				err = recordChecksum(ctx, tx, m)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func (d *DB) withMigrateLock(ctx context.Context, cb func(conn *pgxpool.Conn) error) error {
+	conn, err := d.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1, hashtext(current_schema()))`, migrateLock)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1, hashtext(current_schema()))`, migrateLock)
+	}()
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT NOT NULL PRIMARY KEY,
+			dirty BOOLEAN NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS schema_migration_checksum (
+			version BIGINT NOT NULL PRIMARY KEY,
+			checksum TEXT NOT NULL,
+			applied_on TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return err
+	}
+
+	return cb(conn)
+}
+
+func migrate(ctx context.Context, conn *pgxpool.Conn, migrations []Migration, target Target) error {
+	current, dirty, err := migrationVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w: version %d", ErrDirty, current)
+	}
+	err = verifyChecksums(ctx, conn, migrations, current)
+	if err != nil {
+		return err
+	}
+
+	want := uint(0)
+	switch {
+	case target == Up:
+		if len(migrations) > 0 {
+			want = migrations[len(migrations)-1].Version
+		}
+	case target > 0:
+		want = uint(target)
+		if _, ok := findMigration(migrations, want); !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, want)
+		}
+	case target < 0:
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	if want >= current {
+		for _, m := range migrations {
+			if m.Version <= current || m.Version > want {
+				continue
+			}
+			err = runMigration(ctx, conn, m, m.Up, m.Version)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= want {
+			continue
+		}
+		previous := uint(0)
+		if i > 0 {
+			previous = migrations[i-1].Version
+		}
+		err = runMigration(ctx, conn, m, m.Down, previous)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMigration marks the database dirty at m, runs script and marks it clean
+// at version, so a script failing halfway leaves the database dirty like the
+// migrate CLI does.
+func runMigration(ctx context.Context, conn *pgxpool.Conn, m Migration, script string, version uint) error {
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		return setMigrationVersion(ctx, tx, m.Version, true)
+	})
+	if err != nil {
+		return err
+	}
+
+	if script != "" {
+		// without arguments pgx uses the simple protocol, which accepts
+		// several statements at once and runs them in one transaction
+		_, err = conn.Exec(ctx, script)
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		err := setMigrationVersion(ctx, tx, version, false)
+		if err != nil {
+			return err
+		}
+		if version < m.Version {
+			_, err = tx.Exec(ctx, `DELETE FROM schema_migration_checksum WHERE version = $1`, m.Version)
+			return err
+		}
+		return recordChecksum(ctx, tx, m)
+	})
+}
+
+func migrationVersion(ctx context.Context, conn Connection) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := conn.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	// the migrate CLI writes -1 once every migration was reverted
+	if version < 0 {
+		return 0, dirty, nil
+	}
+	return uint(version), dirty, nil
+}
+
+func setMigrationVersion(ctx context.Context, conn Connection, version uint, dirty bool) error {
+	_, err := conn.Exec(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	if version == 0 && !dirty {
+		return nil
+	}
+	_, err = conn.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, int64(version), dirty)
+	return err
+}
+
+// verifyChecksums compares the applied migrations with the embedded ones.
+// Migrations applied by the migrate CLI have no checksum yet, theirs is
+// recorded as is.
+func verifyChecksums(ctx context.Context, conn *pgxpool.Conn, migrations []Migration, current uint) error {
+	if current == 0 {
+		return nil
+	}
+	if _, ok := findMigration(migrations, current); !ok {
+		return fmt.Errorf("%w: database is at %d", ErrUnknownVersion, current)
+	}
+
+	var checksums []migrationChecksum
+	err := pgxscan.Select(ctx, conn, &checksums, `SELECT version, checksum FROM schema_migration_checksum`)
+	if err != nil {
+		return err
+	}
+	applied := make(map[uint]string, len(checksums))
+	for _, c := range checksums {
+		applied[uint(c.Version)] = c.Checksum
+	}
+
+	for _, m := range migrations {
+		if m.Version > current {
+			break
+		}
+		checksum, ok := applied[m.Version]
+		if !ok {
+			err = recordChecksum(ctx, conn, m)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if checksum != m.Checksum {
+			return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+type migrationChecksum struct {
+	Version  int64  `db:"version"`
+	Checksum string `db:"checksum"`
+}
+
+func recordChecksum(ctx context.Context, conn Connection, m Migration) error {
+	_, err := conn.Exec(ctx, `
+		INSERT INTO schema_migration_checksum (version, checksum)
+		VALUES ($1, $2)
+		ON CONFLICT (version) DO UPDATE SET checksum = EXCLUDED.checksum, applied_on = now()`,
+		int64(m.Version), m.Checksum,
+	)
+	return err
+}
+
+func findMigration(migrations []Migration, version uint) (Migration, bool) {
+	for _, m := range migrations {
+		if m.Version == version {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
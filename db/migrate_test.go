@@ -0,0 +1,94 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := db.Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	assert.Equal(t, uint(1), migrations[0].Version)
+	assert.Equal(t, "initialization", migrations[0].Name)
+	for i, m := range migrations {
+		assert.NotEmpty(t, m.Up, m.Name)
+		assert.NotEmpty(t, m.Down, m.Name)
+		assert.Len(t, m.Checksum, 64, m.Name)
+		if i > 0 {
+			assert.Greater(t, m.Version, migrations[i-1].Version)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	migrations, err := db.Migrations()
+	require.NoError(t, err)
+	latest := migrations[len(migrations)-1].Version
+
+	isolated := dbtest.Isolate(t)
+	tableExists := func(name string) bool {
+		var exists bool
+		err := isolated.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists)
+		require.NoError(t, err)
+		return exists
+	}
+
+	version, dirty, err := isolated.MigrationVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+	assert.False(t, dirty)
+	assert.True(t, tableExists("saga"))
+
+	t.Run("down to a version reverts the ones after it", func(t *testing.T) {
+		require.NoError(t, isolated.Migrate(ctx, db.To(1)))
+		version, _, err := isolated.MigrationVersion(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, uint(1), version)
+		assert.False(t, tableExists("saga"))
+		assert.True(t, tableExists("thread"))
+
+		require.NoError(t, isolated.Migrate(ctx, db.Up))
+		version, _, err = isolated.MigrationVersion(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, latest, version)
+		assert.True(t, tableExists("saga"))
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		err := isolated.Migrate(ctx, db.To(latest+100))
+		assert.ErrorIs(t, err, db.ErrUnknownVersion)
+	})
+
+	t.Run("modified migration", func(t *testing.T) {
+		_, err := isolated.Exec(ctx, `UPDATE schema_migration_checksum SET checksum = 'edited' WHERE version = 1`)
+		require.NoError(t, err)
+
+		err = isolated.Migrate(ctx, db.Up)
+		assert.ErrorIs(t, err, db.ErrChecksumMismatch)
+
+		require.NoError(t, isolated.ForceMigrationVersion(ctx, latest))
+		require.NoError(t, isolated.Migrate(ctx, db.Up))
+	})
+
+	t.Run("dirty database", func(t *testing.T) {
+		_, err := isolated.Exec(ctx, `UPDATE schema_migrations SET dirty = true`)
+		require.NoError(t, err)
+
+		err = isolated.Migrate(ctx, db.Up)
+		assert.ErrorIs(t, err, db.ErrDirty)
+
+		require.NoError(t, isolated.ForceMigrationVersion(ctx, latest))
+		version, dirty, err := isolated.MigrationVersion(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, latest, version)
+		assert.False(t, dirty)
+	})
+}
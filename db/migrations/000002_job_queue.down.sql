@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS job_dequeue_idx;
+
+DROP TABLE IF EXISTS JOB;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS JOB (
+  id TEXT PRIMARY KEY,
+  kind TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  priority INT NOT NULL DEFAULT 0,
+  attempt INT NOT NULL DEFAULT 0,
+  max_attempt INT NOT NULL DEFAULT 5,
+  run_after TIMESTAMPTZ NOT NULL,
+  locked_until TIMESTAMPTZ,
+  last_error TEXT,
+  created_on TIMESTAMPTZ NOT NULL,
+  updated_on TIMESTAMPTZ,
+  version BIGINT NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS job_dequeue_idx ON JOB (priority DESC, run_after ASC) WHERE status IN ('pending', 'running');
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS OUTBOX (
+  seq BIGSERIAL PRIMARY KEY,
+  aggregate_id TEXT NOT NULL,
+  event_type TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_on TIMESTAMPTZ NOT NULL,
+  published_on TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON OUTBOX (seq) WHERE published_on IS NULL;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS thread_changed_notify ON THREAD;
+
+DROP FUNCTION IF EXISTS notify_thread_changed();
@@ -0,0 +1,20 @@
+CREATE OR REPLACE FUNCTION notify_thread_changed() RETURNS TRIGGER AS $$
+BEGIN
+  IF NEW.total_comment IS DISTINCT FROM OLD.total_comment
+    OR NEW.total_reaction IS DISTINCT FROM OLD.total_reaction THEN
+    PERFORM pg_notify('thread_changed', json_build_object(
+      'id', NEW.id,
+      'total_comment', NEW.total_comment,
+      'total_reaction', NEW.total_reaction,
+      'version', NEW.version
+    )::text);
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS thread_changed_notify ON THREAD;
+
+CREATE TRIGGER thread_changed_notify
+  AFTER UPDATE ON THREAD
+  FOR EACH ROW EXECUTE FUNCTION notify_thread_changed();
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS IDEMPOTENCY_KEY;
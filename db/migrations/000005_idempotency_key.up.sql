@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS IDEMPOTENCY_KEY (
+  key TEXT NOT NULL,
+  operation TEXT NOT NULL,
+  request_hash TEXT NOT NULL,
+  status TEXT NOT NULL,
+  response JSONB,
+  created_on TIMESTAMPTZ NOT NULL,
+  updated_on TIMESTAMPTZ,
+  PRIMARY KEY (key, operation)
+);
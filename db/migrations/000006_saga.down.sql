@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS saga_unfinished_idx;
+
+DROP TABLE IF EXISTS SAGA;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS SAGA (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  status TEXT NOT NULL,
+  current_step INT NOT NULL DEFAULT 0,
+  data JSONB NOT NULL,
+  last_error TEXT,
+  locked_until TIMESTAMPTZ,
+  created_on TIMESTAMPTZ NOT NULL,
+  updated_on TIMESTAMPTZ,
+  version BIGINT NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS saga_unfinished_idx ON SAGA (created_on) WHERE status IN ('running', 'compensating');
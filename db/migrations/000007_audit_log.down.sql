@@ -0,0 +1,13 @@
+DROP TRIGGER IF EXISTS reaction_audit ON REACTION;
+
+DROP TRIGGER IF EXISTS comment_audit ON COMMENT;
+
+DROP TRIGGER IF EXISTS thread_audit ON THREAD;
+
+DROP TRIGGER IF EXISTS account_audit ON ACCOUNT;
+
+DROP FUNCTION IF EXISTS audit_row_change();
+
+DROP INDEX IF EXISTS audit_log_row_idx;
+
+DROP TABLE IF EXISTS AUDIT_LOG;
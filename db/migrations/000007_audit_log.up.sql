@@ -0,0 +1,63 @@
+CREATE TABLE IF NOT EXISTS AUDIT_LOG (
+  seq BIGSERIAL PRIMARY KEY,
+  table_name TEXT NOT NULL,
+  row_id TEXT NOT NULL,
+  operation TEXT NOT NULL,
+  before JSONB,
+  after JSONB,
+  actor TEXT,
+  txid BIGINT NOT NULL DEFAULT txid_current(),
+  changed_on TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_row_idx ON AUDIT_LOG (table_name, row_id, seq);
+
+-- the actor is the updated_by column of the new row when the table has one,
+-- otherwise what the transaction set with set_config('audit.actor', ..., true)
+CREATE OR REPLACE FUNCTION audit_row_change() RETURNS TRIGGER AS $$
+DECLARE
+  before_image JSONB;
+  after_image JSONB;
+BEGIN
+  IF TG_OP <> 'INSERT' THEN
+    before_image := to_jsonb(OLD);
+  END IF;
+  IF TG_OP <> 'DELETE' THEN
+    after_image := to_jsonb(NEW);
+  END IF;
+  IF TG_OP = 'UPDATE' AND before_image = after_image THEN
+    RETURN NULL;
+  END IF;
+
+  INSERT INTO AUDIT_LOG (table_name, row_id, operation, before, after, actor)
+  VALUES (
+    TG_TABLE_NAME,
+    COALESCE(after_image, before_image)->>'id',
+    TG_OP,
+    before_image,
+    after_image,
+    COALESCE(after_image->>'updated_by', NULLIF(current_setting('audit.actor', true), ''))
+  );
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS account_audit ON ACCOUNT;
+CREATE TRIGGER account_audit
+  AFTER INSERT OR UPDATE OR DELETE ON ACCOUNT
+  FOR EACH ROW EXECUTE FUNCTION audit_row_change();
+
+DROP TRIGGER IF EXISTS thread_audit ON THREAD;
+CREATE TRIGGER thread_audit
+  AFTER INSERT OR UPDATE OR DELETE ON THREAD
+  FOR EACH ROW EXECUTE FUNCTION audit_row_change();
+
+DROP TRIGGER IF EXISTS comment_audit ON COMMENT;
+CREATE TRIGGER comment_audit
+  AFTER INSERT OR UPDATE OR DELETE ON COMMENT
+  FOR EACH ROW EXECUTE FUNCTION audit_row_change();
+
+DROP TRIGGER IF EXISTS reaction_audit ON REACTION;
+CREATE TRIGGER reaction_audit
+  AFTER INSERT OR UPDATE OR DELETE ON REACTION
+  FOR EACH ROW EXECUTE FUNCTION audit_row_change();
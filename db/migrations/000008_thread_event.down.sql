@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS THREAD_PROJECTION;
+
+DROP TABLE IF EXISTS THREAD_SNAPSHOT;
+
+DROP TABLE IF EXISTS THREAD_EVENT;
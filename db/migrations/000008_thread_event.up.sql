@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS THREAD_EVENT (
+  thread_id TEXT NOT NULL REFERENCES THREAD ON DELETE CASCADE,
+  seq BIGINT NOT NULL,
+  event_type TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_on TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (thread_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS THREAD_SNAPSHOT (
+  thread_id TEXT PRIMARY KEY REFERENCES THREAD ON DELETE CASCADE,
+  seq BIGINT NOT NULL,
+  total_comment BIGINT NOT NULL,
+  total_reaction BIGINT NOT NULL,
+  created_on TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS THREAD_PROJECTION (
+  thread_id TEXT PRIMARY KEY REFERENCES THREAD ON DELETE CASCADE,
+  seq BIGINT NOT NULL,
+  updated_on TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,5 @@
+-- the writing transaction, the relay holds back rows of transactions newer
+-- than the oldest one still running
+ALTER TABLE OUTBOX ADD COLUMN IF NOT EXISTS txid BIGINT NOT NULL DEFAULT txid_current();
+
+CREATE INDEX IF NOT EXISTS outbox_published_idx ON OUTBOX (published_on) WHERE published_on IS NOT NULL;
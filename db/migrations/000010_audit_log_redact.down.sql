@@ -0,0 +1,34 @@
+DROP TRIGGER IF EXISTS account_audit ON ACCOUNT;
+CREATE TRIGGER account_audit
+  AFTER INSERT OR UPDATE OR DELETE ON ACCOUNT
+  FOR EACH ROW EXECUTE FUNCTION audit_row_change();
+
+CREATE OR REPLACE FUNCTION audit_row_change() RETURNS TRIGGER AS $$
+DECLARE
+  before_image JSONB;
+  after_image JSONB;
+BEGIN
+  IF TG_OP <> 'INSERT' THEN
+    before_image := to_jsonb(OLD);
+  END IF;
+  IF TG_OP <> 'DELETE' THEN
+    after_image := to_jsonb(NEW);
+  END IF;
+  IF TG_OP = 'UPDATE' AND before_image = after_image THEN
+    RETURN NULL;
+  END IF;
+
+  INSERT INTO AUDIT_LOG (table_name, row_id, operation, before, after, actor)
+  VALUES (
+    TG_TABLE_NAME,
+    COALESCE(after_image, before_image)->>'id',
+    TG_OP,
+    before_image,
+    after_image,
+    COALESCE(after_image->>'updated_by', NULLIF(current_setting('audit.actor', true), ''))
+  );
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE AUDIT_LOG ALTER COLUMN changed_on SET DEFAULT now();
@@ -0,0 +1,31 @@
+CREATE OR REPLACE FUNCTION audit_row_change() RETURNS TRIGGER AS $$
+DECLARE
+  before_image JSONB;
+  after_image JSONB;
+BEGIN
+  IF TG_OP <> 'INSERT' THEN
+    before_image := to_jsonb(OLD);
+  END IF;
+  IF TG_OP <> 'DELETE' THEN
+    after_image := to_jsonb(NEW);
+  END IF;
+  IF TG_NARGS > 0 THEN
+    before_image := before_image - TG_ARGV;
+    after_image := after_image - TG_ARGV;
+  END IF;
+  IF TG_OP = 'UPDATE' AND before_image = after_image THEN
+    RETURN NULL;
+  END IF;
+
+  INSERT INTO AUDIT_LOG (table_name, row_id, operation, before, after, actor)
+  VALUES (
+    TG_TABLE_NAME,
+    COALESCE(after_image, before_image)->>'id',
+    TG_OP,
+    before_image,
+    after_image,
+    COALESCE(after_image->>'updated_by', NULLIF(current_setting('audit.actor', true), ''))
+  );
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
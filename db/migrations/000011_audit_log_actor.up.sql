@@ -0,0 +1,40 @@
+-- the actor is the updated_by column of the new row when the change set it,
+-- otherwise what the transaction set with set_config('audit.actor', ..., true):
+-- updated_by stays on the row, a change leaving it alone is not its author's.
+-- The trigger arguments name columns kept out of the images, such as secrets.
+CREATE OR REPLACE FUNCTION audit_row_change() RETURNS TRIGGER AS $$
+DECLARE
+  before_image JSONB;
+  after_image JSONB;
+  change_actor TEXT;
+BEGIN
+  IF TG_OP <> 'INSERT' THEN
+    before_image := to_jsonb(OLD);
+  END IF;
+  IF TG_OP <> 'DELETE' THEN
+    after_image := to_jsonb(NEW);
+  END IF;
+  IF TG_NARGS > 0 THEN
+    before_image := before_image - TG_ARGV;
+    after_image := after_image - TG_ARGV;
+  END IF;
+  IF TG_OP = 'UPDATE' AND before_image = after_image THEN
+    RETURN NULL;
+  END IF;
+
+  IF after_image->'updated_by' IS DISTINCT FROM before_image->'updated_by' THEN
+    change_actor := after_image->>'updated_by';
+  END IF;
+
+  INSERT INTO AUDIT_LOG (table_name, row_id, operation, before, after, actor)
+  VALUES (
+    TG_TABLE_NAME,
+    COALESCE(after_image, before_image)->>'id',
+    TG_OP,
+    before_image,
+    after_image,
+    COALESCE(change_actor, NULLIF(current_setting('audit.actor', true), ''))
+  );
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -7,6 +7,7 @@ import (
 	"log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -15,7 +16,7 @@ import (
 )
 
 var (
-	pool *pgxpool.Pool
+	pool atomic.Pointer[pgxpool.Pool]
 	once sync.Once
 )
 
@@ -28,6 +29,53 @@ type Connection interface {
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
+// DB is a pool of connections to one database.
+type DB struct {
+	*pgxpool.Pool
+}
+
+// Open connects to the database described by dsn, a connection string or URL
+// understood by pgxpool, and pings it.
+func Open(ctx context.Context, dsn string) (*DB, error) {
+	c, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := pgxpool.NewWithConfig(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.Ping(ctx)
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	return &DB{p}, nil
+}
+
+// SetDefault makes GetConnection, Atomic and the other helpers of this package
+// use d instead of the database configured in the env file. It returns the
+// previous default, nil if none was connected yet.
+func SetDefault(d *DB) *DB {
+	once.Do(func() {})
+
+	previous := pool.Swap(d.Pool)
+	if previous == nil {
+		return nil
+	}
+	return &DB{previous}
+}
+
+// Default returns the database GetConnection acquires from, connecting to the
+// one of the env file if SetDefault was never called.
+func Default(ctx context.Context) *DB {
+	once.Do(func() { connect(ctx) })
+	return &DB{pool.Load()}
+}
+
 func connect(ctx context.Context) {
 	config := config.Get().Postgre
 	dsn := fmt.Sprintf(
@@ -39,27 +87,17 @@ func connect(ctx context.Context) {
 		config.Database,
 		runtime.NumCPU()*4,
 	)
-	c, err := pgxpool.ParseConfig(dsn)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	db, err := pgxpool.NewWithConfig(ctx, c)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	err = db.Ping(ctx)
+	db, err := Open(ctx, dsn)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	pool = db
+	pool.Store(db.Pool)
 }
 
 func GetConnection(ctx context.Context) (*pgxpool.Conn, error) {
 	once.Do(func() { connect(ctx) })
-	return pool.Acquire(ctx)
+	return pool.Load().Acquire(ctx)
 }
 
 func Atomic(ctx context.Context, txOpt pgx.TxOptions, cb func(tx Connection) error) error {
@@ -73,17 +111,29 @@ func Atomic(ctx context.Context, txOpt pgx.TxOptions, cb func(tx Connection) err
 	if err != nil {
 		return err
 	}
+	txid, err := currentTxid(ctx, tx)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
 
-	err = cb(tx)
+	hooks := hooksFrom(ctx)
+	err = cb(hooks.begin(ctx, tx))
+	if err == nil {
+		err = hooks.beforeCommit(ctx)
+	}
 	if err != nil {
 		if rbErr := tx.Rollback(ctx); rbErr != nil {
-			return fmt.Errorf("cannot rollback %w: %w", rbErr, err)
+			err = fmt.Errorf("cannot rollback %w: %w", rbErr, err)
 		}
 
+		hooks.end(err)
 		return err
 	}
 
-	return tx.Commit(ctx)
+	err = commit(ctx, tx, txid)
+	hooks.end(err)
+	return err
 }
 
 var ErrVersionMisMatch = errors.New("version mismacth, must retry")
@@ -101,23 +151,24 @@ func RetryMatchAndSet(ctx context.Context, cb func(conn Connection) error) error
 	}
 	defer conn.Release()
 
-	var errToTry error
+	return RetryMatchAndSetOn(ctx, conn, cb)
+}
 
-	retryCount := 0
-	for retryCount < maxRetry {
-		errToTry = cb(conn)
-		if errToTry != nil && !errors.Is(errToTry, ErrVersionMisMatch) {
-			return errToTry
+func RetryMatchAndSetOn(ctx context.Context, conn Connection, cb func(conn Connection) error) error {
+	hooks := hooksFrom(ctx)
+	for retryCount := 0; retryCount < maxRetry; retryCount++ {
+		errToTry := cb(hooks.begin(ctx, conn))
+		hooks.end(errToTry)
+		if errToTry == nil {
+			return nil
 		}
 
-		retryCount++
-	}
-
-	if retryCount == maxRetry {
-		return ErrLimitRetry
+		if !errors.Is(errToTry, ErrVersionMisMatch) {
+			return errToTry
+		}
 	}
 
-	return nil
+	return ErrLimitRetry
 }
 
 var ErrLimitRetry = errors.New("retry limit exceeded!")
@@ -137,12 +188,25 @@ func transaction(ctx context.Context, txOpt pgx.TxOptions, cb func(tx Connection
 	if err != nil {
 		return err
 	}
+	txid, err := currentTxid(ctx, tx)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
 
-	err = cb(tx)
+	hooks := hooksFrom(ctx)
+	err = cb(hooks.begin(ctx, tx))
+	if err == nil {
+		err = hooks.beforeCommit(ctx)
+	}
 	if err != nil {
 		if rbErr := tx.Rollback(ctx); rbErr != nil {
-			return fmt.Errorf("cannot rollback %w: %w", rbErr, err)
+			err = fmt.Errorf("cannot rollback %w: %w", rbErr, err)
+			hooks.end(err)
+			return err
 		}
+		hooks.end(err)
+
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "40001" {
 			return transaction(ctx, txOpt, cb, retry-1)
@@ -152,10 +216,11 @@ func transaction(ctx context.Context, txOpt pgx.TxOptions, cb func(tx Connection
 
 	}
 
-	err = tx.Commit(ctx)
+	err = commit(ctx, tx, txid)
+	hooks.end(err)
 	if err != nil {
 		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == "40001" {
+		if errors.As(err, &pgErr) && pgErr.Code == "40001" || errors.Is(err, ErrCommitAborted) {
 			return transaction(ctx, txOpt, cb, retry-1)
 		}
 
@@ -0,0 +1,78 @@
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+// EnvEmbedded opts a test run in the embedded server: DBTEST_EMBEDDED=1 go test ./...
+const EnvEmbedded = "DBTEST_EMBEDDED"
+
+// Main runs the tests of a package, call it from TestMain. With
+// DBTEST_EMBEDDED=1 it starts a Server and stops it once the tests ran;
+// otherwise the tests use the database of the env file, usually started with
+// make up. Either way the package gets a schema of its own, see CreateSchema,
+// made the default of the db package, so packages can be tested in parallel.
+func Main(m *testing.M) {
+	MainWith(m, nil)
+}
+
+// MainWith is Main with the database passed through wrap before the schema of
+// the package is created on it, for instance to route its connections through
+// a proxy.
+func MainWith(m *testing.M, wrap func(ctx context.Context, d *db.DB) (*db.DB, error)) {
+	os.Exit(runMain(m, wrap))
+}
+
+func runMain(m *testing.M, wrap func(ctx context.Context, d *db.DB) (*db.DB, error)) int {
+	ctx := context.Background()
+
+	var base *db.DB
+	if os.Getenv(EnvEmbedded) == "1" {
+		s, err := Start(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer func() {
+			if err := s.Stop(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+		base = s.DB
+	} else {
+		base = db.Default(ctx)
+	}
+
+	if wrap != nil {
+		wrapped, err := wrap(ctx, base)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer wrapped.Close()
+		base = wrapped
+	}
+
+	schema := SchemaName(strings.TrimSuffix(filepath.Base(os.Args[0]), ".test"))
+	isolated, err := CreateSchema(ctx, base, schema)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	db.SetDefault(isolated)
+	defer func() {
+		isolated.Close()
+		if err := DropSchema(ctx, base, schema); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+
+	return m.Run()
+}
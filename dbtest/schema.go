@@ -0,0 +1,117 @@
+package dbtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+// extensionLock serializes CREATE EXTENSION between test processes, IF NOT
+// EXISTS alone still races on the catalog's unique index.
+const extensionLock = 7_342_001
+
+var notIdentifier = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// SchemaName returns a schema name unique to this call, prefixed with a
+// readable form of the test name.
+func SchemaName(testName string) string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	name := notIdentifier.ReplaceAllString(strings.ToLower(testName), "_")
+	// identifiers are cut at 63 bytes, keep room for "t_", "_" and the suffix
+	if len(name) > 50 {
+		name = name[:50]
+	}
+	return "t_" + strings.Trim(name, "_") + "_" + hex.EncodeToString(suffix)
+}
+
+// Isolate gives the test its own schema: it creates one on the default
+// database, applies db/migrations in it and makes the db package use a pool
+// whose connections have search_path set to it, so the test only sees the rows
+// it wrote. The schema is dropped and the previous default restored on
+// cleanup. Tests calling Isolate must not run in parallel within a package.
+func Isolate(t testing.TB) *db.DB {
+	t.Helper()
+	ctx := context.Background()
+
+	base := db.Default(ctx)
+	schema := SchemaName(t.Name())
+	isolated, err := CreateSchema(ctx, base, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := db.SetDefault(isolated)
+	t.Cleanup(func() {
+		db.SetDefault(previous)
+		isolated.Close()
+		if err := DropSchema(ctx, base, schema); err != nil {
+			t.Error(err)
+		}
+	})
+	return isolated
+}
+
+// CreateSchema creates schema on d, opens a pool on it with the same settings
+// as d and applies db/migrations there. Extensions stay in public, which is
+// kept in the search_path so their types resolve.
+func CreateSchema(ctx context.Context, d *db.DB, schema string) (*db.DB, error) {
+	err := createExtensions(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.Exec(ctx, `CREATE SCHEMA `+pgx.Identifier{schema}.Sanitize())
+	if err != nil {
+		return nil, err
+	}
+
+	c := d.Config()
+	c.ConnConfig.RuntimeParams["search_path"] = pgx.Identifier{schema}.Sanitize() + ",public"
+	p, err := pgxpool.NewWithConfig(ctx, c)
+	if err != nil {
+		_ = DropSchema(ctx, d, schema)
+		return nil, err
+	}
+	isolated := &db.DB{Pool: p}
+
+	err = isolated.Migrate(ctx, db.Up)
+	if err != nil {
+		isolated.Close()
+		_ = DropSchema(ctx, d, schema)
+		return nil, err
+	}
+	return isolated, nil
+}
+
+// DropSchema drops schema and everything in it.
+func DropSchema(ctx context.Context, d *db.DB, schema string) error {
+	_, err := d.Exec(ctx, `DROP SCHEMA IF EXISTS `+pgx.Identifier{schema}.Sanitize()+` CASCADE`)
+	if err != nil {
+		return fmt.Errorf("dbtest: drop schema %s: %w", schema, err)
+	}
+	return nil
+}
+
+// createExtensions installs the extensions the migrations need in public
+// before they run, otherwise they would land in the test schema and go away
+// with it.
+func createExtensions(ctx context.Context, d *db.DB) error {
+	return pgx.BeginFunc(ctx, d, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, extensionLock)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `CREATE EXTENSION IF NOT EXISTS citext SCHEMA public`)
+		return err
+	})
+}
@@ -0,0 +1,210 @@
+package dbtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+const (
+	User     = "dbtest"
+	Database = "concurency-problem"
+)
+
+// Server is a throwaway Postgres cluster living in a temporary directory.
+type Server struct {
+	Dir  string
+	Host string
+	Port int
+	DB   *db.DB
+
+	pgCtl string
+}
+
+// Start creates a cluster with the initdb and pg_ctl binaries found in PG_BIN,
+// in PATH or in the usual install directories, starts it on a free port with
+// durability turned off, applies db/migrations and opens Server.DB.
+func Start(ctx context.Context) (*Server, error) {
+	initdb, err := FindBinary("initdb")
+	if err != nil {
+		return nil, err
+	}
+	pgCtl, err := FindBinary("pg_ctl")
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "dbtest-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Dir: dir, Host: "127.0.0.1", pgCtl: pgCtl}
+
+	err = s.start(ctx, initdb)
+	if err != nil {
+		_ = s.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) start(ctx context.Context, initdb string) error {
+	data := filepath.Join(s.Dir, "data")
+	err := run(ctx, initdb, "-D", data, "-U", User, "--auth=trust", "--encoding=UTF8", "--no-sync")
+	if err != nil {
+		return err
+	}
+
+	s.Port, err = freePort()
+	if err != nil {
+		return err
+	}
+
+	options := strings.Join([]string{
+		"-p " + strconv.Itoa(s.Port),
+		"-c listen_addresses=" + s.Host,
+		"-c unix_socket_directories=" + s.Dir,
+		"-c fsync=off",
+		"-c synchronous_commit=off",
+		"-c full_page_writes=off",
+		"-c max_connections=" + strconv.Itoa(max(100, runtime.NumCPU()*8)),
+	}, " ")
+	err = run(ctx, s.pgCtl, "-D", data, "-l", filepath.Join(s.Dir, "postgres.log"), "-o", options, "-w", "start")
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, s.Log())
+	}
+
+	admin, err := db.Open(ctx, s.DSN("postgres"))
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	_, err = admin.Exec(ctx, fmt.Sprintf(`CREATE DATABASE %q`, Database))
+	if err != nil {
+		return err
+	}
+
+	s.DB, err = db.Open(ctx, s.DSN(Database))
+	if err != nil {
+		return err
+	}
+
+	return s.DB.Migrate(ctx, db.Up)
+}
+
+// DSN returns the connection string of database on the server.
+func (s *Server) DSN(database string) string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s dbname=%s sslmode=disable pool_max_conns=%d",
+		s.Host, s.Port, User, database, runtime.NumCPU()*4,
+	)
+}
+
+// Log returns the server log, handy when it refused to start.
+func (s *Server) Log() string {
+	b, _ := os.ReadFile(filepath.Join(s.Dir, "postgres.log"))
+	return string(b)
+}
+
+// Stop closes Server.DB, stops the cluster and removes its directory.
+func (s *Server) Stop() error {
+	if s.DB != nil {
+		s.DB.Close()
+	}
+
+	var err error
+	if _, statErr := os.Stat(filepath.Join(s.Dir, "data", "postmaster.pid")); statErr == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err = run(ctx, s.pgCtl, "-D", filepath.Join(s.Dir, "data"), "-m", "immediate", "-w", "stop")
+	}
+
+	return errors.Join(err, os.RemoveAll(s.Dir))
+}
+
+// FindBinary looks name up in PG_BIN, then PATH, then the directories Debian,
+// Red Hat and Homebrew install Postgres to, newest version first.
+func FindBinary(name string) (string, error) {
+	if dir := os.Getenv("PG_BIN"); dir != "" {
+		path := filepath.Join(dir, name)
+		if isExecutable(path) {
+			return path, nil
+		}
+		return "", fmt.Errorf("dbtest: %s not found in PG_BIN=%s", name, dir)
+	}
+
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+
+	var candidates []string
+	for _, pattern := range []string{
+		"/usr/lib/postgresql/*/bin",
+		"/usr/pgsql-*/bin",
+		"/opt/homebrew/opt/postgresql@*/bin",
+		"/usr/local/opt/postgresql@*/bin",
+	} {
+		dirs, _ := filepath.Glob(pattern)
+		candidates = append(candidates, dirs...)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return version(candidates[i]) > version(candidates[j]) })
+	for _, dir := range candidates {
+		path := filepath.Join(dir, name)
+		if isExecutable(path) {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("dbtest: %s not found, install Postgres or set PG_BIN", name)
+}
+
+// version extracts the major version from an install directory such as
+// /usr/lib/postgresql/16/bin or /usr/pgsql-15/bin.
+func version(dir string) int {
+	digits := strings.FieldsFunc(dir, func(r rune) bool { return r < '0' || r > '9' })
+	if len(digits) == 0 {
+		return 0
+	}
+	v, _ := strconv.Atoi(digits[len(digits)-1])
+	return v
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0o111 != 0
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func run(ctx context.Context, name string, args ...string) error {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("dbtest: %s: %w\n%s", filepath.Base(name), err, out.String())
+	}
+	return nil
+}
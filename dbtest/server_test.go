@@ -0,0 +1,97 @@
+package dbtest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+)
+
+func TestFindBinary(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "initdb"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pg_ctl"), []byte("not executable"), 0o644))
+	t.Setenv("PG_BIN", dir)
+
+	path, err := dbtest.FindBinary("initdb")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "initdb"), path)
+
+	_, err = dbtest.FindBinary("pg_ctl")
+	assert.ErrorContains(t, err, "pg_ctl not found in PG_BIN")
+}
+
+func TestStart(t *testing.T) {
+	if _, err := dbtest.FindBinary("initdb"); err != nil {
+		t.Skip(err)
+	}
+
+	ctx := context.Background()
+	s, err := dbtest.Start(ctx)
+	require.NoError(t, err)
+
+	var threads int
+	require.NoError(t, s.DB.QueryRow(ctx, `SELECT count(1) FROM THREAD`).Scan(&threads))
+	assert.Zero(t, threads)
+
+	require.NoError(t, s.Stop())
+	_, err = os.Stat(s.Dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSchemaName(t *testing.T) {
+	a := dbtest.SchemaName("TestInsertNewFakeTable/no_error_in_serializable")
+	b := dbtest.SchemaName("TestInsertNewFakeTable/no_error_in_serializable")
+	assert.NotEqual(t, a, b)
+	assert.Regexp(t, `^t_testinsertnewfaketable_no_error_in_serializable_[0-9a-f]{8}$`, a)
+
+	long := dbtest.SchemaName(strings.Repeat("x", 100))
+	assert.LessOrEqual(t, len(long), 63)
+}
+
+func TestIsolate(t *testing.T) {
+	if _, err := dbtest.FindBinary("initdb"); err != nil {
+		t.Skip(err)
+	}
+
+	ctx := context.Background()
+	s, err := dbtest.Start(ctx)
+	require.NoError(t, err)
+	defer s.Stop()
+	previous := db.SetDefault(s.DB)
+	if previous != nil {
+		defer db.SetDefault(previous)
+	}
+
+	insert := func(t *testing.T) {
+		conn, err := db.GetConnection(ctx)
+		require.NoError(t, err)
+		defer conn.Release()
+
+		_, err = conn.Exec(ctx, `INSERT INTO FAKE_TABLE (id, "number", created_on) VALUES ('ft', 'ft-001', now())`)
+		require.NoError(t, err)
+	}
+
+	var schema string
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			isolated := dbtest.Isolate(t)
+			require.NoError(t, isolated.QueryRow(ctx, `SELECT current_schema()`).Scan(&schema))
+			insert(t)
+		})
+	}
+
+	var exists bool
+	require.NoError(t, s.DB.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)`, schema).Scan(&exists))
+	assert.False(t, exists)
+
+	var rows int
+	require.NoError(t, s.DB.QueryRow(ctx, `SELECT count(1) FROM FAKE_TABLE`).Scan(&rows))
+	assert.Zero(t, rows)
+}
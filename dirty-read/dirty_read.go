@@ -0,0 +1,51 @@
+package dirtyread
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+var errRollback = errors.New("rollback uncommitted write")
+
+// ReadDuringUncommittedWrite bumps the thread reaction counter in a writer
+// transaction that is later rolled back, and reads the counter with txOpt
+// while that write is still uncommitted.
+func ReadDuringUncommittedWrite(ctx context.Context, txOpt pgx.TxOptions, threadId string) (before, seen int, err error) {
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(writer db.Connection) error {
+		thread, err := repository.GetThread(ctx, writer, threadId)
+		if err != nil {
+			return err
+		}
+		before = thread.TotalReaction
+
+		thread.TotalReaction += 1000
+		err = repository.UpdateThread(ctx, writer, thread)
+		if err != nil {
+			return err
+		}
+
+		err = db.Atomic(ctx, txOpt, func(reader db.Connection) error {
+			thread, err := repository.GetThread(ctx, reader, threadId)
+			if err != nil {
+				return err
+			}
+			seen = thread.TotalReaction
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		return 0, 0, err
+	}
+
+	return before, seen, nil
+}
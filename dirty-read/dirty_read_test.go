@@ -0,0 +1,57 @@
+package dirtyread_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	dirtyread "github.com/xyedo/db-concurency-problem/dirty-read"
+	"github.com/xyedo/db-concurency-problem/helper"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestReadDuringUncommittedWrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		level pgx.TxIsoLevel
+	}{
+		{
+			name:  "read uncommitted behaves as read committed in postgres",
+			level: pgx.ReadUncommitted,
+		},
+		{
+			name:  "no dirty read in read committed",
+			level: pgx.ReadCommitted,
+		},
+		{
+			name:  "no dirty read in repeatable read",
+			level: pgx.RepeatableRead,
+		},
+		{
+			name:  "no dirty read in serializable",
+			level: pgx.Serializable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, err := helper.CreateUser()
+			require.NoError(t, err)
+			threadId, err := helper.CreateThread(userId)
+			require.NoError(t, err)
+
+			before, seen, err := dirtyread.ReadDuringUncommittedWrite(context.Background(), pgx.TxOptions{IsoLevel: tt.level}, threadId)
+			require.NoError(t, err)
+			require.Equal(t, before, seen)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package eventsource
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+const maxRetry = 5
+
+// AddReaction writes a reaction of the user to the thread and appends
+// ReactionAdded to its stream in one transaction.
+func AddReaction(ctx context.Context, threadId, userId string) (string, error) {
+	reactionId := helper.ReactionId()
+	err := execute(ctx, threadId, func(tx db.Connection, t *Thread) ([]Event, error) {
+		_, err := repository.GetAccount(ctx, tx, userId)
+		if err != nil {
+			return nil, err
+		}
+
+		err = repository.CreateReaction(ctx, tx, repository.Reaction{
+			Id:        reactionId,
+			AccountId: userId,
+			ThreadId:  &threadId,
+			Content:   "like",
+			CreatedOn: time.Now(),
+			Version:   1,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return []Event{ReactionAdded{ReactionId: reactionId, AccountId: userId, Content: "like"}}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return reactionId, nil
+}
+
+// AddComment writes a comment of the user on the thread and appends
+// CommentAdded to its stream in one transaction.
+func AddComment(ctx context.Context, threadId, userId, content string, replyTo *string) (string, error) {
+	commentId := helper.CommentId()
+	err := execute(ctx, threadId, func(tx db.Connection, t *Thread) ([]Event, error) {
+		err := repository.CreateComment(ctx, tx, repository.Comment{
+			Id:        commentId,
+			ThreadId:  threadId,
+			UserId:    userId,
+			ReplyTo:   replyTo,
+			Content:   content,
+			CreatedOn: time.Now(),
+			Version:   1,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return []Event{CommentAdded{CommentId: commentId, UserId: userId, ReplyTo: replyTo}}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return commentId, nil
+}
+
+// execute loads the thread, lets cb decide the events and appends them,
+// starting over from a fresh load when another transaction appended first.
+func execute(ctx context.Context, threadId string, cb func(tx db.Connection, t *Thread) ([]Event, error)) error {
+	for retryCount := 0; retryCount < maxRetry; retryCount++ {
+		err := db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+			t, err := Load(ctx, tx, threadId)
+			if err != nil {
+				return err
+			}
+
+			events, err := cb(tx, &t)
+			if err != nil {
+				return err
+			}
+
+			return Append(ctx, tx, &t, events...)
+		})
+		if !errors.Is(err, db.ErrVersionMisMatch) {
+			return err
+		}
+	}
+
+	return db.ErrLimitRetry
+}
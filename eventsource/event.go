@@ -0,0 +1,83 @@
+package eventsource
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+const (
+	TypeThreadStarted = "thread.started"
+	TypeReactionAdded = "reaction.added"
+	TypeCommentAdded  = "comment.added"
+)
+
+// Event is one fact of the stream of a thread. apply folds it into the
+// thread.
+type Event interface {
+	EventType() string
+	apply(t *repository.Thread)
+}
+
+// ThreadStarted opens the stream of a thread with the counters the THREAD row
+// had then, so threads created before event sourcing keep their totals.
+type ThreadStarted struct {
+	TotalComment  int `json:"total_comment"`
+	TotalReaction int `json:"total_reaction"`
+}
+
+func (ThreadStarted) EventType() string { return TypeThreadStarted }
+func (e ThreadStarted) apply(t *repository.Thread) {
+	t.TotalComment = e.TotalComment
+	t.TotalReaction = e.TotalReaction
+}
+
+type ReactionAdded struct {
+	ReactionId string `json:"reaction_id"`
+	AccountId  string `json:"account_id"`
+	Content    string `json:"content"`
+}
+
+func (ReactionAdded) EventType() string          { return TypeReactionAdded }
+func (ReactionAdded) apply(t *repository.Thread) { t.TotalReaction++ }
+
+type CommentAdded struct {
+	CommentId string  `json:"comment_id"`
+	UserId    string  `json:"user_id"`
+	ReplyTo   *string `json:"reply_to"`
+}
+
+func (CommentAdded) EventType() string          { return TypeCommentAdded }
+func (CommentAdded) apply(t *repository.Thread) { t.TotalComment++ }
+
+// Record is an event as stored in THREAD_EVENT.
+type Record struct {
+	ThreadId  string          `db:"thread_id"`
+	Seq       int64           `db:"seq"`
+	EventType string          `db:"event_type"`
+	Payload   json.RawMessage `db:"payload"`
+	CreatedOn time.Time       `db:"created_on"`
+}
+
+// Event decodes the payload of r.
+func (r Record) Event() (Event, error) {
+	var e Event
+	switch r.EventType {
+	case TypeThreadStarted:
+		e = &ThreadStarted{}
+	case TypeReactionAdded:
+		e = &ReactionAdded{}
+	case TypeCommentAdded:
+		e = &CommentAdded{}
+	default:
+		return nil, fmt.Errorf("unknown event type %q", r.EventType)
+	}
+
+	err := json.Unmarshal(r.Payload, e)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
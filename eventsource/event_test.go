@@ -0,0 +1,35 @@
+package eventsource_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/eventsource"
+)
+
+func TestRecordEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   eventsource.Event
+		wantErr bool
+	}{
+		{name: "thread started", event: &eventsource.ThreadStarted{TotalComment: 2, TotalReaction: 3}},
+		{name: "reaction added", event: &eventsource.ReactionAdded{ReactionId: "r", AccountId: "a", Content: "like"}},
+		{name: "comment added", event: &eventsource.CommentAdded{CommentId: "c", UserId: "u"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := json.Marshal(tt.event)
+			require.NoError(t, err)
+
+			got, err := eventsource.Record{EventType: tt.event.EventType(), Payload: payload}.Event()
+			require.NoError(t, err)
+			assert.Equal(t, tt.event, got)
+		})
+	}
+
+	_, err := eventsource.Record{EventType: "thread.renamed", Payload: []byte(`{}`)}.Event()
+	assert.Error(t, err)
+}
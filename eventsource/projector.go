@@ -0,0 +1,150 @@
+package eventsource
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+// Projector adds the reactions and comments of the event streams to
+// THREAD.total_reaction and THREAD.total_comment. THREAD_PROJECTION holds the
+// last seq projected of every thread, THREAD lags behind the stream until the
+// projector caught up. Increments made to THREAD directly are kept.
+type Projector struct {
+	BatchSize    int
+	PollInterval time.Duration
+}
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = 100 * time.Millisecond
+)
+
+func (p Projector) Run(ctx context.Context) error {
+	pollInterval := p.PollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	for {
+		n, err := p.ProjectOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Println(err)
+		}
+		if n > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// ProjectOnce projects a batch of the threads whose stream is ahead of their
+// projection and returns how many it projected.
+func (p Projector) ProjectOnce(ctx context.Context) (int, error) {
+	batchSize := p.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var threadIds []string
+	err = pgxscan.Select(ctx, conn, &threadIds, `
+	SELECT e.thread_id
+	FROM THREAD_EVENT e
+	LEFT JOIN THREAD_PROJECTION p ON p.thread_id = e.thread_id
+	GROUP BY e.thread_id, p.seq
+	HAVING max(e.seq) > COALESCE(p.seq, 0)
+	LIMIT $1`,
+		batchSize,
+	)
+	conn.Release()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, threadId := range threadIds {
+		err = Project(ctx, threadId)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(threadIds), nil
+}
+
+// Project adds the events of the thread not projected yet to the counters of
+// its THREAD row. ThreadStarted adds nothing, its counters are the row's.
+// Concurrent projections of one thread queue on its THREAD_PROJECTION row.
+func Project(ctx context.Context, threadId string) error {
+	return db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		_, err := tx.Exec(ctx, `
+		INSERT INTO THREAD_PROJECTION (thread_id, seq, updated_on)
+		VALUES ($1, 0, now())
+		ON CONFLICT (thread_id) DO NOTHING`,
+			threadId,
+		)
+		if err != nil {
+			return err
+		}
+
+		var projected int64
+		err = tx.QueryRow(ctx, `SELECT seq FROM THREAD_PROJECTION WHERE thread_id = $1 FOR UPDATE`, threadId).Scan(&projected)
+		if err != nil {
+			return err
+		}
+
+		records, err := Events(ctx, tx, threadId, projected)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		var delta repository.Thread
+		for _, r := range records {
+			e, err := r.Event()
+			if err != nil {
+				return err
+			}
+			if _, ok := e.(ThreadStarted); ok {
+				continue
+			}
+			e.apply(&delta)
+		}
+
+		tag, err := tx.Exec(ctx, `
+		UPDATE THREAD SET
+			total_comment = total_comment + $2,
+			total_reaction = total_reaction + $3,
+			updated_on = now(),
+			version = version + 1
+		WHERE id = $1`,
+			threadId,
+			delta.TotalComment,
+			delta.TotalReaction,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() != 1 {
+			return errors.New("nothing was updated, something went wrong")
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE THREAD_PROJECTION SET seq = $2, updated_on = now() WHERE thread_id = $1`, threadId, records[len(records)-1].Seq)
+		return err
+	})
+}
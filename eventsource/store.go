@@ -0,0 +1,166 @@
+package eventsource
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+// SnapshotEvery is the number of events between two snapshots of a thread.
+var SnapshotEvery int64 = 100
+
+// Thread is the aggregate: the THREAD row with its counters folded from the
+// event stream up to Seq. Increments made to THREAD directly once the stream
+// started are not part of them.
+type Thread struct {
+	repository.Thread
+	Seq int64
+}
+
+type snapshot struct {
+	Seq           int64 `db:"seq"`
+	TotalComment  int   `db:"total_comment"`
+	TotalReaction int   `db:"total_reaction"`
+}
+
+// Load reads the thread and folds its latest snapshot and the events after
+// it. A thread without events keeps the counters of its row.
+func Load(ctx context.Context, conn db.Connection, threadId string) (Thread, error) {
+	row, err := repository.GetThread(ctx, conn, threadId)
+	if err != nil {
+		return Thread{}, err
+	}
+	t := Thread{Thread: row}
+
+	var snap snapshot
+	err = pgxscan.Get(ctx, conn, &snap, `
+	SELECT
+		seq,
+		total_comment,
+		total_reaction
+	FROM THREAD_SNAPSHOT
+	WHERE thread_id = $1`,
+		threadId,
+	)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return Thread{}, err
+	}
+	if err == nil {
+		t.Seq = snap.Seq
+		t.TotalComment = snap.TotalComment
+		t.TotalReaction = snap.TotalReaction
+	}
+
+	records, err := Events(ctx, conn, threadId, t.Seq)
+	if err != nil {
+		return Thread{}, err
+	}
+	for _, r := range records {
+		e, err := r.Event()
+		if err != nil {
+			return Thread{}, err
+		}
+		e.apply(&t.Thread)
+		t.Seq = r.Seq
+	}
+
+	return t, nil
+}
+
+// Events returns the events of the thread after seq, in order.
+func Events(ctx context.Context, conn db.Connection, threadId string, after int64) ([]Record, error) {
+	var records []Record
+	err := pgxscan.Select(ctx, conn, &records, `
+	SELECT
+		thread_id,
+		seq,
+		event_type,
+		payload,
+		created_on
+	FROM THREAD_EVENT
+	WHERE thread_id = $1 AND seq > $2
+	ORDER BY seq ASC`,
+		threadId, after,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Append writes events after t.Seq and folds them into t. The stream of a
+// thread is keyed by (thread_id, seq): when another transaction appended
+// since t was loaded Append returns db.ErrVersionMisMatch, the caller reloads
+// and tries again. The first append opens the stream with ThreadStarted.
+func Append(ctx context.Context, conn db.Connection, t *Thread, events ...Event) error {
+	if t.Seq == 0 {
+		events = append([]Event{ThreadStarted{TotalComment: t.TotalComment, TotalReaction: t.TotalReaction}}, events...)
+	}
+
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		// DO NOTHING instead of a unique violation keeps the transaction
+		// usable, the caller decides to roll back
+		tag, err := conn.Exec(ctx, `
+		INSERT INTO THREAD_EVENT (
+			thread_id,
+			seq,
+			event_type,
+			payload,
+			created_on
+		) VALUES
+		($1,$2,$3,$4,$5)
+		ON CONFLICT (thread_id, seq) DO NOTHING`,
+			t.Id, t.Seq+1, e.EventType(), payload, time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() != 1 {
+			return db.ErrVersionMisMatch
+		}
+
+		e.apply(&t.Thread)
+		t.Seq++
+		if t.Seq%SnapshotEvery == 0 {
+			err = saveSnapshot(ctx, conn, *t)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func saveSnapshot(ctx context.Context, conn db.Connection, t Thread) error {
+	_, err := conn.Exec(ctx, `
+	INSERT INTO THREAD_SNAPSHOT (
+		thread_id,
+		seq,
+		total_comment,
+		total_reaction,
+		created_on
+	) VALUES
+	($1,$2,$3,$4,$5)
+	ON CONFLICT (thread_id) DO UPDATE SET
+		seq = EXCLUDED.seq,
+		total_comment = EXCLUDED.total_comment,
+		total_reaction = EXCLUDED.total_reaction,
+		created_on = EXCLUDED.created_on
+	WHERE THREAD_SNAPSHOT.seq < EXCLUDED.seq`,
+		t.Id, t.Seq, t.TotalComment, t.TotalReaction, time.Now(),
+	)
+	return err
+}
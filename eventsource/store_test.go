@@ -0,0 +1,154 @@
+package eventsource_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/eventsource"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestAppendConflict(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	userId, err := helper.CreateUser()
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(userId)
+	require.NoError(t, err)
+
+	first, err := eventsource.Load(ctx, conn, threadId)
+	require.NoError(t, err)
+	stale := first
+
+	require.NoError(t, eventsource.Append(ctx, conn, &first, eventsource.ReactionAdded{ReactionId: "r1"}))
+	assert.Equal(t, int64(2), first.Seq)
+	assert.Equal(t, 1, first.TotalReaction)
+
+	err = eventsource.Append(ctx, conn, &stale, eventsource.ReactionAdded{ReactionId: "r2"})
+	assert.ErrorIs(t, err, db.ErrVersionMisMatch)
+
+	loaded, err := eventsource.Load(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, first, loaded)
+}
+
+func TestEventSourcedThread(t *testing.T) {
+	ctx := context.Background()
+	snapshotEvery := eventsource.SnapshotEvery
+	eventsource.SnapshotEvery = 5
+	defer func() { eventsource.SnapshotEvery = snapshotEvery }()
+
+	authorId, err := helper.CreateUser()
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(authorId)
+	require.NoError(t, err)
+
+	const users = 20
+	var wg sync.WaitGroup
+	errs := make([]error, users)
+	for i := 0; i < users; i++ {
+		userId, err := helper.CreateUser()
+		require.NoError(t, err)
+		wg.Add(1)
+		go func(i int, userId string) {
+			defer wg.Done()
+			_, errs[i] = eventsource.AddReaction(ctx, threadId, userId)
+		}(i, userId)
+	}
+	wg.Wait()
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+
+	_, err = eventsource.AddComment(ctx, threadId, authorId, "first", nil)
+	require.NoError(t, err)
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	aggregate, err := eventsource.Load(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, succeeded, aggregate.TotalReaction)
+	assert.Equal(t, 1, aggregate.TotalComment)
+	assert.Equal(t, int64(succeeded+2), aggregate.Seq)
+
+	reactions, err := repository.CountThreadReactions(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, succeeded, reactions)
+
+	var snapshotSeq int64
+	require.NoError(t, conn.QueryRow(ctx, `SELECT seq FROM THREAD_SNAPSHOT WHERE thread_id = $1`, threadId).Scan(&snapshotSeq))
+	assert.Equal(t, aggregate.Seq-aggregate.Seq%5, snapshotSeq)
+
+	// THREAD lags behind until projected
+	thread, err := repository.GetThread(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Zero(t, thread.TotalReaction)
+
+	n, err := eventsource.Projector{}.ProjectOnce(ctx)
+	require.NoError(t, err)
+	assert.NotZero(t, n)
+
+	thread, err = repository.GetThread(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, succeeded, thread.TotalReaction)
+	assert.Equal(t, 1, thread.TotalComment)
+
+	require.NoError(t, eventsource.Project(ctx, threadId))
+	projected, err := repository.GetThread(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, thread.Version, projected.Version, "nothing new to project")
+}
+
+func TestProjectKeepsDirectIncrements(t *testing.T) {
+	ctx := context.Background()
+	authorId, err := helper.CreateUser()
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(authorId)
+	require.NoError(t, err)
+
+	_, err = eventsource.AddReaction(ctx, threadId, authorId)
+	require.NoError(t, err)
+	require.NoError(t, eventsource.Project(ctx, threadId))
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	// a comment counted on THREAD directly, outside of the stream
+	thread, err := repository.GetThread(ctx, conn, threadId)
+	require.NoError(t, err)
+	thread.TotalComment++
+	require.NoError(t, repository.Threads.Patch(ctx, conn, &thread, []string{"total_comment"}))
+
+	_, err = eventsource.AddReaction(ctx, threadId, authorId)
+	require.NoError(t, err)
+	require.NoError(t, eventsource.Project(ctx, threadId))
+
+	thread, err = repository.GetThread(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, 2, thread.TotalReaction)
+	assert.Equal(t, 1, thread.TotalComment)
+}
@@ -0,0 +1,91 @@
+package faultproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	sslRequestCode    = 80877103
+	gssEncRequestCode = 80877104
+	maxMessageSize    = 1 << 30
+)
+
+// message is one frontend message of the Postgres wire protocol, raw holds
+// the bytes exactly as read so they can be forwarded untouched.
+type message struct {
+	kind byte
+	raw  []byte
+}
+
+// readStartup reads an untyped message, sent before the session starts.
+func readStartup(r io.Reader) (message, bool, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return message{}, false, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length < 8 || length > maxMessageSize {
+		return message{}, false, errors.New("faultproxy: invalid startup message length")
+	}
+
+	raw := make([]byte, length)
+	copy(raw, header)
+	if _, err := io.ReadFull(r, raw[8:]); err != nil {
+		return message{}, false, err
+	}
+
+	code := binary.BigEndian.Uint32(header[4:])
+	encryption := code == sslRequestCode || code == gssEncRequestCode
+	return message{raw: raw}, encryption, nil
+}
+
+func readMessage(r io.Reader) (message, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return message{}, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length < 4 || length > maxMessageSize {
+		return message{}, errors.New("faultproxy: invalid message length")
+	}
+
+	raw := make([]byte, 1+length)
+	copy(raw, header)
+	if _, err := io.ReadFull(r, raw[5:]); err != nil {
+		return message{}, err
+	}
+
+	return message{kind: header[0], raw: raw}, nil
+}
+
+// sql returns the statement carried by a simple query ('Q') or a parse ('P')
+// message.
+func (m message) sql() (string, bool) {
+	if len(m.raw) < 5 {
+		return "", false
+	}
+	body := m.raw[5:]
+
+	switch m.kind {
+	case 'Q':
+		return cstring(body), true
+	case 'P':
+		i := bytes.IndexByte(body, 0)
+		if i < 0 {
+			return "", false
+		}
+		return cstring(body[i+1:]), true
+	default:
+		return "", false
+	}
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
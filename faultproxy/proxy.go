@@ -0,0 +1,396 @@
+package faultproxy
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault degrades every connection going through the proxy, it applies to
+// data forwarded after it is set.
+type Fault struct {
+	// Latency delays every chunk of data, in both directions.
+	Latency time.Duration
+	// Bandwidth limits each direction of each connection, in bytes per
+	// second. 0 means unlimited.
+	Bandwidth int
+	// Hang stops forwarding without closing anything, like a peer that
+	// vanished from the network (half-open connection).
+	Hang bool
+	// DropResponses forwards the client messages but discards every byte
+	// Postgres sends back.
+	DropResponses bool
+}
+
+type Action int
+
+const (
+	// Reset closes both sides of the connection, the client gets ECONNRESET.
+	Reset Action = iota
+	// Hang stops forwarding on the connection, see Fault.Hang.
+	Hang
+	// DropResponses discards what Postgres sends on the connection from now
+	// on.
+	DropResponses
+)
+
+// Rule applies Action to a connection when the client sends a statement
+// Match accepts, e.g. a "commit".
+type Rule struct {
+	Match func(sql string) bool
+	// Before applies the action before the statement reaches Postgres,
+	// otherwise Postgres receives it and the action hits its response.
+	Before bool
+	Action Action
+	// Times is how many statements trigger the rule, 0 means no limit.
+	Times int
+}
+
+// Statement matches sql equal to stmt, ignoring case, spaces and a trailing
+// semicolon.
+func Statement(stmt string) func(sql string) bool {
+	want := normalize(stmt)
+	return func(sql string) bool {
+		return normalize(sql) == want
+	}
+}
+
+func normalize(sql string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(sql), ";"))
+}
+
+// Proxy is an in-process TCP proxy in front of Postgres that injects faults.
+// It expects unencrypted connections (sslmode=disable).
+type Proxy struct {
+	upstream string
+	listener net.Listener
+
+	mu    sync.Mutex
+	fault Fault
+	rules []*rule
+	links map[*link]struct{}
+	timer []*time.Timer
+	wg    sync.WaitGroup
+}
+
+type rule struct {
+	Rule
+	fired int
+}
+
+func Start(upstream string) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		upstream: upstream,
+		listener: listener,
+		links:    make(map[*link]struct{}),
+	}
+	p.wg.Add(1)
+	go p.accept()
+	return p, nil
+}
+
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *Proxy) Port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (p *Proxy) Set(fault Fault) {
+	p.mu.Lock()
+	p.fault = fault
+	links := p.snapshot()
+	p.mu.Unlock()
+
+	if fault.Hang {
+		for _, l := range links {
+			l.hang()
+		}
+	}
+}
+
+// Clear removes the fault and the rules. Connections already hung or dropping
+// responses stay so, reset them to recover.
+func (p *Proxy) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.fault = Fault{}
+	p.rules = nil
+}
+
+func (p *Proxy) currentFault() Fault {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.fault
+}
+
+func (p *Proxy) AddRule(r Rule) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rules = append(p.rules, &rule{Rule: r})
+}
+
+// Reset resets every open connection.
+func (p *Proxy) Reset() {
+	p.mu.Lock()
+	links := p.snapshot()
+	p.mu.Unlock()
+
+	for _, l := range links {
+		l.reset()
+	}
+}
+
+// After runs fn once d elapsed, to script faults over time.
+func (p *Proxy) After(d time.Duration, fn func(p *Proxy)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.timer = append(p.timer, time.AfterFunc(d, func() { fn(p) }))
+}
+
+// Conns returns the number of open client connections.
+func (p *Proxy) Conns() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.links)
+}
+
+func (p *Proxy) Close() error {
+	err := p.listener.Close()
+
+	p.mu.Lock()
+	for _, t := range p.timer {
+		t.Stop()
+	}
+	links := p.snapshot()
+	p.mu.Unlock()
+
+	for _, l := range links {
+		l.close()
+	}
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) snapshot() []*link {
+	links := make([]*link, 0, len(p.links))
+	for l := range p.links {
+		links = append(links, l)
+	}
+	return links
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server, err := net.Dial("tcp", p.upstream)
+		if err != nil {
+			client.Close()
+			continue
+		}
+
+		l := &link{proxy: p, client: client, server: server, hung: make(chan struct{}), done: make(chan struct{})}
+		p.mu.Lock()
+		p.links[l] = struct{}{}
+		hang := p.fault.Hang
+		p.mu.Unlock()
+		if hang {
+			l.hang()
+		}
+
+		p.wg.Add(2)
+		go l.forwardClient()
+		go l.forwardServer()
+	}
+}
+
+// match returns the actions of the rules sql triggers.
+func (p *Proxy) match(sql string, before bool) []Action {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var actions []Action
+	for _, r := range p.rules {
+		if r.Before != before || (r.Times > 0 && r.fired >= r.Times) || !r.Match(sql) {
+			continue
+		}
+		r.fired++
+		actions = append(actions, r.Action)
+	}
+	return actions
+}
+
+type link struct {
+	proxy  *Proxy
+	client net.Conn
+	server net.Conn
+
+	mu       sync.Mutex
+	dropping bool
+	hungOnce sync.Once
+	hung     chan struct{}
+	doneOnce sync.Once
+	done     chan struct{}
+}
+
+func (l *link) hang() {
+	l.hungOnce.Do(func() { close(l.hung) })
+}
+
+func (l *link) isHung() bool {
+	select {
+	case <-l.hung:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *link) dropResponses() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.dropping = true
+}
+
+func (l *link) isDropping() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.dropping
+}
+
+func (l *link) reset() {
+	if tcp, ok := l.client.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	if tcp, ok := l.server.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	l.close()
+}
+
+func (l *link) close() {
+	l.doneOnce.Do(func() {
+		close(l.done)
+		l.client.Close()
+		l.server.Close()
+
+		l.proxy.mu.Lock()
+		delete(l.proxy.links, l)
+		l.proxy.mu.Unlock()
+	})
+}
+
+func (l *link) apply(actions []Action) bool {
+	for _, action := range actions {
+		switch action {
+		case Reset:
+			l.reset()
+			return false
+		case Hang:
+			l.hang()
+		case DropResponses:
+			l.dropResponses()
+		}
+	}
+	return true
+}
+
+// write sends b to w once the current fault allows it. It blocks for as long
+// as the link is hung and reports false once the link is closed.
+func (l *link) write(w io.Writer, b []byte, drop bool) bool {
+	fault := l.proxy.currentFault()
+	delay := fault.Latency
+	if fault.Bandwidth > 0 {
+		delay += time.Duration(len(b)) * time.Second / time.Duration(fault.Bandwidth)
+	}
+	if delay > 0 {
+		select {
+		case <-l.done:
+			return false
+		case <-time.After(delay):
+		}
+	}
+
+	if l.isHung() {
+		<-l.done
+		return false
+	}
+	if drop {
+		return true
+	}
+
+	_, err := w.Write(b)
+	return err == nil
+}
+
+func (l *link) forwardClient() {
+	defer l.proxy.wg.Done()
+	defer l.close()
+
+	for {
+		m, encryption, err := readStartup(l.client)
+		if err != nil || !l.write(l.server, m.raw, false) {
+			return
+		}
+		if !encryption {
+			break
+		}
+	}
+
+	for {
+		m, err := readMessage(l.client)
+		if err != nil {
+			return
+		}
+
+		sql, ok := m.sql()
+		if ok && !l.apply(l.proxy.match(sql, true)) {
+			return
+		}
+		if !l.write(l.server, m.raw, false) {
+			return
+		}
+		if ok && !l.apply(l.proxy.match(sql, false)) {
+			return
+		}
+	}
+}
+
+func (l *link) forwardServer() {
+	defer l.proxy.wg.Done()
+	defer l.close()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := l.server.Read(buf)
+		if n > 0 {
+			drop := l.isDropping() || l.proxy.currentFault().DropResponses
+			if !l.write(l.client, buf[:n], drop) {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package faultproxy_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/faultproxy"
+)
+
+// fakePostgres answers every typed message with "ok:" followed by the
+// statement, and records the statements it received.
+type fakePostgres struct {
+	listener net.Listener
+	received chan string
+}
+
+func startFakePostgres(t *testing.T) *fakePostgres {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakePostgres{listener: listener, received: make(chan string, 16)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakePostgres) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, binary.BigEndian.Uint32(header)-4)); err != nil {
+		return
+	}
+
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		sql := string(body[:len(body)-1])
+		f.received <- sql
+		if _, err := conn.Write([]byte("ok:" + sql + "\n")); err != nil {
+			return
+		}
+	}
+}
+
+func startup() []byte {
+	params := []byte("user\x00test\x00\x00")
+	msg := make([]byte, 8, 8+len(params))
+	binary.BigEndian.PutUint32(msg, uint32(8+len(params)))
+	binary.BigEndian.PutUint32(msg[4:], 196608)
+	return append(msg, params...)
+}
+
+func query(sql string) []byte {
+	msg := []byte{'Q', 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[1:], uint32(4+len(sql)+1))
+	msg = append(msg, sql...)
+	return append(msg, 0)
+}
+
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, p *faultproxy.Proxy) *client {
+	conn, err := net.Dial("tcp", p.Addr())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.Write(startup())
+	require.NoError(t, err)
+	return &client{conn, bufio.NewReader(conn)}
+}
+
+func (c *client) exec(sql string, timeout time.Duration) (string, error) {
+	if _, err := c.conn.Write(query(sql)); err != nil {
+		return "", err
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	return c.r.ReadString('\n')
+}
+
+func start(t *testing.T) (*fakePostgres, *faultproxy.Proxy) {
+	upstream := startFakePostgres(t)
+	p, err := faultproxy.Start(upstream.listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+	return upstream, p
+}
+
+func receivedNothing(t *testing.T, upstream *fakePostgres) {
+	select {
+	case sql := <-upstream.received:
+		t.Fatalf("postgres received %q", sql)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProxyForwards(t *testing.T) {
+	upstream, p := start(t)
+	c := dial(t, p)
+
+	got, err := c.exec("select 1", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "ok:select 1\n", got)
+	assert.Equal(t, "select 1", <-upstream.received)
+	assert.Equal(t, 1, p.Conns())
+}
+
+func TestProxyLatencyAndBandwidth(t *testing.T) {
+	_, p := start(t)
+	c := dial(t, p)
+
+	p.Set(faultproxy.Fault{Latency: 50 * time.Millisecond})
+	begin := time.Now()
+	_, err := c.exec("select 1", time.Second)
+	require.NoError(t, err)
+	// once on the way in and once on the way out
+	assert.GreaterOrEqual(t, time.Since(begin), 100*time.Millisecond)
+
+	p.Set(faultproxy.Fault{Bandwidth: 100})
+	begin = time.Now()
+	_, err = c.exec("select 1", time.Second)
+	require.NoError(t, err)
+	// 14 bytes of query and 13 of response at 100 bytes/s
+	assert.GreaterOrEqual(t, time.Since(begin), 250*time.Millisecond)
+}
+
+func TestProxyResetAfterCommit(t *testing.T) {
+	upstream, p := start(t)
+	c := dial(t, p)
+	p.AddRule(faultproxy.Rule{Match: faultproxy.Statement("COMMIT"), Action: faultproxy.Reset, Times: 1})
+
+	_, err := c.exec("begin", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "begin", <-upstream.received)
+
+	_, err = c.exec("commit;", time.Second)
+	require.Error(t, err)
+	// postgres got the commit, the client cannot know whether it applied
+	assert.Equal(t, "commit;", <-upstream.received)
+
+	// Times limits the rule to the first commit
+	c = dial(t, p)
+	got, err := c.exec("commit", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "ok:commit\n", got)
+}
+
+func TestProxyResetBeforeCommit(t *testing.T) {
+	upstream, p := start(t)
+	c := dial(t, p)
+	p.AddRule(faultproxy.Rule{Match: faultproxy.Statement("commit"), Before: true, Action: faultproxy.Reset})
+
+	_, err := c.exec("commit", time.Second)
+	require.Error(t, err)
+	receivedNothing(t, upstream)
+}
+
+func TestProxyDropResponses(t *testing.T) {
+	upstream, p := start(t)
+	c := dial(t, p)
+	p.AddRule(faultproxy.Rule{Match: faultproxy.Statement("commit"), Action: faultproxy.DropResponses})
+
+	_, err := c.exec("commit", 100*time.Millisecond)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Equal(t, "commit", <-upstream.received)
+}
+
+func TestProxyHang(t *testing.T) {
+	upstream, p := start(t)
+	c := dial(t, p)
+	_, err := c.exec("select 1", time.Second)
+	require.NoError(t, err)
+	<-upstream.received
+
+	p.Set(faultproxy.Fault{Hang: true})
+	_, err = c.exec("select 2", 100*time.Millisecond)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	receivedNothing(t, upstream)
+
+	// the connection is still open, like a half-open TCP connection
+	assert.Equal(t, 1, p.Conns())
+}
+
+func TestProxySchedule(t *testing.T) {
+	_, p := start(t)
+	c := dial(t, p)
+	p.After(50*time.Millisecond, (*faultproxy.Proxy).Reset)
+
+	_, err := c.exec("select 1", time.Second)
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = c.exec("select 2", time.Second)
+	require.Error(t, err)
+	assert.Equal(t, 0, p.Conns())
+}
+
+func TestProxyMatchPreparedStatement(t *testing.T) {
+	upstream, p := start(t)
+	c := dial(t, p)
+	p.AddRule(faultproxy.Rule{Match: faultproxy.Statement("update thread set total_reaction = $1"), Before: true, Action: faultproxy.Reset})
+
+	// Parse: statement name, query, no parameter types
+	body := append([]byte("stmt\x00update thread set total_reaction = $1\x00"), 0, 0)
+	msg := []byte{'P', 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[1:], uint32(4+len(body)))
+	_, err := c.conn.Write(append(msg, body...))
+	require.NoError(t, err)
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.r.ReadString('\n')
+	require.Error(t, err)
+	receivedNothing(t, upstream)
+}
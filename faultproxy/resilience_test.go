@@ -0,0 +1,243 @@
+package faultproxy_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/faultproxy"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+var proxy *faultproxy.Proxy
+
+func init() {
+	config.Get("../.env")
+}
+
+// every connection of the db pool in this package goes through proxy
+func TestMain(m *testing.M) {
+	dbtest.MainWith(m, func(ctx context.Context, d *db.DB) (*db.DB, error) {
+		c := d.Config()
+		var err error
+		proxy, err = faultproxy.Start(net.JoinHostPort(c.ConnConfig.Host, strconv.Itoa(int(c.ConnConfig.Port))))
+		if err != nil {
+			return nil, err
+		}
+
+		c.ConnConfig.Host = "127.0.0.1"
+		c.ConnConfig.Port = uint16(proxy.Port())
+		p, err := pgxpool.NewWithConfig(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return &db.DB{Pool: p}, nil
+	})
+}
+
+func heal(t *testing.T) {
+	t.Cleanup(func() {
+		proxy.Clear()
+		proxy.Reset()
+	})
+}
+
+func newThread(t *testing.T) repository.Thread {
+	userId, err := helper.CreateUser()
+	require.NoError(t, err)
+
+	return repository.Thread{
+		Id:        helper.ThreadId(),
+		Title:     "faulty",
+		Body:      "faulty",
+		CreatedBy: userId,
+		CreatedOn: time.Now(),
+		Version:   1,
+	}
+}
+
+func threadExists(t *testing.T, id string) bool {
+	conn, err := db.GetConnection(context.Background())
+	require.NoError(t, err)
+	defer conn.Release()
+
+	_, err = repository.GetThread(context.Background(), conn, id)
+	return err == nil
+}
+
+func TestCommitOutcome(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      faultproxy.Rule
+		timeout   time.Duration
+		atomic    func(ctx context.Context, txOpt pgx.TxOptions, cb func(tx db.Connection) error) error
+		attempts  int
+		committed bool
+	}{
+		{
+			name:      "reset once postgres got the commit: error but committed",
+			rule:      faultproxy.Rule{Match: faultproxy.Statement("commit"), Action: faultproxy.Reset, Times: 1},
+			atomic:    db.Atomic,
+			attempts:  1,
+			committed: true,
+		},
+		{
+			name:      "reset before the commit: error and rolled back",
+			rule:      faultproxy.Rule{Match: faultproxy.Statement("commit"), Before: true, Action: faultproxy.Reset, Times: 1},
+			atomic:    db.Atomic,
+			attempts:  1,
+			committed: false,
+		},
+		{
+			name:      "auto retry does not retry a broken connection",
+			rule:      faultproxy.Rule{Match: faultproxy.Statement("commit"), Action: faultproxy.Reset, Times: 1},
+			atomic:    db.AtomicWithAutoRetry,
+			attempts:  1,
+			committed: true,
+		},
+		{
+			name:      "commit response lost: timeout but committed",
+			rule:      faultproxy.Rule{Match: faultproxy.Statement("commit"), Action: faultproxy.DropResponses, Times: 1},
+			timeout:   500 * time.Millisecond,
+			atomic:    db.AtomicWithAutoRetry,
+			attempts:  1,
+			committed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heal(t)
+			thread := newThread(t)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			proxy.AddRule(tt.rule)
+			attempts := 0
+			err := tt.atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+				attempts++
+				return repository.CreateThread(ctx, tx, thread)
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.attempts, attempts)
+
+			proxy.Clear()
+			assert.Equal(t, tt.committed, threadExists(t, thread.Id))
+		})
+	}
+}
+
+func TestCommitVerification(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     faultproxy.Rule
+		timeout  time.Duration
+		atomic   func(ctx context.Context, txOpt pgx.TxOptions, cb func(tx db.Connection) error) error
+		attempts int
+		wantErr  error
+	}{
+		{
+			name:     "reset once postgres got the commit is verified as committed",
+			rule:     faultproxy.Rule{Match: faultproxy.Statement("commit"), Action: faultproxy.Reset, Times: 1},
+			atomic:   db.Atomic,
+			attempts: 1,
+		},
+		{
+			name:     "lost commit response is verified as committed",
+			rule:     faultproxy.Rule{Match: faultproxy.Statement("commit"), Action: faultproxy.DropResponses, Times: 1},
+			timeout:  500 * time.Millisecond,
+			atomic:   db.AtomicWithAutoRetry,
+			attempts: 1,
+		},
+		{
+			name:     "reset before the commit is verified as aborted",
+			rule:     faultproxy.Rule{Match: faultproxy.Statement("commit"), Before: true, Action: faultproxy.Reset, Times: 1},
+			atomic:   db.Atomic,
+			attempts: 1,
+			wantErr:  db.ErrCommitAborted,
+		},
+		{
+			name:     "auto retry runs again once the commit is verified as aborted",
+			rule:     faultproxy.Rule{Match: faultproxy.Statement("commit"), Before: true, Action: faultproxy.Reset, Times: 1},
+			atomic:   db.AtomicWithAutoRetry,
+			attempts: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heal(t)
+			thread := newThread(t)
+
+			ctx := db.WithCommitVerification(context.Background())
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			proxy.AddRule(tt.rule)
+			attempts := 0
+			err := tt.atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+				attempts++
+				return repository.CreateThread(ctx, tx, thread)
+			})
+			assert.Equal(t, tt.attempts, attempts)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				assert.False(t, threadExists(t, thread.Id))
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, threadExists(t, thread.Id))
+		})
+	}
+}
+
+func TestAtomicOnHungConnection(t *testing.T) {
+	heal(t)
+	thread := newThread(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		proxy.Set(faultproxy.Fault{Hang: true})
+		return repository.CreateThread(ctx, tx, thread)
+	})
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	proxy.Clear()
+	proxy.Reset()
+	assert.False(t, threadExists(t, thread.Id))
+}
+
+func TestAtomicUnderLatency(t *testing.T) {
+	heal(t)
+	thread := newThread(t)
+
+	proxy.Set(faultproxy.Fault{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	err := db.Atomic(context.Background(), pgx.TxOptions{}, func(tx db.Connection) error {
+		return repository.CreateThread(context.Background(), tx, thread)
+	})
+	require.NoError(t, err)
+	// begin, insert and commit each pay the latency both ways
+	assert.GreaterOrEqual(t, time.Since(start), 120*time.Millisecond)
+	assert.True(t, threadExists(t, thread.Id))
+}
@@ -0,0 +1,255 @@
+package fixture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+// Set is the rows of one or more fixture files.
+type Set struct {
+	Accounts  []repository.Account
+	Threads   []repository.Thread
+	Reactions []repository.Reaction
+}
+
+// Add appends the rows of other to s.
+func (s *Set) Add(other Set) {
+	s.Accounts = append(s.Accounts, other.Accounts...)
+	s.Threads = append(s.Threads, other.Threads...)
+	s.Reactions = append(s.Reactions, other.Reactions...)
+}
+
+// Load copies the rows of s, accounts then threads then reactions so the
+// foreign keys hold. Rows are written as they are: thread counters are not
+// bumped and no outbox event is emitted. Run it in a transaction to get all
+// or none of them.
+func Load(ctx context.Context, conn db.Connection, s Set) error {
+	_, err := repository.Accounts.CopyFrom(ctx, conn, s.Accounts)
+	if err != nil {
+		return fmt.Errorf("fixture: copy accounts: %w", err)
+	}
+	_, err = repository.Threads.CopyFrom(ctx, conn, s.Threads)
+	if err != nil {
+		return fmt.Errorf("fixture: copy threads: %w", err)
+	}
+	_, err = repository.Reactions.CopyFrom(ctx, conn, s.Reactions)
+	if err != nil {
+		return fmt.Errorf("fixture: copy reactions: %w", err)
+	}
+	return nil
+}
+
+// ReadFile reads a .jsonl or .csv fixture file.
+func ReadFile(path string) (Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Set{}, err
+	}
+	defer f.Close()
+
+	var s Set
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl":
+		s, err = ReadJSONL(f)
+	case ".csv":
+		s, err = ReadCSV(f)
+	default:
+		return Set{}, fmt.Errorf("fixture: %s: want a .jsonl or .csv file", path)
+	}
+	if err != nil {
+		return Set{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ReadJSONL reads one row per line, an object holding the kind of the row,
+// account, thread or reaction, and its columns by name. Blank lines are
+// skipped.
+func ReadJSONL(r io.Reader) (Set, error) {
+	var s Set
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		d := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		d.UseNumber()
+		var object map[string]any
+		if err := d.Decode(&object); err != nil {
+			return Set{}, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		values := make(map[string]string, len(object))
+		for column, v := range object {
+			switch v := v.(type) {
+			case nil:
+			case string:
+				values[column] = v
+			case json.Number:
+				values[column] = v.String()
+			case bool:
+				values[column] = strconv.FormatBool(v)
+			default:
+				return Set{}, fmt.Errorf("line %d: column %s: want a string, number, boolean or null", line, column)
+			}
+		}
+
+		if err := s.add(values); err != nil {
+			return Set{}, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Set{}, err
+	}
+	return s, nil
+}
+
+// ReadCSV reads rows under a header naming their columns, one of which is the
+// kind of the row. Rows of different kinds can share the file, leaving the
+// cells of the columns they lack empty.
+func ReadCSV(r io.Reader) (Set, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return Set{}, fmt.Errorf("header: %w", err)
+	}
+
+	var s Set
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return s, nil
+		}
+		if err != nil {
+			return Set{}, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		values := make(map[string]string, len(header))
+		for i, column := range header {
+			if record[i] != "" {
+				values[column] = record[i]
+			}
+		}
+
+		if err := s.add(values); err != nil {
+			return Set{}, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+// add decodes the row of values, by column name, into s. Missing columns are
+// left zero, but for the id which is generated and the version which is 1.
+func (s *Set) add(values map[string]string) error {
+	kind := values["kind"]
+	delete(values, "kind")
+
+	switch kind {
+	case "account":
+		v := repository.Account{Id: helper.AccountId(), Version: 1}
+		if err := decode(&v, values); err != nil {
+			return err
+		}
+		s.Accounts = append(s.Accounts, v)
+	case "thread":
+		v := repository.Thread{Id: helper.ThreadId(), Version: 1}
+		if err := decode(&v, values); err != nil {
+			return err
+		}
+		s.Threads = append(s.Threads, v)
+	case "reaction":
+		v := repository.Reaction{Id: helper.ReactionId(), Version: 1}
+		if err := decode(&v, values); err != nil {
+			return err
+		}
+		s.Reactions = append(s.Reactions, v)
+	case "":
+		return errors.New("missing kind")
+	default:
+		return fmt.Errorf("unknown kind %q, want account, thread or reaction", kind)
+	}
+	return nil
+}
+
+// decode sets the fields of v, a pointer to a struct, from values by their db
+// tag.
+func decode(v any, values map[string]string) error {
+	rv := reflect.ValueOf(v).Elem()
+	t := rv.Type()
+
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		fields[t.Field(i).Tag.Get("db")] = i
+	}
+
+	for column, value := range values {
+		i, ok := fields[column]
+		if !ok || column == "" || column == "-" {
+			return fmt.Errorf("unknown column %s", column)
+		}
+		if err := set(rv.Field(i), value); err != nil {
+			return fmt.Errorf("column %s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func set(v reflect.Value, value string) error {
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err := set(p.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+
+	if v.Type() == timeType {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("cannot decode into %s", v.Type())
+	}
+	return nil
+}
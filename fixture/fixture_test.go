@@ -0,0 +1,127 @@
+package fixture_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/fixture"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestReadFile(t *testing.T) {
+	jsonl, err := fixture.ReadFile("testdata/seed.jsonl")
+	require.NoError(t, err)
+	require.Len(t, jsonl.Accounts, 2)
+	require.Len(t, jsonl.Threads, 1)
+	require.Len(t, jsonl.Reactions, 1)
+
+	assert.Equal(t, "account_fixture1", jsonl.Accounts[0].Id)
+	assert.Equal(t, "alice", *jsonl.Accounts[0].Username)
+	assert.Nil(t, jsonl.Accounts[1].Email)
+	assert.Equal(t, 1, jsonl.Accounts[0].Version)
+	assert.Equal(t, 1, jsonl.Threads[0].TotalReaction)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), jsonl.Threads[0].CreatedOn)
+	assert.True(t, strings.HasPrefix(jsonl.Reactions[0].Id, "reaction_"))
+	assert.Equal(t, "thread_fixture1", *jsonl.Reactions[0].ThreadId)
+	assert.Nil(t, jsonl.Reactions[0].CommentId)
+
+	csv, err := fixture.ReadFile("testdata/seed.csv")
+	require.NoError(t, err)
+	require.Len(t, csv.Accounts, 1)
+	require.Len(t, csv.Threads, 1)
+	require.Len(t, csv.Reactions, 1)
+	assert.Equal(t, "carol", *csv.Accounts[0].Username)
+	assert.Nil(t, csv.Accounts[0].Email)
+	assert.Equal(t, "hi", csv.Threads[0].Title)
+	assert.Equal(t, "love", csv.Reactions[0].Content)
+
+	var set fixture.Set
+	set.Add(jsonl)
+	set.Add(csv)
+	assert.Len(t, set.Accounts, 3)
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		jsonl string
+		csv   string
+		err   string
+	}{
+		{name: "missing kind", jsonl: `{"id": "x"}`, err: "line 1: missing kind"},
+		{name: "unknown kind", jsonl: `{"kind": "comment"}`, err: `unknown kind "comment"`},
+		{name: "unknown column", jsonl: "\n" + `{"kind": "account", "nickname": "x"}`, err: "line 2: unknown column nickname"},
+		{name: "bad number", jsonl: `{"kind": "thread", "total_reaction": "many"}`, err: "column total_reaction"},
+		{name: "nested value", jsonl: `{"kind": "account", "username": {}}`, err: "column username"},
+		{name: "csv bad boolean", csv: "kind,is_deleted\naccount,maybe\n", err: "line 2: column is_deleted"},
+		{name: "csv bad time", csv: "kind,created_on\nthread,yesterday\n", err: "column created_on"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.csv != "" {
+				_, err = fixture.ReadCSV(strings.NewReader(tt.csv))
+			} else {
+				_, err = fixture.ReadJSONL(strings.NewReader(tt.jsonl))
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+
+	_, err := fixture.ReadFile("testdata/seed.txt")
+	assert.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	dbtest.Isolate(t)
+
+	var set fixture.Set
+	for _, path := range []string{"testdata/seed.jsonl", "testdata/seed.csv"} {
+		s, err := fixture.ReadFile(path)
+		require.NoError(t, err)
+		set.Add(s)
+	}
+
+	err := db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		return fixture.Load(ctx, tx, set)
+	})
+	require.NoError(t, err)
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	thread, err := repository.GetThread(ctx, conn, "thread_fixture1")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", thread.Title)
+	assert.Equal(t, 1, thread.TotalReaction)
+
+	count, err := repository.CountThreadReactions(ctx, conn, "thread_fixture2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// the fixtures are loaded as a whole or not at all
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		return fixture.Load(ctx, tx, set)
+	})
+	assert.Error(t, err)
+}
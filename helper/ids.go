@@ -25,3 +25,11 @@ func ReactionId() string {
 func FakeTableId() string {
 	return "ft_" + nanoid()
 }
+
+func JobId() string {
+	return "job_" + nanoid()
+}
+
+func SagaId() string {
+	return "saga_" + nanoid()
+}
@@ -38,6 +38,42 @@ func CreateUser() (string, error) {
 	return userId, nil
 }
 
+// CreateUsers creates n accounts with a single COPY. They share one password
+// hash, bcrypt is what makes CreateUser slow. Usernames carry the account id
+// since a single duplicate would fail the whole COPY.
+func CreateUsers(n int) ([]string, error) {
+	conn, err := db.GetConnection(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(faker.Password()), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+
+	userIds := make([]string, n)
+	accounts := make([]repository.Account, n)
+	for i := range accounts {
+		userIds[i] = AccountId()
+		accounts[i] = repository.Account{
+			Id:             userIds[i],
+			Username:       ToPointer(faker.Username() + "_" + userIds[i]),
+			HashedPassword: string(hashed),
+			CreatedOn:      time.Now(),
+			Version:        1,
+		}
+	}
+
+	_, err = repository.Accounts.CopyFrom(context.Background(), conn, accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	return userIds, nil
+}
+
 func CreateThread(userId string) (string, error) {
 	conn, err := db.GetConnection(context.Background())
 	if err != nil {
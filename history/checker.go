@@ -0,0 +1,366 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type EdgeKind string
+
+const (
+	WW EdgeKind = "ww"
+	WR EdgeKind = "wr"
+	RW EdgeKind = "rw"
+)
+
+// Edge is a dependency From -> To on one item: To overwrote (ww) or read (wr)
+// a version From installed, or From read a version that To overwrote (rw).
+type Edge struct {
+	From  int
+	To    int
+	Kind  EdgeKind
+	Table string
+	Key   string
+	// FromVersion is the version From installed (ww, wr) or read (rw);
+	// ToVersion is the version To installed (ww, rw) or read (wr).
+	FromVersion int64
+	ToVersion   int64
+}
+
+type AnomalyKind string
+
+const (
+	G0         AnomalyKind = "G0"
+	G1c        AnomalyKind = "G1c"
+	G2Item     AnomalyKind = "G2-item"
+	LostUpdate AnomalyKind = "lost-update"
+)
+
+type Anomaly struct {
+	Kind        AnomalyKind
+	Txns        []string
+	Edges       []Edge
+	Explanation string
+}
+
+type Report struct {
+	Anomalies []Anomaly
+}
+
+func (r Report) Has(kind AnomalyKind) bool {
+	for _, a := range r.Anomalies {
+		if a.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Report) String() string {
+	if len(r.Anomalies) == 0 {
+		return "no anomaly found, history is serializable"
+	}
+
+	var b strings.Builder
+	for i, a := range r.Anomalies {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s between %s:\n%s", a.Kind, strings.Join(a.Txns, ", "), a.Explanation)
+	}
+	return b.String()
+}
+
+type item struct {
+	table string
+	key   string
+}
+
+type access struct {
+	txn     int
+	version int64
+}
+
+// Check builds the direct serialization graph (Adya) of the committed
+// transactions, using the per-row version column as the version order, and
+// reports the dependency cycles as G0 (ww only), G1c (through a wr edge) or
+// G2-item (through an rw edge), once per level and strongly connected
+// component, plus the lost-update pattern of two transactions that read the
+// same version of an item and both overwrote it.
+func Check(h History) Report {
+	names := make(map[int]string)
+	installs := make(map[item][]access)
+	reads := make(map[item][]access)
+	for _, txn := range h.Txns {
+		if !txn.Committed {
+			continue
+		}
+		names[txn.Id] = txn.Name
+
+		for _, op := range txn.Ops {
+			if op.Version == 0 || op.Key == "" {
+				continue
+			}
+			it := item{op.Table, op.Key}
+			if op.Kind == Write {
+				installs[it] = append(installs[it], access{txn.Id, op.Version})
+			} else {
+				reads[it] = append(reads[it], access{txn.Id, op.Version})
+			}
+		}
+	}
+
+	var edges []Edge
+	for it, ws := range installs {
+		sort.Slice(ws, func(i, j int) bool { return ws[i].version < ws[j].version })
+		for i := 1; i < len(ws); i++ {
+			if ws[i-1].txn != ws[i].txn {
+				edges = append(edges, Edge{ws[i-1].txn, ws[i].txn, WW, it.table, it.key, ws[i-1].version, ws[i].version})
+			}
+		}
+	}
+	for it, rs := range reads {
+		ws := installs[it]
+		for _, r := range rs {
+			for _, w := range ws {
+				if w.version == r.version && w.txn != r.txn {
+					edges = append(edges, Edge{w.txn, r.txn, WR, it.table, it.key, w.version, r.version})
+				}
+			}
+			for _, w := range ws {
+				if w.version > r.version {
+					if w.txn != r.txn {
+						edges = append(edges, Edge{r.txn, w.txn, RW, it.table, it.key, r.version, w.version})
+					}
+					break
+				}
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Table+edges[i].Key != edges[j].Table+edges[j].Key {
+			return edges[i].Table+edges[i].Key < edges[j].Table+edges[j].Key
+		}
+		return edges[i].ToVersion < edges[j].ToVersion
+	})
+
+	var report Report
+	for _, level := range []struct {
+		kind  AnomalyKind
+		allow map[EdgeKind]bool
+		// through is the edge kind a cycle needs to be of this level rather
+		// than of the one before
+		through EdgeKind
+	}{
+		{G0, map[EdgeKind]bool{WW: true}, WW},
+		{G1c, map[EdgeKind]bool{WW: true, WR: true}, WR},
+		{G2Item, map[EdgeKind]bool{WW: true, WR: true, RW: true}, RW},
+	} {
+		var subset []Edge
+		for _, e := range edges {
+			if level.allow[e.Kind] {
+				subset = append(subset, e)
+			}
+		}
+
+		for _, cycle := range cycles(subset, level.through) {
+			report.Anomalies = append(report.Anomalies, anomaly(level.kind, cycle, names))
+		}
+	}
+
+	report.Anomalies = append(report.Anomalies, lostUpdates(installs, reads, names)...)
+
+	return report
+}
+
+func lostUpdates(installs, reads map[item][]access, names map[int]string) []Anomaly {
+	var anomalies []Anomaly
+	for it, rs := range reads {
+		readersOf := make(map[int64][]int)
+		for _, r := range rs {
+			readersOf[r.version] = append(readersOf[r.version], r.txn)
+		}
+
+		for version, readers := range readersOf {
+			var writers []access
+			for _, txn := range readers {
+				for _, w := range installs[it] {
+					if w.txn == txn && w.version > version {
+						writers = append(writers, w)
+						break
+					}
+				}
+			}
+			if len(writers) < 2 {
+				continue
+			}
+			sort.Slice(writers, func(i, j int) bool { return writers[i].version < writers[j].version })
+
+			var (
+				txns  []string
+				lines []string
+			)
+			for _, w := range writers {
+				txns = append(txns, names[w.txn])
+				lines = append(lines, fmt.Sprintf("  %s read %s %s@v%d and installed v%d", names[w.txn], it.table, it.key, version, w.version))
+			}
+			lines = append(lines, fmt.Sprintf("  every write after the first is based on v%d and silently discards the updates before it", version))
+
+			anomalies = append(anomalies, Anomaly{
+				Kind:        LostUpdate,
+				Txns:        txns,
+				Explanation: strings.Join(lines, "\n"),
+			})
+		}
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Explanation < anomalies[j].Explanation })
+	return anomalies
+}
+
+func anomaly(kind AnomalyKind, cycle []Edge, names map[int]string) Anomaly {
+	var (
+		txns  []string
+		lines []string
+	)
+	for _, e := range cycle {
+		txns = append(txns, names[e.From])
+		lines = append(lines, "  "+describe(e, names))
+	}
+
+	return Anomaly{
+		Kind:        kind,
+		Txns:        txns,
+		Edges:       cycle,
+		Explanation: strings.Join(lines, "\n"),
+	}
+}
+
+func describe(e Edge, names map[int]string) string {
+	from, to := names[e.From], names[e.To]
+	switch e.Kind {
+	case WW:
+		return fmt.Sprintf("%s -ww-> %s: %s overwrote %s %s@v%d installed by %s with v%d", from, to, to, e.Table, e.Key, e.FromVersion, from, e.ToVersion)
+	case WR:
+		return fmt.Sprintf("%s -wr-> %s: %s read %s %s@v%d installed by %s", from, to, to, e.Table, e.Key, e.FromVersion, from)
+	default:
+		return fmt.Sprintf("%s -rw-> %s: %s read %s %s@v%d but %s installed the next version v%d", from, to, from, e.Table, e.Key, e.FromVersion, to, e.ToVersion)
+	}
+}
+
+// cycles returns, for every strongly connected component of more than one
+// transaction, an elementary cycle through an edge of kind through when the
+// component has one. A component thus shows up at every level whose edge kind
+// it contains, whatever the level its other cycles are of.
+func cycles(edges []Edge, through EdgeKind) [][]Edge {
+	adj := make(map[int][]Edge)
+	nodes := make(map[int]bool)
+	for _, e := range edges {
+		adj[e.From] = append(adj[e.From], e)
+		nodes[e.From] = true
+		nodes[e.To] = true
+	}
+
+	ordered := make([]int, 0, len(nodes))
+	for n := range nodes {
+		ordered = append(ordered, n)
+	}
+	sort.Ints(ordered)
+
+	var (
+		index    = make(map[int]int)
+		low      = make(map[int]int)
+		onStack  = make(map[int]bool)
+		stack    []int
+		counter  int
+		result   [][]Edge
+		strongly func(v int)
+	)
+	strongly = func(v int) {
+		index[v] = counter
+		low[v] = counter
+		counter++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, e := range adj[v] {
+			if _, seen := index[e.To]; !seen {
+				strongly(e.To)
+				low[v] = min(low[v], low[e.To])
+			} else if onStack[e.To] {
+				low[v] = min(low[v], index[e.To])
+			}
+		}
+
+		if low[v] != index[v] {
+			return
+		}
+		component := make(map[int]bool)
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component[w] = true
+			if w == v {
+				break
+			}
+		}
+		if len(component) > 1 {
+			if cycle := cycleThrough(through, component, edges, adj); cycle != nil {
+				result = append(result, cycle)
+			}
+		}
+	}
+	for _, n := range ordered {
+		if _, seen := index[n]; !seen {
+			strongly(n)
+		}
+	}
+
+	return result
+}
+
+// cycleThrough closes the first edge of kind inside component with the
+// shortest path back to where it starts. Every edge inside a strongly
+// connected component is on some cycle.
+func cycleThrough(kind EdgeKind, component map[int]bool, edges []Edge, adj map[int][]Edge) []Edge {
+	for _, e := range edges {
+		if e.Kind != kind || !component[e.From] || !component[e.To] {
+			continue
+		}
+		if path := pathIn(e.To, e.From, component, adj); path != nil {
+			return append([]Edge{e}, path...)
+		}
+	}
+	return nil
+}
+
+// pathIn finds the shortest path from one transaction to another inside
+// component.
+func pathIn(from, to int, component map[int]bool, adj map[int][]Edge) []Edge {
+	via := make(map[int]Edge)
+	queue := []int{from}
+	visited := map[int]bool{from: true}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, e := range adj[v] {
+			if !component[e.To] || visited[e.To] {
+				continue
+			}
+			visited[e.To] = true
+			via[e.To] = e
+			if e.To == to {
+				var path []Edge
+				for n := to; n != from; n = via[n].From {
+					path = append([]Edge{via[n]}, path...)
+				}
+				return path
+			}
+			queue = append(queue, e.To)
+		}
+	}
+
+	return nil
+}
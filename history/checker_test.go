@@ -0,0 +1,123 @@
+package history_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/history"
+)
+
+func r(key string, version int64) history.Op {
+	return history.Op{Kind: history.Read, Table: "THREAD", Key: key, Version: version}
+}
+
+func w(key string, version int64) history.Op {
+	return history.Op{Kind: history.Write, Table: "THREAD", Key: key, Version: version}
+}
+
+func committed(id int, ops ...history.Op) history.Txn {
+	return history.Txn{Id: id, Name: "T" + string(rune('1'+id)), Ops: ops, Committed: true, Finished: true}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		txns  []history.Txn
+		want  []history.AnomalyKind
+		cycle []history.EdgeKind
+	}{
+		{
+			name: "serial history",
+			txns: []history.Txn{
+				committed(0, r("x", 1), w("x", 2)),
+				committed(1, r("x", 2), w("x", 3)),
+			},
+		},
+		{
+			name: "lost update",
+			txns: []history.Txn{
+				committed(0, r("x", 1), w("x", 2)),
+				committed(1, r("x", 1), w("x", 3)),
+			},
+			want:  []history.AnomalyKind{history.G2Item, history.LostUpdate},
+			cycle: []history.EdgeKind{history.WW, history.RW},
+		},
+		{
+			name: "write skew",
+			txns: []history.Txn{
+				committed(0, r("x", 1), r("y", 1), w("x", 2)),
+				committed(1, r("x", 1), r("y", 1), w("y", 2)),
+			},
+			want:  []history.AnomalyKind{history.G2Item},
+			cycle: []history.EdgeKind{history.RW, history.RW},
+		},
+		{
+			name: "dirty write",
+			txns: []history.Txn{
+				committed(0, w("x", 2), w("y", 3)),
+				committed(1, w("x", 3), w("y", 2)),
+			},
+			want:  []history.AnomalyKind{history.G0},
+			cycle: []history.EdgeKind{history.WW, history.WW},
+		},
+		{
+			name: "circular information flow",
+			txns: []history.Txn{
+				committed(0, w("x", 2), r("y", 2)),
+				committed(1, w("y", 2), r("x", 2)),
+			},
+			want:  []history.AnomalyKind{history.G1c},
+			cycle: []history.EdgeKind{history.WR, history.WR},
+		},
+		{
+			name: "dirty write and write skew between the same transactions",
+			txns: []history.Txn{
+				committed(0, w("x", 2), w("y", 3), r("z", 1), w("q", 2)),
+				committed(1, w("x", 3), w("y", 2), r("q", 1), w("z", 2)),
+			},
+			want:  []history.AnomalyKind{history.G0, history.G2Item},
+			cycle: []history.EdgeKind{history.WW, history.WW},
+		},
+		{
+			name: "aborted transaction is ignored",
+			txns: []history.Txn{
+				committed(0, r("x", 1), w("x", 2)),
+				{Id: 1, Name: "T2", Ops: []history.Op{r("x", 1), w("x", 3)}, Finished: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := history.Check(history.History{Txns: tt.txns})
+
+			var kinds []history.AnomalyKind
+			for _, a := range report.Anomalies {
+				kinds = append(kinds, a.Kind)
+				assert.NotEmpty(t, a.Explanation)
+			}
+			assert.Equal(t, tt.want, kinds, report.String())
+
+			if tt.cycle == nil {
+				return
+			}
+			require.NotEmpty(t, report.Anomalies)
+			var edges []history.EdgeKind
+			for _, e := range report.Anomalies[0].Edges {
+				edges = append(edges, e.Kind)
+			}
+			assert.ElementsMatch(t, tt.cycle, edges)
+		})
+	}
+}
+
+func TestReportString(t *testing.T) {
+	report := history.Check(history.History{Txns: []history.Txn{
+		committed(0, r("x", 1), w("x", 2)),
+		committed(1, r("x", 1), w("x", 3)),
+	}})
+
+	assert.Contains(t, report.String(), "T1 -ww-> T2: T2 overwrote THREAD x@v2 installed by T1 with v3")
+	assert.Contains(t, report.String(), "T2 -rw-> T1: T2 read THREAD x@v1 but T1 installed the next version v2")
+	assert.Equal(t, "no anomaly found, history is serializable", history.Check(history.History{}).String())
+}
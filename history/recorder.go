@@ -0,0 +1,250 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+type OpKind string
+
+const (
+	Read  OpKind = "r"
+	Write OpKind = "w"
+)
+
+// Op is a single item access. Version is the row version a read observed or a
+// write installed; 0 means it could not be determined (predicate reads, rows
+// without a version column) and the checker ignores it.
+type Op struct {
+	Kind    OpKind
+	Table   string
+	Key     string
+	Version int64
+}
+
+func (o Op) String() string {
+	return fmt.Sprintf("%s(%s %s@v%d)", o.Kind, o.Table, o.Key, o.Version)
+}
+
+type Txn struct {
+	Id        int
+	Name      string
+	Ops       []Op
+	Committed bool
+	Finished  bool
+}
+
+type History struct {
+	Txns []Txn
+}
+
+// VersionedTables lists the tables whose rows carry both an id and a version
+// column. After a write to one of them the recorder reads the row back inside
+// the same transaction to learn which version was installed.
+var VersionedTables = map[string]bool{
+	"ACCOUNT":    true,
+	"THREAD":     true,
+	"COMMENT":    true,
+	"REACTION":   true,
+	"FAKE_TABLE": true,
+	"JOB":        true,
+	"SAGA":       true,
+}
+
+type Recorder struct {
+	mu   sync.Mutex
+	txns []*Txn
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Begin wraps the connection of one transaction attempt. Call Finish with the
+// outcome of the transaction once it committed or rolled back.
+func (r *Recorder) Begin(name string, conn db.Connection) *Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	txn := &Txn{Id: len(r.txns), Name: name}
+	r.txns = append(r.txns, txn)
+
+	return &Conn{conn: conn, recorder: r, txn: txn}
+}
+
+func (r *Recorder) History() History {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h := History{Txns: make([]Txn, 0, len(r.txns))}
+	for _, txn := range r.txns {
+		t := *txn
+		t.Ops = append([]Op(nil), txn.Ops...)
+		h.Txns = append(h.Txns, t)
+	}
+
+	return h
+}
+
+func (r *Recorder) record(txn *Txn, op Op) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	txn.Ops = append(txn.Ops, op)
+}
+
+type Conn struct {
+	conn     db.Connection
+	recorder *Recorder
+	txn      *Txn
+}
+
+var _ db.Connection = (*Conn)(nil)
+
+func (c *Conn) Finish(err error) {
+	c.recorder.mu.Lock()
+	defer c.recorder.mu.Unlock()
+
+	c.txn.Finished = true
+	c.txn.Committed = err == nil
+}
+
+func (c *Conn) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return c.conn.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+func (c *Conn) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return c.conn.SendBatch(ctx, b)
+}
+
+func (c *Conn) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	tag, err := c.conn.Exec(ctx, sql, arguments...)
+	if err != nil {
+		return tag, err
+	}
+
+	kind, table := classify(sql)
+	if kind == Write && tag.RowsAffected() > 0 {
+		key := firstKey(arguments)
+		op := Op{Kind: Write, Table: table, Key: key}
+		if key != "" && VersionedTables[table] && !tag.Delete() {
+			err := c.conn.QueryRow(ctx, "SELECT version FROM "+table+" WHERE id = $1", key).Scan(&op.Version)
+			if err != nil {
+				return tag, err
+			}
+		}
+		c.recorder.record(c.txn, op)
+	}
+
+	return tag, nil
+}
+
+func (c *Conn) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	rows, err := c.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return rows, err
+	}
+
+	kind, table := classify(sql)
+	return &recordedRows{Rows: rows, conn: c, kind: kind, table: table, key: firstKey(args)}, nil
+}
+
+func (c *Conn) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	rows, err := c.Query(ctx, sql, args...)
+	return &recordedRow{rows: rows, err: err}
+}
+
+type recordedRows struct {
+	pgx.Rows
+	conn  *Conn
+	kind  OpKind
+	table string
+	key   string
+}
+
+func (r *recordedRows) Next() bool {
+	if !r.Rows.Next() {
+		return false
+	}
+
+	op := Op{Kind: r.kind, Table: r.table, Key: r.key}
+	values, err := r.Rows.Values()
+	if err == nil {
+		for i, fd := range r.Rows.FieldDescriptions() {
+			switch fd.Name {
+			case "id":
+				if id, ok := values[i].(string); ok {
+					op.Key = id
+				}
+			case "version":
+				if version, ok := values[i].(int64); ok {
+					op.Version = version
+				}
+			}
+		}
+	}
+	r.conn.recorder.record(r.conn.txn, op)
+
+	return true
+}
+
+type recordedRow struct {
+	rows pgx.Rows
+	err  error
+}
+
+func (r *recordedRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return pgx.ErrNoRows
+	}
+
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+	r.rows.Close()
+
+	return r.rows.Err()
+}
+
+var (
+	writeTable = regexp.MustCompile(`(?is)\b(?:INSERT\s+INTO|UPDATE|DELETE\s+FROM)\s+([A-Za-z_][A-Za-z0-9_]*)`)
+	readTable  = regexp.MustCompile(`(?is)\bFROM\s+([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+func classify(sql string) (OpKind, string) {
+	fields := strings.Fields(sql)
+	if len(fields) > 0 && !strings.EqualFold(fields[0], "SELECT") {
+		if m := writeTable.FindStringSubmatch(sql); m != nil {
+			return Write, strings.ToUpper(m[1])
+		}
+	}
+	if m := readTable.FindStringSubmatch(sql); m != nil {
+		return Read, strings.ToUpper(m[1])
+	}
+
+	return Read, ""
+}
+
+// firstKey follows the repository convention of passing the row id as $1.
+func firstKey(args []any) string {
+	if len(args) == 0 {
+		return ""
+	}
+	key, _ := args[0].(string)
+	return key
+}
@@ -0,0 +1,295 @@
+package interleave
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+type Op string
+
+const (
+	Read   Op = "read"
+	Write  Op = "write"
+	Commit Op = "commit"
+)
+
+type Step struct {
+	Txn string
+	Op  Op
+}
+
+// Scheduler forces transactions to follow a script such as
+//
+//	"T1 read", "T2 read", "T1 write", "T2 write", "T1 commit", "T2 commit"
+//
+// Every statement of a scripted transaction waits until it is the next step of
+// the script. A step is over when its statement returns, or as soon as
+// Postgres reports the statement as blocked by another transaction's lock, so
+// a script may let T2 wait on T1 and still reach "T1 commit". Once a
+// transaction ends (commit, rollback or error) its remaining steps are
+// dropped, which lets retried attempts and post-script statements run freely.
+type Scheduler struct {
+	PollInterval time.Duration
+
+	mu      sync.Mutex
+	steps   []Step
+	pos     int
+	pending map[string]func()
+	changed chan struct{}
+}
+
+func New(script ...string) (*Scheduler, error) {
+	steps := make([]Step, 0, len(script))
+	for _, line := range script {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("interleave: step %q must be \"<txn> <op>\"", line)
+		}
+
+		op := Op(strings.ToLower(fields[1]))
+		if op != Read && op != Write && op != Commit {
+			return nil, fmt.Errorf("interleave: unknown op %q in step %q", fields[1], line)
+		}
+		steps = append(steps, Step{Txn: fields[0], Op: op})
+	}
+
+	return &Scheduler{
+		PollInterval: 10 * time.Millisecond,
+		steps:        steps,
+		pending:      make(map[string]func()),
+		changed:      make(chan struct{}),
+	}, nil
+}
+
+// Context makes every transaction started with the returned context play the
+// steps of txn.
+func (s *Scheduler) Context(ctx context.Context, txn string) context.Context {
+	return db.WithTxHook(ctx, s.Hook(txn))
+}
+
+func (s *Scheduler) Hook(txn string) db.TxHook {
+	return db.TxHook{
+		Begin: func(ctx context.Context, conn db.Connection) db.Connection {
+			return &Conn{conn: conn, scheduler: s, txn: txn}
+		},
+		BeforeCommit: func(ctx context.Context) error {
+			release, err := s.await(ctx, txn, Commit)
+			if err != nil {
+				return err
+			}
+
+			s.mu.Lock()
+			s.pending[txn] = release
+			s.mu.Unlock()
+			return nil
+		},
+		End: func(error) {
+			s.mu.Lock()
+			release := s.pending[txn]
+			delete(s.pending, txn)
+			s.mu.Unlock()
+
+			if release != nil {
+				release()
+			}
+			s.drop(txn)
+		},
+	}
+}
+
+// Done reports whether every step of the script has been played.
+func (s *Scheduler) Done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pos >= len(s.steps)
+}
+
+// await blocks until the next step belongs to txn and returns the function that
+// completes that step. Transactions without remaining steps run freely.
+func (s *Scheduler) await(ctx context.Context, txn string, op Op) (func(), error) {
+	for {
+		s.mu.Lock()
+		if !s.hasStep(txn) {
+			s.mu.Unlock()
+			return func() {}, nil
+		}
+
+		step := s.steps[s.pos]
+		if step.Txn == txn {
+			s.mu.Unlock()
+			if step.Op != op {
+				return nil, fmt.Errorf("interleave: %s issued %s but the script expects %s %s", txn, op, step.Txn, step.Op)
+			}
+
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					s.mu.Lock()
+					s.pos++
+					s.broadcast()
+					s.mu.Unlock()
+				})
+			}, nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (s *Scheduler) hasStep(txn string) bool {
+	for _, step := range s.steps[s.pos:] {
+		if step.Txn == txn {
+			return true
+		}
+	}
+	return false
+}
+
+// drop removes the steps an ended transaction will never play.
+func (s *Scheduler) drop(txn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining := s.steps[:s.pos:s.pos]
+	for _, step := range s.steps[s.pos:] {
+		if step.Txn != txn {
+			remaining = append(remaining, step)
+		}
+	}
+	s.steps = remaining
+	s.broadcast()
+}
+
+func (s *Scheduler) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// watchBlocked completes the current step once the backend running it waits
+// for a lock held by another transaction.
+func (s *Scheduler) watchBlocked(ctx context.Context, pid uint32, finished <-chan struct{}, release func()) {
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Release()
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-finished:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		blocked := false
+		err := conn.QueryRow(ctx, `SELECT cardinality(pg_blocking_pids($1)) > 0`, int32(pid)).Scan(&blocked)
+		if err != nil {
+			return
+		}
+		if blocked {
+			release()
+			return
+		}
+	}
+}
+
+type Conn struct {
+	conn      db.Connection
+	scheduler *Scheduler
+	txn       string
+}
+
+var _ db.Connection = (*Conn)(nil)
+
+func (c *Conn) step(ctx context.Context, sql string) (func(), error) {
+	op := Write
+	fields := strings.Fields(sql)
+	if len(fields) > 0 && strings.EqualFold(fields[0], "SELECT") {
+		op = Read
+	}
+
+	release, err := c.scheduler.await(ctx, c.txn, op)
+	if err != nil {
+		return nil, err
+	}
+
+	finished := make(chan struct{})
+	if backend, ok := c.conn.(interface{ Conn() *pgx.Conn }); ok {
+		go c.scheduler.watchBlocked(ctx, backend.Conn().PgConn().PID(), finished, release)
+	}
+
+	return func() {
+		close(finished)
+		release()
+	}, nil
+}
+
+func (c *Conn) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	done, err := c.step(ctx, "COPY")
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	return c.conn.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+func (c *Conn) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return c.conn.SendBatch(ctx, b)
+}
+
+func (c *Conn) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	done, err := c.step(ctx, sql)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer done()
+
+	return c.conn.Exec(ctx, sql, arguments...)
+}
+
+func (c *Conn) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	done, err := c.step(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	return c.conn.Query(ctx, sql, args...)
+}
+
+func (c *Conn) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	done, err := c.step(ctx, sql)
+	if err != nil {
+		return errRow{err}
+	}
+	defer done()
+
+	return c.conn.QueryRow(ctx, sql, args...)
+}
+
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...any) error {
+	return r.err
+}
@@ -0,0 +1,149 @@
+package interleave_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/interleave"
+)
+
+type trace struct {
+	mu  sync.Mutex
+	log []string
+}
+
+func (t *trace) add(s string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.log = append(t.log, s)
+}
+
+type fakeConn struct {
+	name  string
+	trace *trace
+}
+
+func (f fakeConn) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	return 0, nil
+}
+
+func (f fakeConn) SendBatch(context.Context, *pgx.Batch) pgx.BatchResults {
+	return nil
+}
+
+func (f fakeConn) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	f.trace.add(f.name + " " + sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func (f fakeConn) Query(_ context.Context, sql string, _ ...any) (pgx.Rows, error) {
+	f.trace.add(f.name + " " + sql)
+	return nil, nil
+}
+
+func (f fakeConn) QueryRow(_ context.Context, sql string, _ ...any) pgx.Row {
+	f.trace.add(f.name + " " + sql)
+	return nil
+}
+
+func run(ctx context.Context, s *interleave.Scheduler, txn string, tr *trace, statements ...string) error {
+	hook := s.Hook(txn)
+	conn := hook.Begin(ctx, fakeConn{txn, tr})
+
+	var err error
+	for _, sql := range statements {
+		if _, err = conn.Exec(ctx, sql); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = hook.BeforeCommit(ctx)
+	}
+	if err == nil {
+		tr.add(txn + " COMMIT")
+	}
+	hook.End(err)
+
+	return err
+}
+
+func TestSchedulerFollowScript(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := interleave.New("T1 read", "T2 read", "T2 write", "T1 write", "T2 commit", "T1 commit")
+	require.NoError(t, err)
+
+	tr := &trace{}
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, txn := range []string{"T2", "T1"} {
+		wg.Add(1)
+		go func(i int, txn string) {
+			defer wg.Done()
+			errs[i] = run(ctx, s, txn, tr, "SELECT 1", "UPDATE 1")
+		}(i, txn)
+	}
+	wg.Wait()
+
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	assert.True(t, s.Done())
+	assert.Equal(t, []string{
+		"T1 SELECT 1",
+		"T2 SELECT 1",
+		"T2 UPDATE 1",
+		"T1 UPDATE 1",
+		"T2 COMMIT",
+		"T1 COMMIT",
+	}, tr.log)
+}
+
+func TestSchedulerRejectUnexpectedOp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := interleave.New("T1 read", "T1 commit")
+	require.NoError(t, err)
+
+	err = run(ctx, s, "T1", &trace{}, "UPDATE 1")
+	require.ErrorContains(t, err, "T1 issued write but the script expects T1 read")
+}
+
+func TestSchedulerDropStepsOfEndedTxn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := interleave.New("T1 read", "T2 read", "T1 write", "T2 write", "T1 commit", "T2 commit")
+	require.NoError(t, err)
+
+	tr := &trace{}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_ = run(ctx, s, "T1", tr, "SELECT 1", "UPDATE 1")
+	}()
+	go func() {
+		defer wg.Done()
+		// T2 fails on its write, a retry then runs without waiting
+		hook := s.Hook("T2")
+		conn := hook.Begin(ctx, fakeConn{"T2", tr})
+		_, _ = conn.Exec(ctx, "SELECT 1")
+		hook.End(db.ErrVersionMisMatch)
+
+		_ = run(ctx, s, "T2", tr, "SELECT 2", "UPDATE 2")
+	}()
+	wg.Wait()
+
+	assert.True(t, s.Done())
+	assert.Equal(t, []string{"T1 SELECT 1", "T2 SELECT 1"}, tr.log[:2])
+	assert.Len(t, tr.log, 7)
+}
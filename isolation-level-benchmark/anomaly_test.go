@@ -0,0 +1,173 @@
+package isolationlevelbenchmark
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/history"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+func TestLostUpdateAnomalyPerIsolationLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		txOpt     pgx.TxOptions
+		anomalies []history.AnomalyKind
+	}{
+		{
+			name:      "read committed permits lost update",
+			txOpt:     pgx.TxOptions{IsoLevel: pgx.ReadCommitted},
+			anomalies: []history.AnomalyKind{history.G2Item, history.LostUpdate},
+		},
+		{
+			name:  "repeatable read prevents lost update",
+			txOpt: pgx.TxOptions{IsoLevel: pgx.RepeatableRead},
+		},
+		{
+			name:  "serializable prevents lost update",
+			txOpt: pgx.TxOptions{IsoLevel: pgx.Serializable},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			userId, err := helper.CreateUser()
+			require.NoError(t, err)
+			threadId, err := helper.CreateThread(userId)
+			require.NoError(t, err)
+
+			rec := history.NewRecorder()
+			var (
+				read sync.WaitGroup
+				done sync.WaitGroup
+			)
+			read.Add(2)
+			for _, name := range []string{"T1", "T2"} {
+				done.Add(1)
+				go func(name string) {
+					defer done.Done()
+
+					var conn *history.Conn
+					err := db.Atomic(ctx, tt.txOpt, func(tx db.Connection) error {
+						conn = rec.Begin(name, tx)
+						thread, err := repository.GetThread(ctx, conn, threadId)
+						read.Done()
+						if err != nil {
+							return err
+						}
+						read.Wait()
+
+						thread.TotalReaction++
+						return repository.UpdateThread(ctx, conn, thread)
+					})
+					if conn != nil {
+						conn.Finish(err)
+					}
+				}(name)
+			}
+			done.Wait()
+
+			report := history.Check(rec.History())
+			var kinds []history.AnomalyKind
+			for _, a := range report.Anomalies {
+				kinds = append(kinds, a.Kind)
+			}
+			assert.Equal(t, tt.anomalies, kinds, report.String())
+			t.Log(report)
+		})
+	}
+}
+
+func TestWriteSkewAnomalyPerIsolationLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		txOpt     pgx.TxOptions
+		anomalies []history.AnomalyKind
+	}{
+		{
+			name:      "read committed permits write skew",
+			txOpt:     pgx.TxOptions{IsoLevel: pgx.ReadCommitted},
+			anomalies: []history.AnomalyKind{history.G2Item},
+		},
+		{
+			name:      "repeatable read permits write skew",
+			txOpt:     pgx.TxOptions{IsoLevel: pgx.RepeatableRead},
+			anomalies: []history.AnomalyKind{history.G2Item},
+		},
+		{
+			name:  "serializable prevents write skew",
+			txOpt: pgx.TxOptions{IsoLevel: pgx.Serializable},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			userId, err := helper.CreateUser()
+			require.NoError(t, err)
+			threadIds := make([]string, 2)
+			for i := range threadIds {
+				threadIds[i], err = helper.CreateThread(userId)
+				require.NoError(t, err)
+			}
+
+			// only one of the two threads may get a reaction: each transaction
+			// checks both are untouched, then reacts to its own
+			rec := history.NewRecorder()
+			var (
+				read sync.WaitGroup
+				done sync.WaitGroup
+			)
+			read.Add(2)
+			for i, name := range []string{"T1", "T2"} {
+				done.Add(1)
+				go func(name, own string) {
+					defer done.Done()
+
+					var conn *history.Conn
+					err := db.Atomic(ctx, tt.txOpt, func(tx db.Connection) error {
+						conn = rec.Begin(name, tx)
+						total := 0
+						var thread repository.Thread
+						for _, threadId := range threadIds {
+							v, err := repository.GetThread(ctx, conn, threadId)
+							if err != nil {
+								read.Done()
+								return err
+							}
+							total += v.TotalReaction
+							if threadId == own {
+								thread = v
+							}
+						}
+						read.Done()
+						read.Wait()
+
+						if total > 0 {
+							return nil
+						}
+						thread.TotalReaction++
+						return repository.UpdateThread(ctx, conn, thread)
+					})
+					if conn != nil {
+						conn.Finish(err)
+					}
+				}(name, threadIds[i])
+			}
+			done.Wait()
+
+			report := history.Check(rec.History())
+			var kinds []history.AnomalyKind
+			for _, a := range report.Anomalies {
+				kinds = append(kinds, a.Kind)
+			}
+			assert.Equal(t, tt.anomalies, kinds, report.String())
+			t.Log(report)
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package isolationlevelbenchmark
+
+import (
+	"testing"
+
+	"github.com/xyedo/db-concurency-problem/report"
+)
+
+func reportLatency(b *testing.B, rec *report.Recorder) {
+	b.StopTimer()
+	res := rec.Result()
+
+	b.ReportMetric(float64(res.Latency.P50.Microseconds()), "p50-µs")
+	b.ReportMetric(float64(res.Latency.P99.Microseconds()), "p99-µs")
+	b.ReportMetric(float64(res.AbortLatency.P99.Microseconds()), "abort-p99-µs")
+	if res.Operations > 0 {
+		b.ReportMetric(float64(res.TotalAborts())/float64(res.Operations), "aborts/op")
+	}
+}
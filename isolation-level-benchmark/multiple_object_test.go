@@ -2,47 +2,18 @@ package isolationlevelbenchmark
 
 import (
 	"context"
+	"os"
 	"testing"
 	"time"
 
-	"github.com/go-faker/faker/v4"
 	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/xyedo/db-concurency-problem/db"
 	"github.com/xyedo/db-concurency-problem/helper"
-	"github.com/xyedo/db-concurency-problem/repository"
+	"github.com/xyedo/db-concurency-problem/loadgen"
+	"github.com/xyedo/db-concurency-problem/report"
 )
 
-func ReadModifyWriteComment(ctx context.Context, txOpt pgx.TxOptions, userId, threadId string) error {
-	return db.Atomic(ctx, txOpt, func(tx db.Connection) error {
-		_, err := repository.GetAccount(ctx, tx, userId)
-		if err != nil {
-			return err
-		}
-
-		thread, err := repository.GetThread(ctx, tx, threadId)
-		if err != nil {
-			return err
-		}
-
-		commentId := helper.CommentId()
-		err = repository.CreateComment(ctx, tx, repository.Comment{
-			Id:        commentId,
-			ThreadId:  threadId,
-			UserId:    userId,
-			Content:   faker.Sentence(),
-			CreatedOn: time.Now(),
-			Version:   1,
-		})
-		if err != nil {
-			return err
-		}
-
-		thread.TotalComment++
-		return repository.UpdateThread(ctx, tx, thread)
-	})
-}
-
 func TestReadModifyWriteComment(t *testing.T) {
 	userId, err := helper.CreateUser()
 	require.NoError(t, err)
@@ -65,11 +36,15 @@ func BenchmarkMultipleObjectObjectReadCommitted(b *testing.B) {
 		panic(err)
 	}
 
+	rec := report.NewRecorder(b.Name(), "")
 	b.ResetTimer()
 
 	for n := 0; n < b.N; n++ {
-		_ = ReadModifyWriteComment(context.Background(), pgx.TxOptions{IsoLevel: pgx.ReadCommitted}, userId, threadId)
+		_ = rec.Do(context.Background(), func(ctx context.Context) error {
+			return ReadModifyWriteComment(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted}, userId, threadId)
+		})
 	}
+	reportLatency(b, rec)
 }
 
 func BenchmarkMultipleObjectObjectRepeatableRead(b *testing.B) {
@@ -83,11 +58,15 @@ func BenchmarkMultipleObjectObjectRepeatableRead(b *testing.B) {
 		panic(err)
 	}
 
+	rec := report.NewRecorder(b.Name(), "")
 	b.ResetTimer()
 
 	for n := 0; n < b.N; n++ {
-		_ = ReadModifyWriteComment(context.Background(), pgx.TxOptions{IsoLevel: pgx.RepeatableRead}, userId, threadId)
+		_ = rec.Do(context.Background(), func(ctx context.Context) error {
+			return ReadModifyWriteComment(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead}, userId, threadId)
+		})
 	}
+	reportLatency(b, rec)
 }
 
 func BenchmarkMultipleObjectObjectSerializable(b *testing.B) {
@@ -101,9 +80,49 @@ func BenchmarkMultipleObjectObjectSerializable(b *testing.B) {
 		panic(err)
 	}
 
+	rec := report.NewRecorder(b.Name(), "")
 	b.ResetTimer()
 
 	for n := 0; n < b.N; n++ {
-		_ = ReadModifyWriteComment(context.Background(), pgx.TxOptions{IsoLevel: pgx.Serializable}, userId, threadId)
+		_ = rec.Do(context.Background(), func(ctx context.Context) error {
+			return ReadModifyWriteComment(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable}, userId, threadId)
+		})
+	}
+	reportLatency(b, rec)
+}
+
+func TestOpenLoopReadModifyWriteComment(t *testing.T) {
+	tests := []struct {
+		name  string
+		txOpt pgx.TxOptions
+	}{
+		{name: "read committed", txOpt: pgx.TxOptions{IsoLevel: pgx.ReadCommitted}},
+		{name: "repeatable read", txOpt: pgx.TxOptions{IsoLevel: pgx.RepeatableRead}},
+		{name: "serializable", txOpt: pgx.TxOptions{IsoLevel: pgx.Serializable}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, err := helper.CreateUser()
+			require.NoError(t, err)
+			threadId, err := helper.CreateThread(userId)
+			require.NoError(t, err)
+
+			rec := report.NewRecorder("isolation multiple open loop", tt.name)
+			stats, err := loadgen.Run(context.Background(), loadgen.Config{
+				Rate:        100,
+				Duration:    time.Second,
+				MaxInFlight: 16,
+				MaxQueue:    1000,
+			}, rec, func(ctx context.Context, _ int) error {
+				return ReadModifyWriteComment(ctx, tt.txOpt, userId, threadId)
+			})
+			require.NoError(t, err)
+			res := rec.Result()
+			res.OpenLoop = &stats
+
+			assert.Equal(t, 100, stats.Scheduled)
+			assert.Equal(t, stats.Scheduled, res.Operations+stats.Dropped)
+			require.NoError(t, report.WriteText(os.Stdout, res))
+		})
 	}
 }
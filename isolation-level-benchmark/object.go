@@ -0,0 +1,55 @@
+package isolationlevelbenchmark
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-faker/faker/v4"
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+func ReadModifyWriteUser(ctx context.Context, txOpt pgx.TxOptions, userId string) error {
+	return db.Atomic(ctx, txOpt, func(tx db.Connection) error {
+		account, err := repository.GetAccount(ctx, tx, userId)
+		if err != nil {
+			return err
+		}
+
+		account.Username = helper.ToPointer(faker.Username())
+		account.Email = helper.ToPointer(faker.Email())
+		return repository.UpdateAccount(ctx, tx, account)
+	})
+}
+
+func ReadModifyWriteComment(ctx context.Context, txOpt pgx.TxOptions, userId, threadId string) error {
+	return db.Atomic(ctx, txOpt, func(tx db.Connection) error {
+		_, err := repository.GetAccount(ctx, tx, userId)
+		if err != nil {
+			return err
+		}
+
+		thread, err := repository.GetThread(ctx, tx, threadId)
+		if err != nil {
+			return err
+		}
+
+		commentId := helper.CommentId()
+		err = repository.CreateComment(ctx, tx, repository.Comment{
+			Id:        commentId,
+			ThreadId:  threadId,
+			UserId:    userId,
+			Content:   faker.Sentence(),
+			CreatedOn: time.Now(),
+			Version:   1,
+		})
+		if err != nil {
+			return err
+		}
+
+		thread.TotalComment++
+		return repository.UpdateThread(ctx, tx, thread)
+	})
+}
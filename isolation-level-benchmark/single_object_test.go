@@ -4,30 +4,20 @@ import (
 	"context"
 	"testing"
 
-	"github.com/go-faker/faker/v4"
 	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/require"
 	"github.com/xyedo/db-concurency-problem/config"
-	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
 	"github.com/xyedo/db-concurency-problem/helper"
-	"github.com/xyedo/db-concurency-problem/repository"
+	"github.com/xyedo/db-concurency-problem/report"
 )
 
 func init() {
 	config.Get("../.env")
 }
 
-func ReadModifyWriteUser(ctx context.Context, txOpt pgx.TxOptions, userId string) error {
-	return db.Atomic(ctx, txOpt, func(tx db.Connection) error {
-		account, err := repository.GetAccount(ctx, tx, userId)
-		if err != nil {
-			return err
-		}
-
-		account.Username = helper.ToPointer(faker.Username())
-		account.Email = helper.ToPointer(faker.Email())
-		return repository.UpdateAccount(ctx, tx, account)
-	})
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
 }
 
 func TestReadModifyWriteUser(t *testing.T) {
@@ -43,10 +33,14 @@ func BenchmarkSingleObjectReadCommitted(b *testing.B) {
 	if err != nil {
 		panic(err)
 	}
+	rec := report.NewRecorder(b.Name(), "")
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_ = ReadModifyWriteUser(context.Background(), pgx.TxOptions{IsoLevel: pgx.ReadCommitted}, userId)
+		_ = rec.Do(context.Background(), func(ctx context.Context) error {
+			return ReadModifyWriteUser(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted}, userId)
+		})
 	}
+	reportLatency(b, rec)
 }
 
 func BenchmarkSingleObjectRepeatableRead(b *testing.B) {
@@ -54,10 +48,14 @@ func BenchmarkSingleObjectRepeatableRead(b *testing.B) {
 	if err != nil {
 		panic(err)
 	}
+	rec := report.NewRecorder(b.Name(), "")
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_ = ReadModifyWriteUser(context.Background(), pgx.TxOptions{IsoLevel: pgx.RepeatableRead}, userId)
+		_ = rec.Do(context.Background(), func(ctx context.Context) error {
+			return ReadModifyWriteUser(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead}, userId)
+		})
 	}
+	reportLatency(b, rec)
 }
 
 func BenchmarkSingleObjectSerializable(b *testing.B) {
@@ -65,8 +63,12 @@ func BenchmarkSingleObjectSerializable(b *testing.B) {
 	if err != nil {
 		panic(err)
 	}
+	rec := report.NewRecorder(b.Name(), "")
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_ = ReadModifyWriteUser(context.Background(), pgx.TxOptions{IsoLevel: pgx.Serializable}, userId)
+		_ = rec.Do(context.Background(), func(ctx context.Context) error {
+			return ReadModifyWriteUser(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable}, userId)
+		})
 	}
+	reportLatency(b, rec)
 }
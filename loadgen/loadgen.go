@@ -0,0 +1,130 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xyedo/db-concurency-problem/report"
+)
+
+// Config drives an open loop: operation i is due at start + i/Rate whether or
+// not the previous ones are done, so a slow system builds a backlog instead of
+// silently lowering the offered load (coordinated omission).
+type Config struct {
+	Rate     float64
+	Duration time.Duration
+	// MaxInFlight bounds the operations running at once, 0 runs every
+	// operation as soon as it is due.
+	MaxInFlight int
+	// MaxQueue bounds the operations waiting for one of the MaxInFlight
+	// slots, an operation due while the queue is full is dropped.
+	MaxQueue int
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Rate <= 0:
+		return errors.New("loadgen: rate must be positive")
+	case c.Duration <= 0:
+		return errors.New("loadgen: duration must be positive")
+	case c.MaxInFlight < 0 || c.MaxQueue < 0:
+		return errors.New("loadgen: max in flight and max queue cannot be negative")
+	}
+	return nil
+}
+
+// Op runs the i-th scheduled operation.
+type Op func(ctx context.Context, i int) error
+
+type job struct {
+	i        int
+	intended time.Time
+}
+
+// Run schedules op at cfg.Rate for cfg.Duration and records every operation in
+// rec with its latency measured from the time it was due. Scheduling stops
+// when the duration elapses or ctx is done, operations already accepted run to
+// completion.
+func Run(ctx context.Context, cfg Config, rec *report.Recorder, op Op) (report.OpenLoop, error) {
+	if err := cfg.validate(); err != nil {
+		return report.OpenLoop{}, err
+	}
+
+	stats := report.OpenLoop{Rate: cfg.Rate}
+	interval := time.Duration(float64(time.Second) / cfg.Rate)
+	run := func(j job) {
+		_ = rec.DoAt(ctx, j.intended, func(ctx context.Context) error {
+			return op(ctx, j.i)
+		})
+	}
+
+	var (
+		wg   sync.WaitGroup
+		busy atomic.Int64
+		jobs chan job
+	)
+	if cfg.MaxInFlight > 0 {
+		jobs = make(chan job, cfg.MaxQueue)
+		for w := 0; w < cfg.MaxInFlight; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range jobs {
+					busy.Add(1)
+					run(j)
+					busy.Add(-1)
+				}
+			}()
+		}
+	}
+
+	start := time.Now()
+	for i := 0; ; i++ {
+		intended := start.Add(time.Duration(i) * interval)
+		if intended.Sub(start) >= cfg.Duration {
+			break
+		}
+
+		if wait := time.Until(intended); wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		stats.Scheduled++
+
+		j := job{i, intended}
+		if jobs == nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				run(j)
+			}()
+			continue
+		}
+
+		waiting := busy.Load() >= int64(cfg.MaxInFlight) || len(jobs) > 0
+		select {
+		case jobs <- j:
+			if waiting {
+				stats.Queued++
+			}
+			stats.MaxQueueDepth = max(stats.MaxQueueDepth, len(jobs))
+		default:
+			stats.Dropped++
+		}
+	}
+
+	if jobs != nil {
+		close(jobs)
+	}
+	wg.Wait()
+
+	return stats, nil
+}
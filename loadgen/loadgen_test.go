@@ -0,0 +1,102 @@
+package loadgen_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/loadgen"
+	"github.com/xyedo/db-concurency-problem/report"
+)
+
+func sleep(d time.Duration) loadgen.Op {
+	return func(ctx context.Context, i int) error {
+		time.Sleep(d)
+		return nil
+	}
+}
+
+func TestRunKeepsTheRate(t *testing.T) {
+	rec := report.NewRecorder("loadgen", "unbounded")
+	var ran atomic.Int64
+	stats, err := loadgen.Run(context.Background(), loadgen.Config{Rate: 1000, Duration: 200 * time.Millisecond}, rec, func(ctx context.Context, i int) error {
+		ran.Add(1)
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 200, stats.Scheduled)
+	assert.Zero(t, stats.Dropped)
+	assert.EqualValues(t, 200, ran.Load())
+	assert.Equal(t, 200, rec.Result().Operations)
+}
+
+func TestRunMeasuresFromIntendedStart(t *testing.T) {
+	// one worker serving 50 ops/s behind 100 ops/s of arrivals: the backlog
+	// grows and the last operations wait far longer than they run
+	rec := report.NewRecorder("loadgen", "overloaded")
+	stats, err := loadgen.Run(context.Background(), loadgen.Config{
+		Rate:        100,
+		Duration:    200 * time.Millisecond,
+		MaxInFlight: 1,
+		MaxQueue:    100,
+	}, rec, sleep(20*time.Millisecond))
+	require.NoError(t, err)
+
+	res := rec.Result()
+	assert.Equal(t, 20, stats.Scheduled)
+	assert.Zero(t, stats.Dropped)
+	assert.Greater(t, stats.Queued, 10)
+	assert.Greater(t, stats.MaxQueueDepth, 5)
+	assert.Equal(t, 20, res.Operations)
+	assert.Greater(t, res.Latency.Max, 150*time.Millisecond)
+}
+
+func TestRunDropsWhenTheQueueIsFull(t *testing.T) {
+	rec := report.NewRecorder("loadgen", "no queue")
+	stats, err := loadgen.Run(context.Background(), loadgen.Config{
+		Rate:        100,
+		Duration:    200 * time.Millisecond,
+		MaxInFlight: 1,
+	}, rec, sleep(50*time.Millisecond))
+	require.NoError(t, err)
+
+	res := rec.Result()
+	assert.Equal(t, 20, stats.Scheduled)
+	assert.Greater(t, stats.Dropped, 10)
+	assert.Equal(t, stats.Scheduled, res.Operations+stats.Dropped)
+	assert.Less(t, res.Latency.Max, 100*time.Millisecond)
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	stats, err := loadgen.Run(ctx, loadgen.Config{Rate: 100, Duration: time.Minute}, report.NewRecorder("loadgen", "cancel"), sleep(0))
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.InDelta(t, 5, stats.Scheduled, 2)
+}
+
+func TestRunValidatesConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     loadgen.Config
+		wantErr string
+	}{
+		{name: "no rate", cfg: loadgen.Config{Duration: time.Second}, wantErr: "rate must be positive"},
+		{name: "no duration", cfg: loadgen.Config{Rate: 1}, wantErr: "duration must be positive"},
+		{name: "negative queue", cfg: loadgen.Config{Rate: 1, Duration: time.Second, MaxQueue: -1}, wantErr: "cannot be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadgen.Run(context.Background(), tt.cfg, report.NewRecorder("loadgen", tt.name), sleep(0))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
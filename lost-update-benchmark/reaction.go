@@ -2,12 +2,15 @@ package lostupdatebenchmark
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/eventsource"
 	"github.com/xyedo/db-concurency-problem/helper"
 	"github.com/xyedo/db-concurency-problem/repository"
+	"github.com/xyedo/db-concurency-problem/saga"
 )
 
 type ForUpdate struct{}
@@ -41,8 +44,7 @@ func (ForUpdate) readModifyWriteReactionToThreadId(ctx context.Context, txOpt pg
 		}
 
 		thread.TotalReaction++
-		thread.Version++
-		return repository.UpdateThread(ctx, tx, thread)
+		return repository.Threads.Patch(ctx, tx, &thread, []string{"total_reaction"})
 	})
 }
 
@@ -77,8 +79,7 @@ func (RepeatableRead) readModifyWriteReactionToThreadId(ctx context.Context, txO
 		}
 
 		thread.TotalReaction++
-		thread.Version++
-		return repository.UpdateThread(ctx, tx, thread)
+		return repository.Threads.Patch(ctx, tx, &thread, []string{"total_reaction"})
 	})
 }
 
@@ -88,7 +89,27 @@ func (c CompareAndSet) Do(ctx context.Context, threadId, userId string) error {
 	return c.readModifyWriteReactionToThreadId(ctx, threadId, userId)
 }
 
-func (CompareAndSet) readModifyWriteReactionToThreadId(ctx context.Context, threadId, userId string) error {
+func (c CompareAndSet) DoIdempotent(ctx context.Context, idempotencyKey, threadId, userId string) (string, error) {
+	return db.Idempotent(ctx, db.IdempotencyKey{
+		Key:       idempotencyKey,
+		Operation: "add-thread-reaction",
+		Request:   []string{threadId, userId},
+	}, func(tx db.Connection) (string, error) {
+		_, err := repository.GetAccount(ctx, tx, userId)
+		if err != nil {
+			return "", err
+		}
+
+		var reactionId string
+		err = db.RetryMatchAndSetOn(ctx, tx, func(conn db.Connection) error {
+			reactionId, err = c.addReaction(ctx, conn, threadId, userId)
+			return err
+		})
+		return reactionId, err
+	})
+}
+
+func (c CompareAndSet) readModifyWriteReactionToThreadId(ctx context.Context, threadId, userId string) error {
 	conn, err := db.GetConnection(ctx)
 	if err != nil {
 		return err
@@ -101,44 +122,132 @@ func (CompareAndSet) readModifyWriteReactionToThreadId(ctx context.Context, thre
 	conn.Release()
 
 	return db.RetryMatchAndSet(ctx, func(conn db.Connection) error {
-		thread, err := repository.GetThread(ctx, conn, threadId)
-		if err != nil {
-			return err
-		}
+		_, err := c.addReaction(ctx, conn, threadId, userId)
+		return err
+	})
+}
 
-		reactionId := helper.ReactionId()
-		err = repository.CreateReaction(ctx, conn, repository.Reaction{
-			Id:        reactionId,
-			AccountId: userId,
-			ThreadId:  &threadId,
-			Content:   "like",
-			CreatedOn: time.Now(),
-			Version:   1,
-		})
-		if err != nil {
-			//in a production environtment we need to deleteReaction in case its already created but failed to send a success response back
-			// _ = repository.DeleteReaction(ctx, conn, reactionId)
-			return err
-		}
-		oldThread := thread
+func (CompareAndSet) addReaction(ctx context.Context, conn db.Connection, threadId, userId string) (string, error) {
+	thread, err := repository.GetThread(ctx, conn, threadId)
+	if err != nil {
+		return "", err
+	}
 
-		thread.TotalReaction++
-		thread.Version++
+	reactionId := helper.ReactionId()
+	err = repository.CreateReaction(ctx, conn, repository.Reaction{
+		Id:        reactionId,
+		AccountId: userId,
+		ThreadId:  &threadId,
+		Content:   "like",
+		CreatedOn: time.Now(),
+		Version:   1,
+	})
+	if err != nil {
+		//in a production environtment we need to deleteReaction in case its already created but failed to send a success response back
+		// _ = repository.DeleteReaction(ctx, conn, reactionId)
+		return "", err
+	}
+	oldThread := thread
 
-		err = repository.UpdateThread(ctx, conn, thread, repository.UpdateThreadOption{
-			CompareAndSet: &repository.CompareAndSetOption{
-				Version: oldThread.Version,
-			},
-		})
-		if err != nil {
-			//in a production environtment we need to rollback UpdateThread to its previous State in case its already updated but failed to send a success response back
-			// _ = repository.UpdateThread(ctx, conn, oldThread)
-			_ = repository.DeleteReaction(ctx, conn, reactionId)
+	thread.TotalReaction++
+	err = repository.Threads.Patch(ctx, conn, &thread, []string{"total_reaction"}, repository.UpdateOption{
+		CompareAndSet: &repository.CompareAndSetOption{
+			Version: oldThread.Version,
+		},
+	})
+	if err != nil {
+		//in a production environtment we need to rollback UpdateThread to its previous State in case its already updated but failed to send a success response back
+		// _ = repository.UpdateThread(ctx, conn, oldThread)
+		_ = repository.DeleteReaction(ctx, conn, reactionId)
 
-			return err
-		}
+		return "", err
+	}
 
-		return nil
+	return reactionId, nil
+}
+
+// EventSourced appends ReactionAdded to the stream of the thread instead of
+// updating THREAD, the (thread_id, seq) key of the stream catches concurrent
+// appends. THREAD only catches up once the stream is projected, see Settle.
+type EventSourced struct{}
+
+func (EventSourced) Do(ctx context.Context, threadId, userId string) error {
+	_, err := eventsource.AddReaction(ctx, threadId, userId)
+	return err
+}
+
+// Settle projects the stream of the thread into its THREAD row.
+func (EventSourced) Settle(ctx context.Context, threadId string) error {
+	return eventsource.Project(ctx, threadId)
+}
+
+type Saga struct{}
+
+func (Saga) Do(ctx context.Context, threadId, userId string) error {
+	_, err := saga.Run(ctx, AddThreadReactionSaga, saga.Data{
+		"reaction_id": helper.ReactionId(),
+		"thread_id":   threadId,
+		"user_id":     userId,
 	})
+	return err
+}
 
+var AddThreadReactionSaga = saga.Definition{
+	Name: "add-thread-reaction",
+	Steps: []saga.Step{
+		{
+			Name: "create-reaction",
+			Action: func(ctx context.Context, conn db.Connection, data saga.Data) error {
+				userId := data["user_id"]
+				_, err := repository.GetAccount(ctx, conn, userId)
+				if err != nil {
+					return err
+				}
+
+				// the step may run again after a crash, the reaction of the
+				// first run is then already there
+				_, err = repository.Reactions.Get(ctx, conn, data["reaction_id"])
+				if err == nil {
+					return nil
+				}
+				if !errors.Is(err, pgx.ErrNoRows) {
+					return err
+				}
+
+				threadId := data["thread_id"]
+				return repository.CreateReaction(ctx, conn, repository.Reaction{
+					Id:        data["reaction_id"],
+					AccountId: userId,
+					ThreadId:  &threadId,
+					Content:   "like",
+					CreatedOn: time.Now(),
+					Version:   1,
+				})
+			},
+			Compensate: func(ctx context.Context, conn db.Connection, data saga.Data) error {
+				err := repository.DeleteReaction(ctx, conn, data["reaction_id"])
+				if errors.Is(err, pgx.ErrNoRows) {
+					return nil
+				}
+				return err
+			},
+		},
+		{
+			Name: "increment-thread-reaction",
+			Action: func(ctx context.Context, conn db.Connection, data saga.Data) error {
+				thread, err := repository.GetThread(ctx, conn, data["thread_id"])
+				if err != nil {
+					return err
+				}
+				oldVersion := thread.Version
+
+				thread.TotalReaction++
+				return repository.Threads.Patch(ctx, conn, &thread, []string{"total_reaction"}, repository.UpdateOption{
+					CompareAndSet: &repository.CompareAndSetOption{
+						Version: oldVersion,
+					},
+				})
+			},
+		},
+	},
 }
@@ -2,8 +2,11 @@ package lostupdatebenchmark_test
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"sync"
 	"testing"
 	"time"
@@ -12,8 +15,11 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/xyedo/db-concurency-problem/config"
 	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
 	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/loadgen"
 	lostupdatebenchmark "github.com/xyedo/db-concurency-problem/lost-update-benchmark"
+	"github.com/xyedo/db-concurency-problem/report"
 	"github.com/xyedo/db-concurency-problem/repository"
 )
 
@@ -21,15 +27,36 @@ func init() {
 	config.Get("../.env")
 }
 
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+var reportPath = flag.String("report", "", "write the TestReactionCounter report to this .json, .csv or .md file")
+
 type Reaction interface {
 	Do(ctx context.Context, threadId, userId string) error
 }
 
+// settler is a Reaction whose THREAD row lags behind until settled.
+type settler interface {
+	Settle(ctx context.Context, threadId string) error
+}
+
+func settle(ctx context.Context, reaction Reaction, threadId string) error {
+	if s, ok := reaction.(settler); ok {
+		return s.Settle(ctx, threadId)
+	}
+	return nil
+}
+
 func TestReactionCounter(t *testing.T) {
 	ctx := context.Background()
 	tests := []struct {
 		name     string
 		reaction Reaction
+		// compensates tells that reactions losing a race are undone rather
+		// than retried, so fewer than all of them may land
+		compensates bool
 	}{
 		{
 			name:     "locking",
@@ -43,18 +70,34 @@ func TestReactionCounter(t *testing.T) {
 			name:     "compare and set",
 			reaction: lostupdatebenchmark.CompareAndSet{},
 		},
+		{
+			name:        "saga",
+			reaction:    lostupdatebenchmark.Saga{},
+			compensates: true,
+		},
+		{
+			name:     "event sourced",
+			reaction: lostupdatebenchmark.EventSourced{},
+		},
 	}
+	var results []report.Result
 	for _, tt := range tests {
 		userId, err := helper.CreateUser()
 		require.NoError(t, err)
 		threadId, err := helper.CreateThread(userId)
 		require.NoError(t, err)
 		t.Run(tt.name, func(t *testing.T) {
-			start := time.Now()
-			err := addConcurentReaction(ctx, tt.reaction, 100, threadId)
-			if err != nil {
-				log.Println(err)
+			rec := report.NewRecorder("lost-update", tt.name)
+			err := addConcurentReaction(ctx, rec, tt.reaction, 100, threadId)
+			if tt.compensates {
+				if err != nil {
+					log.Println(err)
+				}
+			} else {
+				require.NoError(t, err)
 			}
+			res := rec.Result()
+			require.NoError(t, settle(ctx, tt.reaction, threadId))
 
 			c, err := db.GetConnection(ctx)
 			require.NoError(t, err)
@@ -63,21 +106,113 @@ func TestReactionCounter(t *testing.T) {
 			thread, err := repository.GetThread(ctx, c, threadId)
 			require.NoError(t, err)
 
-			assert.Equal(t, 100, thread.TotalReaction)
-			fmt.Println("execution time: ", time.Since(start))
+			res.Correct = thread.TotalReaction == res.Succeeded
+			res.Detail = fmt.Sprintf("total_reaction=%d, succeeded reactions=%d", thread.TotalReaction, res.Succeeded)
+			results = append(results, res)
+
+			assert.True(t, res.Correct, res.Detail)
+			if !tt.compensates {
+				assert.Equal(t, 100, thread.TotalReaction)
+			}
 		})
 	}
-}
 
-func addConcurentReaction(ctx context.Context, cb Reaction, concurentUser int, threadId string) error {
+	require.NoError(t, report.WriteMarkdown(os.Stdout, results...))
+	if *reportPath != "" {
+		require.NoError(t, report.WriteFile(*reportPath, report.FormatOf(*reportPath), results...))
+	}
+}
 
-	newUserIds := make([]string, 0, concurentUser)
-	for i := 0; i < concurentUser; i++ {
+func TestReactionCounterOpenLoop(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		reaction Reaction
+	}{
+		{
+			name:     "locking",
+			reaction: lostupdatebenchmark.ForUpdate{},
+		},
+		{
+			name:     "repeatable read",
+			reaction: lostupdatebenchmark.RepeatableRead{},
+		},
+		{
+			name:     "compare and set",
+			reaction: lostupdatebenchmark.CompareAndSet{},
+		},
+		{
+			name:     "event sourced",
+			reaction: lostupdatebenchmark.EventSourced{},
+		},
+	}
+	var results []report.Result
+	for _, tt := range tests {
 		userId, err := helper.CreateUser()
-		if err != nil {
-			return err
-		}
-		newUserIds = append(newUserIds, userId)
+		require.NoError(t, err)
+		threadId, err := helper.CreateThread(userId)
+		require.NoError(t, err)
+		t.Run(tt.name, func(t *testing.T) {
+			rec := report.NewRecorder("lost-update open loop", tt.name)
+			stats, err := loadgen.Run(ctx, loadgen.Config{
+				Rate:        200,
+				Duration:    time.Second,
+				MaxInFlight: 32,
+				MaxQueue:    1000,
+			}, rec, func(ctx context.Context, _ int) error {
+				return tt.reaction.Do(ctx, threadId, userId)
+			})
+			require.NoError(t, err)
+			res := rec.Result()
+			res.OpenLoop = &stats
+			require.NoError(t, settle(ctx, tt.reaction, threadId))
+
+			c, err := db.GetConnection(ctx)
+			require.NoError(t, err)
+			defer c.Release()
+
+			thread, err := repository.GetThread(ctx, c, threadId)
+			require.NoError(t, err)
+
+			res.Correct = thread.TotalReaction == res.Succeeded
+			res.Detail = fmt.Sprintf("total_reaction=%d, succeeded reactions=%d", thread.TotalReaction, res.Succeeded)
+			results = append(results, res)
+
+			assert.Equal(t, 200, stats.Scheduled)
+			assert.True(t, res.Correct, res.Detail)
+		})
+	}
+
+	require.NoError(t, report.WriteMarkdown(os.Stdout, results...))
+}
+
+func TestCompareAndSetIdempotentRetry(t *testing.T) {
+	ctx := context.Background()
+	userId, err := helper.CreateUser()
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(userId)
+	require.NoError(t, err)
+
+	idempotencyKey := helper.ReactionId()
+	first, err := lostupdatebenchmark.CompareAndSet{}.DoIdempotent(ctx, idempotencyKey, threadId, userId)
+	require.NoError(t, err)
+	replay, err := lostupdatebenchmark.CompareAndSet{}.DoIdempotent(ctx, idempotencyKey, threadId, userId)
+	require.NoError(t, err)
+	assert.Equal(t, first, replay)
+
+	c, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer c.Release()
+
+	thread, err := repository.GetThread(ctx, c, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, 1, thread.TotalReaction)
+}
+
+func addConcurentReaction(ctx context.Context, rec *report.Recorder, cb Reaction, concurentUser int, threadId string) error {
+	newUserIds, err := helper.CreateUsers(concurentUser)
+	if err != nil {
+		return err
 	}
 
 	var wg sync.WaitGroup
@@ -86,16 +221,12 @@ func addConcurentReaction(ctx context.Context, cb Reaction, concurentUser int, t
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
-			errs[i] = cb.Do(ctx, threadId, newUserIds[i])
+			errs[i] = rec.Do(ctx, func(ctx context.Context) error {
+				return cb.Do(ctx, threadId, newUserIds[i])
+			})
 		}(i)
 	}
 	wg.Wait()
 
-	for _, err := range errs {
-		if err != nil {
-			log.Println(err)
-		}
-	}
-
-	return nil
+	return errors.Join(errs...)
 }
@@ -0,0 +1,46 @@
+package nonrepeatableread
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+// ReadThreadTwice reads the same thread twice in one transaction and runs
+// between, which is expected to commit a change to that thread from another
+// connection, in the middle.
+func ReadThreadTwice(ctx context.Context, txOpt pgx.TxOptions, threadId string, between func(ctx context.Context) error) (first, second repository.Thread, err error) {
+	err = db.Atomic(ctx, txOpt, func(tx db.Connection) error {
+		first, err = repository.GetThread(ctx, tx, threadId)
+		if err != nil {
+			return err
+		}
+
+		err = between(ctx)
+		if err != nil {
+			return err
+		}
+
+		second, err = repository.GetThread(ctx, tx, threadId)
+		return err
+	})
+	if err != nil {
+		return repository.Thread{}, repository.Thread{}, err
+	}
+
+	return first, second, nil
+}
+
+func AddReaction(ctx context.Context, threadId string) error {
+	return db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		thread, err := repository.GetThread(ctx, tx, threadId, repository.GetThreadOption{ForUpdate: true})
+		if err != nil {
+			return err
+		}
+
+		thread.TotalReaction++
+		return repository.UpdateThread(ctx, tx, thread)
+	})
+}
@@ -0,0 +1,62 @@
+package nonrepeatableread_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	nonrepeatableread "github.com/xyedo/db-concurency-problem/non-repeatable-read"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestReadThreadTwice(t *testing.T) {
+	tests := []struct {
+		name        string
+		level       pgx.TxIsoLevel
+		wantAnomaly bool
+	}{
+		{
+			name:        "non repeatable read in read committed",
+			level:       pgx.ReadCommitted,
+			wantAnomaly: true,
+		},
+		{
+			name:        "repeatable read prevents it",
+			level:       pgx.RepeatableRead,
+			wantAnomaly: false,
+		},
+		{
+			name:        "serializable prevents it",
+			level:       pgx.Serializable,
+			wantAnomaly: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, err := helper.CreateUser()
+			require.NoError(t, err)
+			threadId, err := helper.CreateThread(userId)
+			require.NoError(t, err)
+
+			first, second, err := nonrepeatableread.ReadThreadTwice(context.Background(), pgx.TxOptions{IsoLevel: tt.level}, threadId, func(ctx context.Context) error {
+				return nonrepeatableread.AddReaction(ctx, threadId)
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantAnomaly, first.TotalReaction != second.TotalReaction)
+			assert.Equal(t, tt.wantAnomaly, first.Version != second.Version)
+		})
+	}
+}
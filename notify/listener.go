@@ -0,0 +1,187 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+const ThreadChannel = "thread_changed"
+
+type ThreadChanged struct {
+	Id            string `json:"id"`
+	TotalComment  int    `json:"total_comment"`
+	TotalReaction int    `json:"total_reaction"`
+	Version       int    `json:"version"`
+}
+
+// Listener fans the notifications of ThreadChannel out to the subscribers of
+// each thread. The zero value is ready to use, zero fields take their
+// defaults.
+type Listener struct {
+	BufferSize        int
+	ReconnectInterval time.Duration
+
+	mu          sync.Mutex
+	subscribers map[string]map[<-chan ThreadChanged]chan ThreadChanged
+	dropped     int
+	ready       chan struct{}
+	readyOnce   sync.Once
+}
+
+const (
+	defaultBufferSize        = 16
+	defaultReconnectInterval = time.Second
+)
+
+func NewListener() *Listener {
+	return &Listener{
+		BufferSize:        defaultBufferSize,
+		ReconnectInterval: defaultReconnectInterval,
+	}
+}
+
+// Run keeps a dedicated connection LISTENing until ctx is cancelled. When the
+// connection breaks it reconnects and LISTENs again; notifications sent while
+// disconnected are lost, so subscribers should treat events as hints and
+// re-read the thread if they need the exact state.
+func (l *Listener) Run(ctx context.Context) error {
+	reconnectInterval := l.ReconnectInterval
+	if reconnectInterval == 0 {
+		reconnectInterval = defaultReconnectInterval
+	}
+
+	for {
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Println("thread listener disconnected:", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// Ready is closed once the first LISTEN has been issued.
+func (l *Listener) Ready() <-chan struct{} {
+	return l.readyChan()
+}
+
+func (l *Listener) readyChan() chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ready == nil {
+		l.ready = make(chan struct{})
+	}
+	return l.ready
+}
+
+func (l *Listener) listen(ctx context.Context) error {
+	pooled, err := db.GetConnection(ctx)
+	if err != nil {
+		return err
+	}
+	// a LISTENing session must never go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+ThreadChannel)
+	if err != nil {
+		return err
+	}
+	l.readyOnce.Do(func() { close(l.readyChan()) })
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var changed ThreadChanged
+		if err := json.Unmarshal([]byte(notification.Payload), &changed); err != nil {
+			log.Println("malformed thread notification:", err)
+			continue
+		}
+		l.dispatch(changed)
+	}
+}
+
+// Subscribe returns a buffered channel receiving every change of threadId. A
+// subscriber that falls behind loses its oldest pending event rather than
+// blocking the listener, so it always ends up with the latest counters.
+func (l *Listener) Subscribe(threadId string) <-chan ThreadChanged {
+	bufferSize := l.BufferSize
+	if bufferSize == 0 {
+		bufferSize = defaultBufferSize
+	}
+	ch := make(chan ThreadChanged, bufferSize)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.subscribers == nil {
+		l.subscribers = make(map[string]map[<-chan ThreadChanged]chan ThreadChanged)
+	}
+	if l.subscribers[threadId] == nil {
+		l.subscribers[threadId] = make(map[<-chan ThreadChanged]chan ThreadChanged)
+	}
+	l.subscribers[threadId][ch] = ch
+
+	return ch
+}
+
+func (l *Listener) Unsubscribe(threadId string, sub <-chan ThreadChanged) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ch, ok := l.subscribers[threadId][sub]
+	if !ok {
+		return
+	}
+	delete(l.subscribers[threadId], sub)
+	if len(l.subscribers[threadId]) == 0 {
+		delete(l.subscribers, threadId)
+	}
+	close(ch)
+}
+
+// Dropped reports how many events were discarded because of slow subscribers.
+func (l *Listener) Dropped() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.dropped
+}
+
+func (l *Listener) dispatch(changed ThreadChanged) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, ch := range l.subscribers[changed.Id] {
+		select {
+		case ch <- changed:
+			continue
+		default:
+		}
+
+		select {
+		case <-ch:
+			l.dropped++
+		default:
+		}
+		select {
+		case ch <- changed:
+		default:
+			l.dropped++
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestDispatchDropOldestForSlowSubscriber(t *testing.T) {
+	l := NewListener()
+	l.BufferSize = 2
+
+	slow := l.Subscribe("thread_1")
+	other := l.Subscribe("thread_2")
+
+	for i := 1; i <= 5; i++ {
+		l.dispatch(ThreadChanged{Id: "thread_1", TotalReaction: i})
+	}
+
+	assert.Equal(t, 3, l.Dropped())
+	assert.Equal(t, 4, (<-slow).TotalReaction)
+	assert.Equal(t, 5, (<-slow).TotalReaction)
+	assert.Empty(t, other)
+
+	l.Unsubscribe("thread_1", slow)
+	_, ok := <-slow
+	assert.False(t, ok)
+}
+
+func TestZeroListener(t *testing.T) {
+	var l Listener
+
+	sub := l.Subscribe("thread_1")
+	l.dispatch(ThreadChanged{Id: "thread_1", TotalReaction: 1})
+	assert.Equal(t, 1, (<-sub).TotalReaction)
+	assert.Equal(t, defaultBufferSize, cap(sub))
+	assert.Equal(t, l.Ready(), l.Ready())
+
+	l.Unsubscribe("thread_1", sub)
+	l.Unsubscribe("thread_2", sub)
+}
+
+func TestSubscribeReceiveCounterChange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, err := helper.CreateUser()
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(userId)
+	require.NoError(t, err)
+
+	l := NewListener()
+	go l.Run(ctx)
+	sub := l.Subscribe(threadId)
+	defer l.Unsubscribe(threadId, sub)
+
+	select {
+	case <-l.Ready():
+	case <-ctx.Done():
+		t.Fatal("listener never became ready")
+	}
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	thread, err := repository.GetThread(ctx, conn, threadId)
+	require.NoError(t, err)
+
+	thread.Title = "title only change must not notify"
+	require.NoError(t, repository.UpdateThread(ctx, conn, thread))
+
+	thread.TotalReaction++
+	require.NoError(t, repository.UpdateThread(ctx, conn, thread))
+
+	select {
+	case changed := <-sub:
+		assert.Equal(t, threadId, changed.Id)
+		assert.Equal(t, 1, changed.TotalReaction)
+		assert.Equal(t, 3, changed.Version)
+	case <-ctx.Done():
+		t.Fatal("no notification received")
+	}
+}
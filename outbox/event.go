@@ -0,0 +1,107 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	TypeThreadCreated   = "thread.created"
+	TypeCommentPosted   = "comment.posted"
+	TypeReactionAdded   = "reaction.added"
+	TypeReactionRemoved = "reaction.removed"
+)
+
+type Event interface {
+	EventType() string
+	AggregateId() string
+}
+
+type ThreadCreated struct {
+	ThreadId  string    `json:"thread_id"`
+	CreatedBy string    `json:"created_by"`
+	Title     string    `json:"title"`
+	CreatedOn time.Time `json:"created_on"`
+	Version   int       `json:"version"`
+}
+
+func (ThreadCreated) EventType() string     { return TypeThreadCreated }
+func (e ThreadCreated) AggregateId() string { return e.ThreadId }
+
+type CommentPosted struct {
+	CommentId string    `json:"comment_id"`
+	ThreadId  string    `json:"thread_id"`
+	UserId    string    `json:"user_id"`
+	ReplyTo   *string   `json:"reply_to"`
+	CreatedOn time.Time `json:"created_on"`
+	Version   int       `json:"version"`
+}
+
+func (CommentPosted) EventType() string     { return TypeCommentPosted }
+func (e CommentPosted) AggregateId() string { return e.ThreadId }
+
+type ReactionAdded struct {
+	ReactionId string    `json:"reaction_id"`
+	AccountId  string    `json:"account_id"`
+	ThreadId   *string   `json:"thread_id"`
+	CommentId  *string   `json:"comment_id"`
+	Content    string    `json:"content"`
+	CreatedOn  time.Time `json:"created_on"`
+	Version    int       `json:"version"`
+}
+
+func (ReactionAdded) EventType() string { return TypeReactionAdded }
+func (e ReactionAdded) AggregateId() string {
+	if e.ThreadId != nil {
+		return *e.ThreadId
+	}
+	if e.CommentId != nil {
+		return *e.CommentId
+	}
+	return e.ReactionId
+}
+
+type ReactionRemoved struct {
+	ReactionId string  `json:"reaction_id"`
+	AccountId  string  `json:"account_id"`
+	ThreadId   *string `json:"thread_id"`
+	CommentId  *string `json:"comment_id"`
+}
+
+func (ReactionRemoved) EventType() string { return TypeReactionRemoved }
+func (e ReactionRemoved) AggregateId() string {
+	return ReactionAdded{ReactionId: e.ReactionId, ThreadId: e.ThreadId, CommentId: e.CommentId}.AggregateId()
+}
+
+func Decode(msg Message) (Event, error) {
+	var (
+		event Event
+		err   error
+	)
+	switch msg.EventType {
+	case TypeThreadCreated:
+		var e ThreadCreated
+		err = json.Unmarshal(msg.Payload, &e)
+		event = e
+	case TypeCommentPosted:
+		var e CommentPosted
+		err = json.Unmarshal(msg.Payload, &e)
+		event = e
+	case TypeReactionAdded:
+		var e ReactionAdded
+		err = json.Unmarshal(msg.Payload, &e)
+		event = e
+	case TypeReactionRemoved:
+		var e ReactionRemoved
+		err = json.Unmarshal(msg.Payload, &e)
+		event = e
+	default:
+		return nil, fmt.Errorf("unknown event type %q", msg.EventType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
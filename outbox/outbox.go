@@ -0,0 +1,89 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+type Message struct {
+	Seq         int64           `db:"seq"`
+	AggregateId string          `db:"aggregate_id"`
+	EventType   string          `db:"event_type"`
+	Payload     json.RawMessage `db:"payload"`
+	CreatedOn   time.Time       `db:"created_on"`
+	PublishedOn *time.Time      `db:"published_on"`
+}
+
+// Attach wraps an `INSERT ... RETURNING id` statement, or an UPDATE or DELETE
+// returning the rows it changed, so the event row is written by the very same
+// statement, which keeps the entity and its event atomic even when conn is not
+// a transaction. No event is written when the statement changes no row.
+func Attach(insert string, args []any, event Event) (string, []any, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return "", nil, err
+	}
+
+	n := len(args)
+	query := fmt.Sprintf(`
+	WITH inserted AS (%s)
+	INSERT INTO OUTBOX (
+		aggregate_id,
+		event_type,
+		payload,
+		created_on
+	)
+	SELECT $%d, $%d, $%d, $%d FROM inserted`,
+		insert, n+1, n+2, n+3, n+4,
+	)
+
+	return query, append(args, event.AggregateId(), event.EventType(), payload, time.Now()), nil
+}
+
+func Write(ctx context.Context, conn db.Connection, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(ctx, `INSERT INTO OUTBOX (
+		aggregate_id,
+		event_type,
+		payload,
+		created_on
+		) VALUES
+		($1,$2,$3,$4)`,
+		event.AggregateId(),
+		event.EventType(),
+		payload,
+		time.Now(),
+	)
+	return err
+}
+
+func GetMessages(ctx context.Context, conn db.Connection, aggregateId string) ([]Message, error) {
+	var messages []Message
+	err := pgxscan.Select(ctx, conn, &messages, `
+	SELECT
+		seq,
+		aggregate_id,
+		event_type,
+		payload,
+		created_on,
+		published_on
+	FROM OUTBOX
+	WHERE aggregate_id = $1
+	ORDER BY seq ASC`,
+		aggregateId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
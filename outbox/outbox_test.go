@@ -0,0 +1,229 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/outbox"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestAttach(t *testing.T) {
+	query, args, err := outbox.Attach(
+		`INSERT INTO THREAD (id, title) VALUES ($1,$2) RETURNING id`,
+		[]any{"thread_1", "title"},
+		outbox.ThreadCreated{ThreadId: "thread_1", Version: 1},
+	)
+	require.NoError(t, err)
+
+	assert.Contains(t, query, "WITH inserted AS (INSERT INTO THREAD (id, title) VALUES ($1,$2) RETURNING id)")
+	assert.Contains(t, query, "SELECT $3, $4, $5, $6 FROM inserted")
+	require.Len(t, args, 6)
+	assert.Equal(t, "thread_1", args[2])
+	assert.Equal(t, outbox.TypeThreadCreated, args[3])
+}
+
+func TestEventIsWrittenWithEntity(t *testing.T) {
+	ctx := context.Background()
+	userId, err := helper.CreateUser()
+	require.NoError(t, err)
+
+	threadId := helper.ThreadId()
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		err := repository.CreateThread(ctx, tx, repository.Thread{
+			Id:        threadId,
+			Title:     "rolled back",
+			Body:      "rolled back",
+			CreatedBy: userId,
+			CreatedOn: time.Now(),
+			Version:   1,
+		})
+		if err != nil {
+			return err
+		}
+
+		return errors.New("abort")
+	})
+	require.Error(t, err)
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	messages, err := outbox.GetMessages(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestRelayDeliverInOrder(t *testing.T) {
+	ctx := context.Background()
+	userId, err := helper.CreateUser()
+	require.NoError(t, err)
+
+	threadId, err := helper.CreateThread(userId)
+	require.NoError(t, err)
+
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		err := repository.CreateComment(ctx, tx, repository.Comment{
+			Id:        helper.CommentId(),
+			ThreadId:  threadId,
+			UserId:    userId,
+			Content:   "first",
+			CreatedOn: time.Now(),
+			Version:   1,
+		})
+		if err != nil {
+			return err
+		}
+
+		return repository.CreateReaction(ctx, tx, repository.Reaction{
+			Id:        helper.ReactionId(),
+			AccountId: userId,
+			ThreadId:  &threadId,
+			Content:   "like",
+			CreatedOn: time.Now(),
+			Version:   1,
+		})
+	})
+	require.NoError(t, err)
+
+	publisher := &outbox.InMemoryPublisher{}
+	relay := outbox.Relay{Publisher: publisher}
+	for {
+		n, err := relay.RelayOnce(ctx)
+		require.NoError(t, err)
+		if n == 0 {
+			break
+		}
+	}
+
+	var events []outbox.Event
+	for _, msg := range publisher.Messages() {
+		if msg.AggregateId != threadId {
+			continue
+		}
+		event, err := outbox.Decode(msg)
+		require.NoError(t, err)
+		events = append(events, event)
+	}
+
+	require.Len(t, events, 3)
+	assert.IsType(t, outbox.ThreadCreated{}, events[0])
+	assert.IsType(t, outbox.CommentPosted{}, events[1])
+	assert.IsType(t, outbox.ReactionAdded{}, events[2])
+	assert.Equal(t, userId, events[2].(outbox.ReactionAdded).AccountId)
+
+	n, err := relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestDeleteReactionWritesEvent(t *testing.T) {
+	ctx := context.Background()
+	userId, err := helper.CreateUser()
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(userId)
+	require.NoError(t, err)
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	reactionId := helper.ReactionId()
+	err = repository.CreateReaction(ctx, conn, repository.Reaction{
+		Id:        reactionId,
+		AccountId: userId,
+		ThreadId:  &threadId,
+		Content:   "like",
+		CreatedOn: time.Now(),
+		Version:   1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, repository.DeleteReaction(ctx, conn, reactionId))
+	assert.Error(t, repository.DeleteReaction(ctx, conn, reactionId))
+
+	messages, err := outbox.GetMessages(ctx, conn, threadId)
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+
+	event, err := outbox.Decode(messages[2])
+	require.NoError(t, err)
+	assert.Equal(t, outbox.ReactionRemoved{
+		ReactionId: reactionId,
+		AccountId:  userId,
+		ThreadId:   &threadId,
+	}, event)
+}
+
+func TestRelayHoldsBackNewerTransactions(t *testing.T) {
+	ctx := context.Background()
+	first, second := helper.ThreadId(), helper.ThreadId()
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	// first begins and writes its event, second commits its event meanwhile
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+	require.NoError(t, outbox.Write(ctx, tx, outbox.ThreadCreated{ThreadId: first, Version: 1}))
+
+	other, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer other.Release()
+	require.NoError(t, outbox.Write(ctx, other, outbox.ThreadCreated{ThreadId: second, Version: 1}))
+
+	publisher := &outbox.InMemoryPublisher{}
+	relay := outbox.Relay{Publisher: publisher}
+	relayAll := func() {
+		for {
+			n, err := relay.RelayOnce(ctx)
+			require.NoError(t, err)
+			if n == 0 {
+				return
+			}
+		}
+	}
+	delivered := func() []string {
+		var ids []string
+		for _, msg := range publisher.Messages() {
+			if msg.AggregateId == first || msg.AggregateId == second {
+				ids = append(ids, msg.AggregateId)
+			}
+		}
+		return ids
+	}
+
+	relayAll()
+	assert.Empty(t, delivered())
+
+	require.NoError(t, tx.Commit(ctx))
+	relayAll()
+	assert.Equal(t, []string{first, second}, delivered())
+
+	pruned, err := outbox.Prune(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, pruned, int64(2))
+
+	messages, err := outbox.GetMessages(ctx, conn, first)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
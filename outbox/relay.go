@@ -0,0 +1,163 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+type Relay struct {
+	Publisher    Publisher
+	BatchSize    int
+	PollInterval time.Duration
+	// Retention is how long Run keeps published messages before pruning
+	// them, forever when zero.
+	Retention time.Duration
+}
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = 100 * time.Millisecond
+)
+
+func (r Relay) Run(ctx context.Context) error {
+	pollInterval := r.PollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Println(err)
+		}
+		if n > 0 && err == nil {
+			continue
+		}
+
+		if r.Retention > 0 {
+			_, err := Prune(ctx, time.Now().Add(-r.Retention))
+			if err != nil && ctx.Err() == nil {
+				log.Println(err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// RelayOnce publishes the oldest unpublished batch in seq order. Seq is taken
+// on insert while transactions commit in any order, so messages of
+// transactions newer than the oldest one still running are held back: a
+// transaction committing late cannot be overtaken by one that began after it.
+// A long transaction therefore delays the relay. Rows are locked with FOR
+// UPDATE (not SKIP LOCKED) so concurrent relays queue behind each other
+// instead of delivering out of order. A message is only marked after Publish
+// succeeded, so a crash in between redelivers it: consumers must deduplicate
+// by Seq.
+func (r Relay) RelayOnce(ctx context.Context) (int, error) {
+	batchSize := r.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+
+	published := 0
+	var pubErr error
+	err := db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		var messages []Message
+		err := pgxscan.Select(ctx, tx, &messages, `
+		SELECT
+			seq,
+			aggregate_id,
+			event_type,
+			payload,
+			created_on,
+			published_on
+		FROM OUTBOX
+		WHERE published_on IS NULL
+			AND txid < txid_snapshot_xmin(txid_current_snapshot())
+		ORDER BY seq ASC
+		LIMIT $1
+		FOR UPDATE`,
+			batchSize,
+		)
+		if err != nil {
+			return err
+		}
+
+		seqs := make([]int64, 0, len(messages))
+		for _, msg := range messages {
+			if err := r.Publisher.Publish(ctx, msg); err != nil {
+				pubErr = fmt.Errorf("cannot publish outbox message %d: %w", msg.Seq, err)
+				break
+			}
+			seqs = append(seqs, msg.Seq)
+		}
+
+		if len(seqs) == 0 {
+			return nil
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE OUTBOX SET published_on = now() WHERE seq = ANY($1)`, seqs)
+		if err != nil {
+			return err
+		}
+		published = len(seqs)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return published, pubErr
+}
+
+// Prune deletes the messages published before t.
+func Prune(ctx context.Context, t time.Time) (int64, error) {
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `DELETE FROM OUTBOX WHERE published_on < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+type InMemoryPublisher struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (p *InMemoryPublisher) Publish(_ context.Context, msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+func (p *InMemoryPublisher) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Message(nil), p.messages...)
+}
@@ -0,0 +1,53 @@
+package phantomread
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-faker/faker/v4"
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+// CountThreadCommentsTwice evaluates the same predicate twice in one
+// transaction while between inserts a matching row from another connection.
+func CountThreadCommentsTwice(ctx context.Context, txOpt pgx.TxOptions, threadId string, between func(ctx context.Context) error) (first, second int, err error) {
+	err = db.Atomic(ctx, txOpt, func(tx db.Connection) error {
+		first, err = repository.CountThreadComments(ctx, tx, threadId)
+		if err != nil {
+			return err
+		}
+
+		err = between(ctx)
+		if err != nil {
+			return err
+		}
+
+		second, err = repository.CountThreadComments(ctx, tx, threadId)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return first, second, nil
+}
+
+func InsertComment(ctx context.Context, threadId, userId string) error {
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	return repository.CreateComment(ctx, conn, repository.Comment{
+		Id:        helper.CommentId(),
+		ThreadId:  threadId,
+		UserId:    userId,
+		Content:   faker.Sentence(),
+		CreatedOn: time.Now(),
+		Version:   1,
+	})
+}
@@ -0,0 +1,62 @@
+package phantomread_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	phantomread "github.com/xyedo/db-concurency-problem/phantom-read"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestCountThreadCommentsTwice(t *testing.T) {
+	tests := []struct {
+		name        string
+		level       pgx.TxIsoLevel
+		wantAnomaly bool
+	}{
+		{
+			name:        "phantom in read committed",
+			level:       pgx.ReadCommitted,
+			wantAnomaly: true,
+		},
+		{
+			name:        "repeatable read snapshot prevents phantom in postgres",
+			level:       pgx.RepeatableRead,
+			wantAnomaly: false,
+		},
+		{
+			name:        "serializable prevents phantom",
+			level:       pgx.Serializable,
+			wantAnomaly: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, err := helper.CreateUser()
+			require.NoError(t, err)
+			threadId, err := helper.CreateThread(userId)
+			require.NoError(t, err)
+
+			first, second, err := phantomread.CountThreadCommentsTwice(context.Background(), pgx.TxOptions{IsoLevel: tt.level}, threadId, func(ctx context.Context) error {
+				return phantomread.InsertComment(ctx, threadId, userId)
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, 0, first)
+			assert.Equal(t, tt.wantAnomaly, first != second)
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+)
+
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusDead    = "dead"
+)
+
+var ErrNoJob = errors.New("no job available")
+
+type Job struct {
+	Id          string          `db:"id"`
+	Kind        string          `db:"kind"`
+	Payload     json.RawMessage `db:"payload"`
+	Status      string          `db:"status"`
+	Priority    int             `db:"priority"`
+	Attempt     int             `db:"attempt"`
+	MaxAttempt  int             `db:"max_attempt"`
+	RunAfter    time.Time       `db:"run_after"`
+	LockedUntil *time.Time      `db:"locked_until"`
+	LastError   *string         `db:"last_error"`
+	CreatedOn   time.Time       `db:"created_on"`
+	UpdatedOn   *time.Time      `db:"updated_on"`
+	Version     int             `db:"version"`
+}
+
+type EnqueueOption struct {
+	Priority   int
+	MaxAttempt int
+	RunAfter   time.Time
+}
+
+const defaultMaxAttempt = 5
+
+// Enqueue stores a new pending job. Passing the tx of db.Atomic makes the job
+// visible only when the surrounding business writes commit.
+func Enqueue(ctx context.Context, conn db.Connection, kind string, payload any, opts ...EnqueueOption) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	opt := EnqueueOption{MaxAttempt: defaultMaxAttempt, RunAfter: now}
+	if len(opts) > 0 {
+		opt.Priority = opts[0].Priority
+		if opts[0].MaxAttempt > 0 {
+			opt.MaxAttempt = opts[0].MaxAttempt
+		}
+		if !opts[0].RunAfter.IsZero() {
+			opt.RunAfter = opts[0].RunAfter
+		}
+	}
+
+	jobId := helper.JobId()
+	tag, err := conn.Exec(ctx, `INSERT INTO JOB (
+		id,
+		kind,
+		payload,
+		status,
+		priority,
+		attempt,
+		max_attempt,
+		run_after,
+		created_on,
+		version
+		) VALUES
+		($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		jobId,
+		kind,
+		body,
+		StatusPending,
+		opt.Priority,
+		0,
+		opt.MaxAttempt,
+		opt.RunAfter,
+		now,
+		1,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return "", errors.New("nothing was inserted, something went wrong")
+	}
+
+	return jobId, nil
+}
+
+// Dequeue claims the most urgent runnable job for visibilityTimeout. A running
+// job whose lock expired is considered abandoned and can be claimed again,
+// unless it used up all of its attempts: it is marked dead instead.
+func Dequeue(ctx context.Context, conn db.Connection, visibilityTimeout time.Duration, kinds ...string) (Job, error) {
+	var job Job
+	err := pgxscan.Get(ctx, conn, &job, `
+	WITH exhausted AS (
+		UPDATE JOB SET
+			status = $5,
+			locked_until = NULL,
+			last_error = 'lock expired on the last attempt',
+			updated_on = now(),
+			version = version + 1
+		WHERE status = $1 AND locked_until < now() AND attempt >= max_attempt
+	)
+	UPDATE JOB SET
+		status = $1,
+		attempt = attempt + 1,
+		locked_until = now() + $2 * interval '1 millisecond',
+		updated_on = now(),
+		version = version + 1
+	WHERE id = (
+		SELECT id
+		FROM JOB
+		WHERE (
+			(status = $3 AND run_after <= now()) OR
+			(status = $1 AND locked_until < now() AND attempt < max_attempt)
+		)
+		AND (coalesce(cardinality($4::text[]), 0) = 0 OR kind = ANY($4::text[]))
+		ORDER BY priority DESC, run_after ASC
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING
+		id,
+		kind,
+		payload,
+		status,
+		priority,
+		attempt,
+		max_attempt,
+		run_after,
+		locked_until,
+		last_error,
+		created_on,
+		updated_on,
+		version`,
+		StatusRunning,
+		visibilityTimeout.Milliseconds(),
+		StatusPending,
+		kinds,
+		StatusDead,
+	)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return Job{}, ErrNoJob
+		}
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+func Complete(ctx context.Context, conn db.Connection, job Job) error {
+	tag, err := conn.Exec(ctx, `
+	UPDATE JOB SET
+		status = $2,
+		locked_until = NULL,
+		updated_on = now(),
+		version = version + 1
+	WHERE id = $1 AND version = $3`,
+		job.Id,
+		StatusDone,
+		job.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return db.ErrVersionMisMatch
+	}
+
+	return nil
+}
+
+// Fail puts the job back to pending after backoff, or marks it dead once it
+// used up all of its attempts.
+func Fail(ctx context.Context, conn db.Connection, job Job, cause error, backoff time.Duration) error {
+	status := StatusPending
+	if job.Attempt >= job.MaxAttempt {
+		status = StatusDead
+	}
+
+	tag, err := conn.Exec(ctx, `
+	UPDATE JOB SET
+		status = $2,
+		run_after = now() + $3 * interval '1 millisecond',
+		locked_until = NULL,
+		last_error = $4,
+		updated_on = now(),
+		version = version + 1
+	WHERE id = $1 AND version = $5`,
+		job.Id,
+		status,
+		backoff.Milliseconds(),
+		cause.Error(),
+		job.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return db.ErrVersionMisMatch
+	}
+
+	return nil
+}
+
+func GetJob(ctx context.Context, conn db.Connection, id string) (Job, error) {
+	var job Job
+	err := pgxscan.Get(ctx, conn, &job, `
+	SELECT
+		id,
+		kind,
+		payload,
+		status,
+		priority,
+		attempt,
+		max_attempt,
+		run_after,
+		locked_until,
+		last_error,
+		created_on,
+		updated_on,
+		version
+	FROM JOB
+	WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return job, nil
+}
@@ -0,0 +1,200 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/queue"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestEnqueueIsTransactional(t *testing.T) {
+	ctx := context.Background()
+	kind := "test.rollback." + helper.JobId()
+
+	var jobId string
+	err := db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		var err error
+		jobId, err = queue.Enqueue(ctx, tx, kind, map[string]string{"hello": "world"})
+		if err != nil {
+			return err
+		}
+
+		return errors.New("business write failed")
+	})
+	require.Error(t, err)
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	_, err = queue.GetJob(ctx, conn, jobId)
+	require.ErrorIs(t, err, pgx.ErrNoRows)
+}
+
+func TestWorkerProcessEachJobOnce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	kind := "test.once." + helper.JobId()
+	const totalJob = 50
+	for i := 0; i < totalJob; i++ {
+		err := db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+			_, err := queue.Enqueue(ctx, tx, kind, i)
+			return err
+		})
+		require.NoError(t, err)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[int]int)
+	w := queue.Worker{
+		Concurrency: 8,
+		Handlers: map[string]queue.HandlerFunc{
+			kind: func(ctx context.Context, job queue.Job) error {
+				var n int
+				if err := json.Unmarshal(job.Payload, &n); err != nil {
+					return err
+				}
+				mu.Lock()
+				seen[n]++
+				mu.Unlock()
+				return nil
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := w.ProcessOne(ctx)
+				if errors.Is(err, queue.ErrNoJob) {
+					return
+				}
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, seen, totalJob)
+	for n, count := range seen {
+		assert.Equal(t, 1, count, "job %d processed more than once", n)
+	}
+}
+
+func TestWorkerRetryThenDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	kind := "test.dead." + helper.JobId()
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	jobId, err := queue.Enqueue(ctx, conn, kind, nil, queue.EnqueueOption{MaxAttempt: 2})
+	require.NoError(t, err)
+
+	w := queue.Worker{
+		Handlers: map[string]queue.HandlerFunc{
+			kind: func(ctx context.Context, job queue.Job) error {
+				return errors.New("always failing")
+			},
+		},
+		Backoff: func(attempt int) time.Duration { return 0 },
+	}
+
+	require.Error(t, w.ProcessOne(ctx))
+	job, err := queue.GetJob(ctx, conn, jobId)
+	require.NoError(t, err)
+	assert.Equal(t, queue.StatusPending, job.Status)
+	assert.Equal(t, 1, job.Attempt)
+
+	require.Error(t, w.ProcessOne(ctx))
+	job, err = queue.GetJob(ctx, conn, jobId)
+	require.NoError(t, err)
+	assert.Equal(t, queue.StatusDead, job.Status)
+	require.NotNil(t, job.LastError)
+	assert.Equal(t, "always failing", *job.LastError)
+
+	require.ErrorIs(t, w.ProcessOne(ctx), queue.ErrNoJob)
+}
+
+func TestDequeueReclaimExpiredVisibility(t *testing.T) {
+	ctx := context.Background()
+	kind := "test.visibility." + helper.JobId()
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	jobId, err := queue.Enqueue(ctx, conn, kind, nil)
+	require.NoError(t, err)
+
+	job, err := queue.Dequeue(ctx, conn, 50*time.Millisecond, kind)
+	require.NoError(t, err)
+	require.Equal(t, jobId, job.Id)
+
+	_, err = queue.Dequeue(ctx, conn, 50*time.Millisecond, kind)
+	require.ErrorIs(t, err, queue.ErrNoJob)
+
+	time.Sleep(100 * time.Millisecond)
+	job, err = queue.Dequeue(ctx, conn, time.Second, kind)
+	require.NoError(t, err)
+	assert.Equal(t, jobId, job.Id)
+	assert.Equal(t, 2, job.Attempt)
+}
+
+func TestDequeueDeadLetterExhaustedExpiredVisibility(t *testing.T) {
+	ctx := context.Background()
+	kind := "test.exhausted." + helper.JobId()
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	jobId, err := queue.Enqueue(ctx, conn, kind, nil, queue.EnqueueOption{MaxAttempt: 1})
+	require.NoError(t, err)
+
+	_, err = queue.Dequeue(ctx, conn, 50*time.Millisecond, kind)
+	require.NoError(t, err)
+
+	// the only attempt never completed, its job is dead rather than retried
+	time.Sleep(100 * time.Millisecond)
+	_, err = queue.Dequeue(ctx, conn, time.Second, kind)
+	require.ErrorIs(t, err, queue.ErrNoJob)
+
+	job, err := queue.GetJob(ctx, conn, jobId)
+	require.NoError(t, err)
+	assert.Equal(t, queue.StatusDead, job.Status)
+	assert.Equal(t, 1, job.Attempt)
+	assert.Nil(t, job.LockedUntil)
+	assert.NotNil(t, job.LastError)
+}
+
+func TestWorkerWithoutHandler(t *testing.T) {
+	ctx := context.Background()
+
+	err := queue.Worker{}.ProcessOne(ctx)
+	assert.ErrorIs(t, err, queue.ErrNoHandler)
+}
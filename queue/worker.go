@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+type HandlerFunc func(ctx context.Context, job Job) error
+
+type Worker struct {
+	Handlers          map[string]HandlerFunc
+	Concurrency       int
+	VisibilityTimeout time.Duration
+	PollInterval      time.Duration
+	Backoff           func(attempt int) time.Duration
+}
+
+const (
+	defaultVisibilityTimeout = 30 * time.Second
+	defaultPollInterval      = 100 * time.Millisecond
+)
+
+func ExponentialBackoff(attempt int) time.Duration {
+	if attempt > 10 {
+		attempt = 10
+	}
+	return 100 * time.Millisecond << attempt
+}
+
+// Run polls for jobs until ctx is cancelled. Each goroutine claims one job at a
+// time, so Concurrency bounds the number of handlers running in parallel.
+func (w Worker) Run(ctx context.Context) error {
+	concurrency := w.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+func (w Worker) loop(ctx context.Context) {
+	pollInterval := w.PollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	for {
+		err := w.ProcessOne(ctx)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrNoJob) && ctx.Err() == nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+var ErrNoHandler = errors.New("no handler registered")
+
+// ProcessOne claims and handles a single job. It returns ErrNoJob when the
+// queue has nothing runnable for the registered kinds, ErrNoHandler when no
+// kind is registered.
+func (w Worker) ProcessOne(ctx context.Context) error {
+	if len(w.Handlers) == 0 {
+		return ErrNoHandler
+	}
+
+	visibilityTimeout := w.VisibilityTimeout
+	if visibilityTimeout == 0 {
+		visibilityTimeout = defaultVisibilityTimeout
+	}
+	backoff := w.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff
+	}
+
+	kinds := make([]string, 0, len(w.Handlers))
+	for kind := range w.Handlers {
+		kinds = append(kinds, kind)
+	}
+
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	job, err := Dequeue(ctx, conn, visibilityTimeout, kinds...)
+	if err != nil {
+		return err
+	}
+
+	handlerCtx, cancel := context.WithTimeout(ctx, visibilityTimeout)
+	defer cancel()
+
+	handler := w.Handlers[job.Kind]
+	if handler == nil {
+		err = fmt.Errorf("%w for kind %q", ErrNoHandler, job.Kind)
+	} else {
+		err = handler(handlerCtx, job)
+	}
+	if err != nil {
+		if failErr := Fail(ctx, conn, job, err, backoff(job.Attempt)); failErr != nil {
+			return fmt.Errorf("cannot mark job %s as failed %w: %w", job.Id, failErr, err)
+		}
+
+		return fmt.Errorf("job %s failed: %w", job.Id, err)
+	}
+
+	return Complete(ctx, conn, job)
+}
@@ -0,0 +1,118 @@
+package readonlyanomaly
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+// The read-only transaction anomaly from Fekete, O'Neil and O'Neil (2004),
+// played on the reaction counters of two threads used as a checking (X) and a
+// savings (Y) balance, both starting at 0:
+//
+//	T2: reads X and Y, then withdraws 10 from X, plus a penalty of 1 when the
+//	    total it saw would go negative
+//	T1: deposits 20 into Y and commits while T2 is still open
+//	T3: read-only, reports X and Y after T1 committed but before T2 did
+//	T2: writes X and commits
+//
+// T2 charged the penalty, so it must be ordered before T1; T3 saw the deposit
+// but not the withdrawal, so it must be after T1 and before T2. No serial
+// order satisfies both, although T1 and T2 alone are serializable.
+const (
+	Withdraw = 10
+	Deposit  = 20
+	Penalty  = 1
+)
+
+type Outcome struct {
+	// ReportX and ReportY are the balances observed by the read-only T3.
+	ReportX int
+	ReportY int
+	FinalX  int
+	FinalY  int
+
+	WithdrawErr error
+	ReportErr   error
+}
+
+// Anomaly is true when T3's report cannot be explained by any serial order of
+// the committed transactions.
+func (o Outcome) Anomaly() bool {
+	if o.WithdrawErr != nil || o.ReportErr != nil {
+		return false
+	}
+
+	return o.FinalX == -(Withdraw+Penalty) && o.ReportX == 0 && o.ReportY == Deposit
+}
+
+func Run(ctx context.Context, txOpt pgx.TxOptions, checkingThreadId, savingsThreadId string) (Outcome, error) {
+	var outcome Outcome
+	outcome.WithdrawErr = db.Atomic(ctx, txOpt, func(t2 db.Connection) error {
+		checking, err := repository.GetThread(ctx, t2, checkingThreadId)
+		if err != nil {
+			return err
+		}
+		savings, err := repository.GetThread(ctx, t2, savingsThreadId)
+		if err != nil {
+			return err
+		}
+
+		err = db.Atomic(ctx, txOpt, func(t1 db.Connection) error {
+			savings, err := repository.GetThread(ctx, t1, savingsThreadId)
+			if err != nil {
+				return err
+			}
+
+			savings.TotalReaction += Deposit
+			return repository.UpdateThread(ctx, t1, savings)
+		})
+		if err != nil {
+			return err
+		}
+
+		readOnly := txOpt
+		readOnly.AccessMode = pgx.ReadOnly
+		outcome.ReportErr = db.Atomic(ctx, readOnly, func(t3 db.Connection) error {
+			checking, err := repository.GetThread(ctx, t3, checkingThreadId)
+			if err != nil {
+				return err
+			}
+			savings, err := repository.GetThread(ctx, t3, savingsThreadId)
+			if err != nil {
+				return err
+			}
+
+			outcome.ReportX = checking.TotalReaction
+			outcome.ReportY = savings.TotalReaction
+			return nil
+		})
+
+		checking.TotalReaction -= Withdraw
+		if checking.TotalReaction+savings.TotalReaction < 0 {
+			checking.TotalReaction -= Penalty
+		}
+		return repository.UpdateThread(ctx, t2, checking)
+	})
+
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return Outcome{}, err
+	}
+	defer conn.Release()
+
+	checking, err := repository.GetThread(ctx, conn, checkingThreadId)
+	if err != nil {
+		return Outcome{}, err
+	}
+	savings, err := repository.GetThread(ctx, conn, savingsThreadId)
+	if err != nil {
+		return Outcome{}, err
+	}
+	outcome.FinalX = checking.TotalReaction
+	outcome.FinalY = savings.TotalReaction
+
+	return outcome, nil
+}
@@ -0,0 +1,71 @@
+package readonlyanomaly_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	readonlyanomaly "github.com/xyedo/db-concurency-problem/read-only-anomaly"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		level       pgx.TxIsoLevel
+		wantAnomaly bool
+	}{
+		{
+			name:        "read committed exhibits it",
+			level:       pgx.ReadCommitted,
+			wantAnomaly: true,
+		},
+		{
+			name:        "snapshot isolation exhibits it even for a read only transaction",
+			level:       pgx.RepeatableRead,
+			wantAnomaly: true,
+		},
+		{
+			name:        "serializable aborts one of the transactions",
+			level:       pgx.Serializable,
+			wantAnomaly: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, err := helper.CreateUser()
+			require.NoError(t, err)
+			checking, err := helper.CreateThread(userId)
+			require.NoError(t, err)
+			savings, err := helper.CreateThread(userId)
+			require.NoError(t, err)
+
+			outcome, err := readonlyanomaly.Run(context.Background(), pgx.TxOptions{IsoLevel: tt.level}, checking, savings)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAnomaly, outcome.Anomaly(), "%+v", outcome)
+
+			if !tt.wantAnomaly {
+				failed := outcome.WithdrawErr
+				if failed == nil {
+					failed = outcome.ReportErr
+				}
+				var pgErr *pgconn.PgError
+				require.ErrorAs(t, failed, &pgErr)
+				assert.Equal(t, "40001", pgErr.Code)
+			}
+		})
+	}
+}
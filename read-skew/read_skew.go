@@ -0,0 +1,62 @@
+package readskew
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-faker/faker/v4"
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+// ReadCommentCounter reads the denormalized THREAD.total_comment and then the
+// actual number of comments, calling between in the middle to commit a new
+// comment (and its counter increment). Both values should always agree.
+func ReadCommentCounter(ctx context.Context, txOpt pgx.TxOptions, threadId string, between func(ctx context.Context) error) (totalComment, commentCount int, err error) {
+	err = db.Atomic(ctx, txOpt, func(tx db.Connection) error {
+		thread, err := repository.GetThread(ctx, tx, threadId)
+		if err != nil {
+			return err
+		}
+		totalComment = thread.TotalComment
+
+		err = between(ctx)
+		if err != nil {
+			return err
+		}
+
+		commentCount, err = repository.CountThreadComments(ctx, tx, threadId)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return totalComment, commentCount, nil
+}
+
+func AddComment(ctx context.Context, threadId, userId string) error {
+	return db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		thread, err := repository.GetThread(ctx, tx, threadId, repository.GetThreadOption{ForUpdate: true})
+		if err != nil {
+			return err
+		}
+
+		err = repository.CreateComment(ctx, tx, repository.Comment{
+			Id:        helper.CommentId(),
+			ThreadId:  threadId,
+			UserId:    userId,
+			Content:   faker.Sentence(),
+			CreatedOn: time.Now(),
+			Version:   1,
+		})
+		if err != nil {
+			return err
+		}
+
+		thread.TotalComment++
+		return repository.UpdateThread(ctx, tx, thread)
+	})
+}
@@ -0,0 +1,61 @@
+package readskew_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	readskew "github.com/xyedo/db-concurency-problem/read-skew"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestReadCommentCounter(t *testing.T) {
+	tests := []struct {
+		name        string
+		level       pgx.TxIsoLevel
+		wantAnomaly bool
+	}{
+		{
+			name:        "counter and count disagree in read committed",
+			level:       pgx.ReadCommitted,
+			wantAnomaly: true,
+		},
+		{
+			name:        "repeatable read sees one consistent snapshot",
+			level:       pgx.RepeatableRead,
+			wantAnomaly: false,
+		},
+		{
+			name:        "serializable sees one consistent snapshot",
+			level:       pgx.Serializable,
+			wantAnomaly: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, err := helper.CreateUser()
+			require.NoError(t, err)
+			threadId, err := helper.CreateThread(userId)
+			require.NoError(t, err)
+
+			totalComment, commentCount, err := readskew.ReadCommentCounter(context.Background(), pgx.TxOptions{IsoLevel: tt.level}, threadId, func(ctx context.Context) error {
+				return readskew.AddComment(ctx, threadId, userId)
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantAnomaly, totalComment != commentCount)
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+type CompareOptions struct {
+	// Threshold is the relative change of a metric, or the absolute change of
+	// the failure rate, that counts as a regression, 0.1 is 10%.
+	Threshold float64
+	// Alpha is the significance level a latency change must reach.
+	Alpha float64
+}
+
+// Change compares the run of one scenario variant against its baseline.
+// Relative changes are current/baseline - 1.
+type Change struct {
+	Scenario string
+	Variant  string
+	Baseline *Result
+	Current  *Result
+
+	Throughput float64
+	P50        float64
+	P99        float64
+	// P is the Mann-Whitney p-value of the operation latencies, Effect the
+	// probability that a current operation is slower than a baseline one.
+	P      float64
+	Effect float64
+
+	Regressions []string
+	Notes       []string
+}
+
+type Comparison struct {
+	Options CompareOptions
+	Changes []Change
+}
+
+func (c Comparison) Regressed() bool {
+	for _, change := range c.Changes {
+		if len(change.Regressions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func key(r Result) string {
+	return r.Scenario + "\x00" + r.Variant
+}
+
+// Compare matches results by scenario and variant. Latency only regresses
+// when the Mann-Whitney test finds the current run significantly slower and
+// its median grew beyond the threshold; throughput, failure rate and
+// correctness are compared directly.
+func Compare(baseline, current []Result, opts CompareOptions) Comparison {
+	base := make(map[string]*Result, len(baseline))
+	for i := range baseline {
+		base[key(baseline[i])] = &baseline[i]
+	}
+
+	comparison := Comparison{Options: opts}
+	seen := make(map[string]bool)
+	for i := range current {
+		cur := &current[i]
+		seen[key(*cur)] = true
+
+		change := Change{Scenario: cur.Scenario, Variant: cur.Variant, Current: cur, P: 1, Effect: 0.5}
+		b, ok := base[key(*cur)]
+		if !ok {
+			change.Notes = append(change.Notes, "no baseline")
+			comparison.Changes = append(comparison.Changes, change)
+			continue
+		}
+		change.Baseline = b
+		compare(&change, opts)
+		comparison.Changes = append(comparison.Changes, change)
+	}
+
+	for _, b := range baseline {
+		if !seen[key(b)] {
+			b := b
+			comparison.Changes = append(comparison.Changes, Change{
+				Scenario: b.Scenario,
+				Variant:  b.Variant,
+				Baseline: &b,
+				P:        1,
+				Effect:   0.5,
+				Notes:    []string{"missing from the current run"},
+			})
+		}
+	}
+
+	sort.SliceStable(comparison.Changes, func(i, j int) bool {
+		a, b := comparison.Changes[i], comparison.Changes[j]
+		if a.Scenario != b.Scenario {
+			return a.Scenario < b.Scenario
+		}
+		return a.Variant < b.Variant
+	})
+	return comparison
+}
+
+func compare(c *Change, opts CompareOptions) {
+	b, cur := c.Baseline, c.Current
+	c.Throughput = relative(b.Throughput, cur.Throughput)
+	c.P50 = relative(float64(b.Latency.P50), float64(cur.Latency.P50))
+	c.P99 = relative(float64(b.Latency.P99), float64(cur.Latency.P99))
+
+	if b.Correct && !cur.Correct {
+		c.Regressions = append(c.Regressions, "became incorrect: "+cur.Detail)
+	}
+	if c.Throughput < -opts.Threshold {
+		c.Regressions = append(c.Regressions, fmt.Sprintf("throughput %+.1f%%", c.Throughput*100))
+	}
+	if before, after := failureRate(*b), failureRate(*cur); after-before > opts.Threshold {
+		c.Regressions = append(c.Regressions, fmt.Sprintf("failure rate %.1f%% -> %.1f%%", before*100, after*100))
+	}
+
+	if b.Histograms.Operations == nil || cur.Histograms.Operations == nil {
+		c.Notes = append(c.Notes, "no latency histogram, significance not tested")
+		return
+	}
+	_, c.P, c.Effect = MannWhitney(b.Histograms.Operations, cur.Histograms.Operations)
+	if c.P >= opts.Alpha || c.Effect <= 0.5 {
+		return
+	}
+	if c.P50 > opts.Threshold {
+		c.Regressions = append(c.Regressions, fmt.Sprintf("p50 latency %+.1f%% (p=%.3g)", c.P50*100, c.P))
+	}
+	if c.P99 > opts.Threshold {
+		c.Regressions = append(c.Regressions, fmt.Sprintf("p99 latency %+.1f%% (p=%.3g)", c.P99*100, c.P))
+	}
+}
+
+func relative(before, after float64) float64 {
+	if before == 0 {
+		if after == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return after/before - 1
+}
+
+func failureRate(r Result) float64 {
+	if r.Operations == 0 {
+		return 0
+	}
+	return float64(r.TotalFailed()) / float64(r.Operations)
+}
+
+func WriteComparison(w io.Writer, c Comparison) error {
+	var b strings.Builder
+	b.WriteString("| scenario | variant | ops/s | p50 | p99 | p-value | verdict |\n")
+	b.WriteString("|---|---|--:|--:|--:|--:|---|\n")
+	for _, change := range c.Changes {
+		verdict := "ok"
+		if len(change.Regressions) > 0 {
+			verdict = "**regressed**: " + strings.Join(change.Regressions, "; ")
+		}
+		if len(change.Notes) > 0 {
+			verdict += " (" + strings.Join(change.Notes, "; ") + ")"
+		}
+		if change.Baseline == nil || change.Current == nil {
+			fmt.Fprintf(&b, "| %s | %s | - | - | - | - | %s |\n", change.Scenario, change.Variant, verdict)
+			continue
+		}
+
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %.3g | %s |\n",
+			change.Scenario,
+			change.Variant,
+			delta(fmt.Sprintf("%.1f", change.Baseline.Throughput), fmt.Sprintf("%.1f", change.Current.Throughput), change.Throughput),
+			delta(round(change.Baseline.Latency.P50).String(), round(change.Current.Latency.P50).String(), change.P50),
+			delta(round(change.Baseline.Latency.P99).String(), round(change.Current.Latency.P99).String(), change.P99),
+			change.P,
+			strings.ReplaceAll(verdict, "|", `\|`),
+		)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func delta(before, after string, change float64) string {
+	return fmt.Sprintf("%s → %s (%+.1f%%)", before, after, change*100)
+}
@@ -0,0 +1,179 @@
+package report_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/report"
+)
+
+func values(vs ...time.Duration) *report.Histogram {
+	h := report.NewHistogram()
+	for _, v := range vs {
+		h.Record(v)
+	}
+	return h
+}
+
+func TestMannWhitney(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   *report.Histogram
+		u      float64
+		p      float64
+		effect float64
+	}{
+		{
+			name:   "b entirely above a",
+			a:      values(1, 2, 3, 4, 5),
+			b:      values(6, 7, 8, 9, 10),
+			u:      25,
+			p:      0.0122,
+			effect: 1,
+		},
+		{
+			name:   "interleaved",
+			a:      values(1, 3, 5, 7, 9),
+			b:      values(2, 4, 6, 8, 10),
+			u:      15,
+			p:      0.676,
+			effect: 0.6,
+		},
+		{
+			name:   "identical",
+			a:      values(1, 2, 2, 3),
+			b:      values(1, 2, 2, 3),
+			u:      8,
+			p:      1,
+			effect: 0.5,
+		},
+		{
+			name:   "empty",
+			a:      values(),
+			b:      values(1),
+			p:      1,
+			effect: 0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, p, effect := report.MannWhitney(tt.a, tt.b)
+			assert.InDelta(t, tt.u, u, 1e-9)
+			assert.InDelta(t, tt.p, p, 1e-3)
+			assert.InDelta(t, tt.effect, effect, 1e-9)
+		})
+	}
+}
+
+func run(variant string, median time.Duration, throughput float64, correct bool) report.Result {
+	r := rand.New(rand.NewSource(1))
+	h := report.NewHistogram()
+	for i := 0; i < 500; i++ {
+		h.Record(median + time.Duration(r.NormFloat64()*float64(median)/10))
+	}
+
+	return report.Result{
+		Scenario:   "lost-update",
+		Variant:    variant,
+		Operations: 500,
+		Succeeded:  500,
+		Throughput: throughput,
+		Latency:    h.Latency(),
+		Histograms: report.Histograms{Operations: h},
+		Correct:    correct,
+	}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := []report.Result{
+		run("locking", 10*time.Millisecond, 100, true),
+		run("cas", 10*time.Millisecond, 100, true),
+		run("repeatable read", 10*time.Millisecond, 100, true),
+		run("saga", 10*time.Millisecond, 100, true),
+	}
+	current := []report.Result{
+		run("locking", 10*time.Millisecond, 98, true),
+		run("cas", 13*time.Millisecond, 100, true),
+		run("repeatable read", 10*time.Millisecond, 70, false),
+		run("event sourced", 10*time.Millisecond, 100, true),
+	}
+	current[2].Failed = map[string]int{"retry limit exceeded!": 100}
+	current[2].Detail = "total_reaction=400, succeeded reactions=500"
+
+	c := report.Compare(baseline, current, report.CompareOptions{Threshold: 0.1, Alpha: 0.05})
+	require.True(t, c.Regressed())
+
+	byVariant := make(map[string]report.Change)
+	for _, change := range c.Changes {
+		byVariant[change.Variant] = change
+	}
+
+	assert.Empty(t, byVariant["locking"].Regressions)
+	assert.Greater(t, byVariant["locking"].P, 0.05)
+
+	cas := byVariant["cas"]
+	require.Len(t, cas.Regressions, 2)
+	assert.Contains(t, cas.Regressions[0], "p50 latency +30")
+	assert.Contains(t, cas.Regressions[1], "p99 latency")
+	assert.Less(t, cas.P, 1e-6)
+	assert.Greater(t, cas.Effect, 0.9)
+
+	assert.Equal(t, []string{
+		"became incorrect: total_reaction=400, succeeded reactions=500",
+		"throughput -30.0%",
+		"failure rate 0.0% -> 20.0%",
+	}, byVariant["repeatable read"].Regressions)
+
+	assert.Equal(t, []string{"missing from the current run"}, byVariant["saga"].Notes)
+	assert.Equal(t, []string{"no baseline"}, byVariant["event sourced"].Notes)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteComparison(&buf, c))
+	assert.Contains(t, buf.String(), "| lost-update | cas | 100.0 → 100.0 (+0.0%) |")
+	assert.Contains(t, buf.String(), "**regressed**: became incorrect")
+	assert.Contains(t, buf.String(), "| lost-update | saga | - | - | - | - | ok (missing from the current run) |")
+}
+
+func TestCompareWithoutHistograms(t *testing.T) {
+	base, cur := run("cas", time.Millisecond, 100, true), run("cas", 2*time.Millisecond, 100, true)
+	base.Histograms, cur.Histograms = report.Histograms{}, report.Histograms{}
+
+	c := report.Compare([]report.Result{base}, []report.Result{cur}, report.CompareOptions{Threshold: 0.1, Alpha: 0.05})
+	assert.False(t, c.Regressed())
+	assert.Equal(t, []string{"no latency histogram, significance not tested"}, c.Changes[0].Notes)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	older := run("cas", time.Millisecond, 100, true)
+	older.StartedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := run("cas", 2*time.Millisecond, 50, true)
+	newer.StartedAt = older.StartedAt.Add(time.Hour)
+	other := run("saga", time.Millisecond, 100, false)
+	other.StartedAt = older.StartedAt
+
+	paths, err := report.Save(dir, older, newer, other)
+	require.NoError(t, err)
+	require.Len(t, paths, 3)
+	assert.Equal(t, "20240101T000000_lost-update_cas.json", paths[0][len(dir)+1:])
+
+	results, err := report.Load(dir)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "cas", results[0].Variant)
+	assert.Equal(t, 50.0, results[0].Throughput)
+	assert.Equal(t, newer.Latency, results[0].Latency)
+	assert.Equal(t, "saga", results[1].Variant)
+
+	single, err := report.Load(paths[0])
+	require.NoError(t, err)
+	require.Len(t, single, 1)
+	assert.Equal(t, 100.0, single[0].Throughput)
+
+	_, err = report.Load(dir + "/missing.json")
+	assert.Error(t, err)
+}
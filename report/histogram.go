@@ -0,0 +1,188 @@
+package report
+
+import (
+	"encoding/json"
+	"math/bits"
+	"time"
+)
+
+// subBucketBits sets the precision: every power of two range is split in
+// 2^subBucketBits linear buckets, so a recorded value is off by at most 1/128.
+const (
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+)
+
+// Histogram is an HDR-style log-linear latency histogram. It keeps a constant
+// relative error whatever the range of the values, and its memory only grows
+// with the logarithm of the largest value. It is not safe for concurrent use.
+type Histogram struct {
+	counts []int64
+	total  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func bucketOf(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+
+	exp := bits.Len64(uint64(v)) - subBucketBits - 1
+	return (exp+1)*subBucketCount + int(v>>exp) - subBucketCount
+}
+
+// bounds returns the lowest and highest value that fall in bucket i.
+func bounds(i int) (int64, int64) {
+	if i < subBucketCount {
+		return int64(i), int64(i)
+	}
+
+	exp := i/subBucketCount - 1
+	sub := int64(i%subBucketCount + subBucketCount)
+	return sub << exp, (sub+1)<<exp - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	h.RecordN(d, 1)
+}
+
+func (h *Histogram) RecordN(d time.Duration, n int64) {
+	if n <= 0 {
+		return
+	}
+	v := max(int64(d), 0)
+
+	i := bucketOf(v)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, i-len(h.counts)+1)...)
+	}
+	h.counts[i] += n
+
+	if h.total == 0 || v < h.min {
+		h.min = v
+	}
+	h.max = max(h.max, v)
+	h.total += n
+	h.sum += v * n
+}
+
+func (h *Histogram) Merge(other *Histogram) {
+	if other.total == 0 {
+		return
+	}
+
+	if len(other.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]int64, len(other.counts)-len(h.counts))...)
+	}
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	h.max = max(h.max, other.max)
+	h.total += other.total
+	h.sum += other.sum
+}
+
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+func (h *Histogram) Min() time.Duration {
+	return time.Duration(h.min)
+}
+
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / h.total)
+}
+
+// Quantile returns the highest value equivalent to the q-th quantile
+// (0 <= q <= 1), capped by the largest recorded value.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := int64(q*float64(h.total) + 0.5)
+	rank = max(1, min(rank, h.total))
+
+	var seen int64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			_, high := bounds(i)
+			return time.Duration(min(high, h.max))
+		}
+	}
+	return time.Duration(h.max)
+}
+
+// Latency summarizes the histogram.
+func (h *Histogram) Latency() Latency {
+	return Latency{
+		Count: h.total,
+		Mean:  h.Mean(),
+		P50:   h.Quantile(0.50),
+		P95:   h.Quantile(0.95),
+		P99:   h.Quantile(0.99),
+		Max:   h.Max(),
+	}
+}
+
+// Each calls fn with the lowest value of every non empty bucket.
+func (h *Histogram) Each(fn func(value time.Duration, count int64)) {
+	for i, count := range h.counts {
+		if count > 0 {
+			low, _ := bounds(i)
+			fn(time.Duration(low), count)
+		}
+	}
+}
+
+type histogramJSON struct {
+	Count   int64      `json:"count"`
+	Sum     int64      `json:"sum_ns"`
+	Min     int64      `json:"min_ns"`
+	Max     int64      `json:"max_ns"`
+	Buckets [][2]int64 `json:"buckets"`
+}
+
+// MarshalJSON stores the non empty buckets as [lowest value in ns, count]
+// pairs.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	out := histogramJSON{Count: h.total, Sum: h.sum, Min: h.min, Max: h.max, Buckets: [][2]int64{}}
+	h.Each(func(value time.Duration, count int64) {
+		out.Buckets = append(out.Buckets, [2]int64{int64(value), count})
+	})
+
+	return json.Marshal(out)
+}
+
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var in histogramJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	*h = Histogram{}
+	for _, bucket := range in.Buckets {
+		h.RecordN(time.Duration(bucket[0]), bucket[1])
+	}
+	h.total, h.sum, h.min, h.max = in.Count, in.Sum, in.Min, in.Max
+	return nil
+}
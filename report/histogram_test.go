@@ -0,0 +1,80 @@
+package report_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/report"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := report.NewHistogram()
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.5, 5 * time.Millisecond},
+		{0.95, 9500 * time.Microsecond},
+		{0.99, 9900 * time.Microsecond},
+		{1, 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got := h.Quantile(tt.q)
+		assert.InEpsilon(t, float64(tt.want), float64(got), 1.0/128, "q=%v got %s", tt.q, got)
+	}
+
+	assert.EqualValues(t, 10000, h.Count())
+	assert.Equal(t, time.Microsecond, h.Min())
+	assert.Equal(t, 10*time.Millisecond, h.Max())
+	assert.Equal(t, 5000500*time.Nanosecond, h.Mean())
+}
+
+func TestHistogramSmallValuesAreExact(t *testing.T) {
+	h := report.NewHistogram()
+	for _, v := range []time.Duration{3, 1, 2, 0, 127} {
+		h.Record(v)
+	}
+
+	assert.Equal(t, time.Duration(2), h.Quantile(0.5))
+	assert.Equal(t, time.Duration(127), h.Quantile(1))
+	assert.Equal(t, time.Duration(0), report.NewHistogram().Quantile(0.5))
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := report.NewHistogram(), report.NewHistogram()
+	a.Record(time.Millisecond)
+	b.Record(time.Second)
+	b.Record(time.Microsecond)
+
+	a.Merge(b)
+	assert.EqualValues(t, 3, a.Count())
+	assert.Equal(t, time.Microsecond, a.Min())
+	assert.Equal(t, time.Second, a.Max())
+	assert.Equal(t, time.Second, a.Quantile(1))
+
+	empty := report.NewHistogram()
+	empty.Merge(a)
+	assert.Equal(t, a.Latency(), empty.Latency())
+}
+
+func TestHistogramJSON(t *testing.T) {
+	h := report.NewHistogram()
+	for i := 0; i < 1000; i++ {
+		h.Record(time.Duration(i*i) * time.Microsecond)
+	}
+
+	data, err := json.Marshal(h)
+	require.NoError(t, err)
+
+	decoded := report.NewHistogram()
+	require.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, h.Latency(), decoded.Latency())
+	assert.Equal(t, h.Min(), decoded.Min())
+}
@@ -0,0 +1,65 @@
+package report
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// MannWhitney tests whether latencies in b tend to be larger or smaller than
+// in a, without assuming any distribution. Every histogram bucket is a group of
+// tied values. It returns the U statistic of b, the two-sided p-value from the
+// normal approximation with tie correction, and the probability that a value
+// of b is larger than a value of a (0.5 means no difference).
+func MannWhitney(a, b *Histogram) (u, p, effect float64) {
+	n1, n2 := float64(a.Count()), float64(b.Count())
+	if n1 == 0 || n2 == 0 {
+		return 0, 1, 0.5
+	}
+
+	counts := make(map[time.Duration][2]int64)
+	a.Each(func(v time.Duration, c int64) {
+		e := counts[v]
+		e[0] += c
+		counts[v] = e
+	})
+	b.Each(func(v time.Duration, c int64) {
+		e := counts[v]
+		e[1] += c
+		counts[v] = e
+	})
+	values := make([]time.Duration, 0, len(counts))
+	for v := range counts {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	var (
+		rank    float64
+		rankSum float64
+		ties    float64
+	)
+	for _, v := range values {
+		c := counts[v]
+		t := float64(c[0] + c[1])
+		average := rank + (t+1)/2
+		rankSum += average * float64(c[1])
+		ties += t*t*t - t
+		rank += t
+	}
+
+	n := n1 + n2
+	u = rankSum - n2*(n2+1)/2
+	effect = u / (n1 * n2)
+
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return u, 1, effect
+	}
+	// continuity correction towards the mean
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	p = math.Erfc(max(z, 0) / math.Sqrt2)
+
+	return u, p, effect
+}
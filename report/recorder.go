@@ -0,0 +1,162 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+// Recorder collects the operations of one run, it is safe for concurrent use.
+type Recorder struct {
+	scenario string
+	variant  string
+	start    time.Time
+
+	mu         sync.Mutex
+	operations *Histogram
+	committed  *Histogram
+	aborted    *Histogram
+	failed     map[string]int
+	aborts     map[string]int
+	retries    map[int]int
+}
+
+func NewRecorder(scenario, variant string) *Recorder {
+	return &Recorder{
+		scenario:   scenario,
+		variant:    variant,
+		start:      time.Now(),
+		operations: NewHistogram(),
+		committed:  NewHistogram(),
+		aborted:    NewHistogram(),
+		failed:     make(map[string]int),
+		aborts:     make(map[string]int),
+		retries:    make(map[int]int),
+	}
+}
+
+type attempt struct {
+	latency time.Duration
+	err     error
+}
+
+// Do runs op and records its latency and outcome. Every transaction attempt
+// op makes through db.Atomic, db.AtomicWithAutoRetry or db.RetryMatchAndSet
+// with the given context is timed from the moment its callback starts and
+// counted as a commit or an abort.
+func (r *Recorder) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	return r.DoAt(ctx, time.Now(), op)
+}
+
+// DoAt is Do with the latency measured from intended, the time the operation
+// was meant to start, so time spent waiting behind a slow system counts.
+func (r *Recorder) DoAt(ctx context.Context, intended time.Time, op func(ctx context.Context) error) error {
+	var (
+		started  []time.Time
+		attempts []attempt
+	)
+	ctx = db.WithTxHook(ctx, db.TxHook{
+		Begin: func(ctx context.Context, conn db.Connection) db.Connection {
+			started = append(started, time.Now())
+			return conn
+		},
+		End: func(err error) {
+			start := started[len(started)-1]
+			started = started[:len(started)-1]
+			attempts = append(attempts, attempt{time.Since(start), err})
+		},
+	})
+
+	err := op(ctx)
+	r.record(time.Since(intended), attempts, err)
+
+	return err
+}
+
+func (r *Recorder) record(latency time.Duration, attempts []attempt, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.operations.Record(latency)
+	retries := 0
+	for _, a := range attempts {
+		if a.err == nil {
+			r.committed.Record(a.latency)
+			continue
+		}
+		r.aborted.Record(a.latency)
+		r.aborts[ErrorKey(a.err)]++
+		retries++
+	}
+
+	if err != nil {
+		r.failed[ErrorKey(err)]++
+		if retries > 0 {
+			retries--
+		}
+	}
+	r.retries[retries]++
+}
+
+// Result summarizes the operations recorded so far, Correct and Detail are
+// left to the caller.
+func (r *Recorder) Result() Result {
+	elapsed := time.Since(r.start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	histograms := Histograms{
+		Operations: NewHistogram(),
+		Committed:  NewHistogram(),
+		Aborted:    NewHistogram(),
+	}
+	histograms.Operations.Merge(r.operations)
+	histograms.Committed.Merge(r.committed)
+	histograms.Aborted.Merge(r.aborted)
+
+	operations := int(r.operations.Count())
+	res := Result{
+		Scenario:      r.scenario,
+		Variant:       r.variant,
+		StartedAt:     r.start.Round(0),
+		Elapsed:       elapsed,
+		Operations:    operations,
+		Succeeded:     operations - sum(r.failed),
+		Failed:        copyMap(r.failed),
+		Latency:       r.operations.Latency(),
+		Commits:       int(r.committed.Count()),
+		Aborts:        copyMap(r.aborts),
+		Retries:       copyMap(r.retries),
+		CommitLatency: r.committed.Latency(),
+		AbortLatency:  r.aborted.Latency(),
+		Histograms:    histograms,
+	}
+	if elapsed > 0 {
+		res.Throughput = float64(res.Succeeded) / elapsed.Seconds()
+	}
+
+	return res
+}
+
+// ErrorKey groups errors by SQLSTATE when Postgres raised them.
+func ErrorKey(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return "SQLSTATE " + pgErr.Code
+	}
+
+	return err.Error()
+}
+
+func copyMap[K comparable](m map[K]int) map[K]int {
+	c := make(map[K]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
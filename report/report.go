@@ -0,0 +1,90 @@
+package report
+
+import (
+	"sort"
+	"time"
+)
+
+// Result is the outcome of one benchmark run. Durations are serialized as
+// nanoseconds.
+type Result struct {
+	Scenario  string        `json:"scenario"`
+	Variant   string        `json:"variant"`
+	StartedAt time.Time     `json:"started_at"`
+	Elapsed   time.Duration `json:"elapsed_ns"`
+
+	Operations int            `json:"operations"`
+	Succeeded  int            `json:"succeeded"`
+	Failed     map[string]int `json:"failed"`
+	Throughput float64        `json:"throughput"`
+	Latency    Latency        `json:"latency"`
+
+	// Commits and Aborts count transaction attempts, an operation retried
+	// twice before committing adds two aborts and one commit.
+	Commits int            `json:"commits"`
+	Aborts  map[string]int `json:"aborts"`
+	// Retries maps a number of retries to the operations that needed it.
+	Retries map[int]int `json:"retries"`
+
+	CommitLatency Latency    `json:"commit_latency"`
+	AbortLatency  Latency    `json:"abort_latency"`
+	Histograms    Histograms `json:"histograms"`
+
+	// OpenLoop is set when operations were scheduled at a fixed rate.
+	OpenLoop *OpenLoop `json:"open_loop,omitempty"`
+
+	Correct bool   `json:"correct"`
+	Detail  string `json:"detail"`
+}
+
+type Latency struct {
+	Count int64         `json:"count"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P95   time.Duration `json:"p95_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+type OpenLoop struct {
+	Rate      float64 `json:"rate"`
+	Scheduled int     `json:"scheduled"`
+	// Queued operations found every worker busy and waited for one, Dropped
+	// operations found the queue full and never ran.
+	Queued        int `json:"queued"`
+	Dropped       int `json:"dropped"`
+	MaxQueueDepth int `json:"max_queue_depth"`
+}
+
+// Histograms holds the raw latencies: one value per operation, and one per
+// transaction attempt split by its outcome.
+type Histograms struct {
+	Operations *Histogram `json:"operations"`
+	Committed  *Histogram `json:"committed"`
+	Aborted    *Histogram `json:"aborted"`
+}
+
+func (r Result) TotalAborts() int {
+	return sum(r.Aborts)
+}
+
+func (r Result) TotalFailed() int {
+	return sum(r.Failed)
+}
+
+func sum(m map[string]int) int {
+	total := 0
+	for _, n := range m {
+		total += n
+	}
+	return total
+}
+
+func sortedKeys[K string | int](m map[K]int) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,171 @@
+package report_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/report"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	rec := report.NewRecorder("lost-update", "cas")
+
+	// a compare and set that mismatches twice before it sticks
+	attempt := 0
+	err := rec.Do(ctx, func(ctx context.Context) error {
+		return db.RetryMatchAndSetOn(ctx, nil, func(db.Connection) error {
+			attempt++
+			if attempt < 3 {
+				return db.ErrVersionMisMatch
+			}
+			return nil
+		})
+	})
+	require.NoError(t, err)
+
+	serialization := &pgconn.PgError{Code: "40001"}
+	err = rec.Do(ctx, func(ctx context.Context) error {
+		return db.RetryMatchAndSetOn(ctx, nil, func(db.Connection) error {
+			return serialization
+		})
+	})
+	require.ErrorIs(t, err, serialization)
+
+	err = rec.Do(ctx, func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+	require.Error(t, err)
+
+	res := rec.Result()
+	assert.Equal(t, "lost-update", res.Scenario)
+	assert.Equal(t, "cas", res.Variant)
+	assert.Equal(t, 3, res.Operations)
+	assert.Equal(t, 1, res.Succeeded)
+	assert.Equal(t, map[string]int{"SQLSTATE 40001": 1, "boom": 1}, res.Failed)
+	assert.Equal(t, 1, res.Commits)
+	assert.Equal(t, map[string]int{db.ErrVersionMisMatch.Error(): 2, "SQLSTATE 40001": 1}, res.Aborts)
+	assert.Equal(t, map[int]int{0: 2, 2: 1}, res.Retries)
+	assert.Positive(t, res.Elapsed)
+	assert.Positive(t, res.Throughput)
+
+	assert.EqualValues(t, 3, res.Latency.Count)
+	assert.EqualValues(t, 1, res.CommitLatency.Count)
+	assert.EqualValues(t, 3, res.AbortLatency.Count)
+	assert.EqualValues(t, 3, res.Histograms.Operations.Count())
+	assert.EqualValues(t, 1, res.Histograms.Committed.Count())
+	assert.EqualValues(t, 3, res.Histograms.Aborted.Count())
+}
+
+func TestPercentiles(t *testing.T) {
+	rec := report.NewRecorder("latency", "sleep")
+	for _, d := range []time.Duration{5, 1, 4, 2, 3} {
+		_ = rec.Do(context.Background(), func(context.Context) error {
+			time.Sleep(d * time.Millisecond)
+			return nil
+		})
+	}
+
+	latency := rec.Result().Latency
+	assert.GreaterOrEqual(t, latency.P50, 3*time.Millisecond)
+	assert.Less(t, latency.P50, 4*time.Millisecond)
+	assert.GreaterOrEqual(t, latency.P99, 5*time.Millisecond)
+	assert.Equal(t, latency.P99, latency.Max)
+}
+
+func sample() []report.Result {
+	return []report.Result{
+		{
+			Scenario:      "lost-update",
+			Variant:       "locking",
+			Elapsed:       2 * time.Second,
+			Operations:    100,
+			Succeeded:     100,
+			Failed:        map[string]int{},
+			Throughput:    50,
+			Latency:       report.Latency{Count: 100, P50: 10 * time.Millisecond, P95: 20 * time.Millisecond, P99: 30 * time.Millisecond, Max: 40 * time.Millisecond},
+			Commits:       100,
+			Aborts:        map[string]int{},
+			Retries:       map[int]int{0: 100},
+			CommitLatency: report.Latency{Count: 100, P50: 9 * time.Millisecond, P99: 29 * time.Millisecond},
+			Correct:       true,
+			Detail:        "total_reaction=100, succeeded reactions=100",
+		},
+		{
+			Scenario:      "lost-update",
+			Variant:       "repeatable read",
+			Elapsed:       time.Second,
+			Operations:    100,
+			Succeeded:     90,
+			Failed:        map[string]int{"retry limit exceeded!": 10},
+			Throughput:    90,
+			Latency:       report.Latency{Count: 100, P50: 5 * time.Millisecond, P95: 50 * time.Millisecond, P99: 70 * time.Millisecond, Max: 90 * time.Millisecond},
+			Commits:       90,
+			Aborts:        map[string]int{"SQLSTATE 40001": 120},
+			Retries:       map[int]int{0: 40, 1: 30, 2: 20, 5: 10},
+			CommitLatency: report.Latency{Count: 90, P50: 4 * time.Millisecond, P99: 8 * time.Millisecond},
+			AbortLatency:  report.Latency{Count: 120, P50: 3 * time.Millisecond, P99: 12 * time.Millisecond},
+			OpenLoop:      &report.OpenLoop{Rate: 100, Scheduled: 110, Queued: 30, Dropped: 10, MaxQueueDepth: 8},
+			Correct:       false,
+			Detail:        "total_reaction=80 | succeeded reactions=90",
+		},
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteJSON(&buf, sample()...))
+	assert.Contains(t, buf.String(), `"p95_ns": 50000000`)
+
+	results, err := report.ReadJSON(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, sample(), results)
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteCSV(&buf, sample()...))
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "scenario", records[0][0])
+	assert.Equal(t, []string{
+		"lost-update", "repeatable read", "1000.000", "100", "90", "10", "90.00",
+		"5.000", "50.000", "70.000", "90.000", "90", "120", "SQLSTATE 40001=120", "0=40;1=30;2=20;5=10",
+		"4.000", "8.000", "3.000", "12.000",
+		"100.00", "110", "30", "10", "false", "total_reaction=80 | succeeded reactions=90",
+	}, records[2])
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteMarkdown(&buf, sample()...))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "| lost-update | locking | 50.0 | 10ms | 20ms | 30ms | 100 | - | 0=100 | 29ms | - | 0 | - | yes |", lines[2])
+	assert.Equal(t, `| lost-update | repeatable read | 90.0 | 5ms | 50ms | 70ms | 90 | SQLSTATE 40001=120 | 0=40, 1=30, 2=20, 5=10 | 8ms | 12ms | 10 | 10 | **no**: total_reaction=80 \| succeeded reactions=90 |`, lines[3])
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, report.JSON, report.FormatOf("out/run.json"))
+	assert.Equal(t, report.CSV, report.FormatOf("run.CSV"))
+	assert.Equal(t, report.Markdown, report.FormatOf("run.md"))
+	assert.Equal(t, report.Text, report.FormatOf("run.txt"))
+
+	format, err := report.ParseFormat("markdown")
+	require.NoError(t, err)
+	assert.Equal(t, report.Markdown, format)
+	_, err = report.ParseFormat("xml")
+	assert.Error(t, err)
+}
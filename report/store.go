@@ -0,0 +1,85 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var unsafeName = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Save writes every result to its own JSON file in dir, named after its start
+// time, scenario and variant, and returns the paths written.
+func Save(dir string, results ...Result) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(results))
+	for _, r := range results {
+		name := strings.Join([]string{
+			r.StartedAt.UTC().Format("20060102T150405"),
+			unsafeName.ReplaceAllString(r.Scenario, "-"),
+			unsafeName.ReplaceAllString(r.Variant, "-"),
+		}, "_") + ".json"
+		path := filepath.Join(dir, name)
+		if err := WriteFile(path, JSON, r); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// Load reads JSON reports from files and from every .json file of
+// directories. When several runs share a scenario and variant only the one
+// that started last is kept.
+func Load(paths ...string) ([]Result, error) {
+	latest := make(map[string]Result)
+	for _, path := range paths {
+		files := []string{path}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			files, err = filepath.Glob(filepath.Join(path, "*.json"))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, file := range files {
+			results, err := readFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			for _, r := range results {
+				if prev, ok := latest[key(r)]; !ok || r.StartedAt.After(prev.StartedAt) {
+					latest[key(r)] = r
+				}
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(latest))
+	for _, r := range latest {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return key(results[i]) < key(results[j]) })
+	return results, nil
+}
+
+func readFile(path string) ([]Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadJSON(f)
+}
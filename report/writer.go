@@ -0,0 +1,256 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	Text     Format = "text"
+	JSON     Format = "json"
+	CSV      Format = "csv"
+	Markdown Format = "markdown"
+)
+
+var Formats = []Format{Text, JSON, CSV, Markdown}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown report format %q, want one of %v", s, Formats)
+}
+
+// FormatOf guesses the format from the file extension of path.
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON
+	case ".csv":
+		return CSV
+	case ".md":
+		return Markdown
+	default:
+		return Text
+	}
+}
+
+func Write(w io.Writer, format Format, results ...Result) error {
+	switch format {
+	case JSON:
+		return WriteJSON(w, results...)
+	case CSV:
+		return WriteCSV(w, results...)
+	case Markdown:
+		return WriteMarkdown(w, results...)
+	case Text:
+		return WriteText(w, results...)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+func WriteFile(path string, format Format, results ...Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = Write(f, format, results...)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func WriteJSON(w io.Writer, results ...Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+func ReadJSON(r io.Reader) ([]Result, error) {
+	var results []Result
+	err := json.NewDecoder(r).Decode(&results)
+	return results, err
+}
+
+var csvHeader = []string{
+	"scenario", "variant", "elapsed_ms", "operations", "succeeded", "failed", "throughput",
+	"p50_ms", "p95_ms", "p99_ms", "max_ms", "commits", "aborts", "aborts_by_code", "retries",
+	"commit_p50_ms", "commit_p99_ms", "abort_p50_ms", "abort_p99_ms",
+	"rate", "scheduled", "queued", "dropped", "correct", "detail",
+}
+
+func WriteCSV(w io.Writer, results ...Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range results {
+		openLoop := []string{"", "", "", ""}
+		if o := r.OpenLoop; o != nil {
+			openLoop = []string{
+				strconv.FormatFloat(o.Rate, 'f', 2, 64),
+				strconv.Itoa(o.Scheduled),
+				strconv.Itoa(o.Queued),
+				strconv.Itoa(o.Dropped),
+			}
+		}
+
+		err := cw.Write(append([]string{
+			r.Scenario,
+			r.Variant,
+			ms(r.Elapsed),
+			strconv.Itoa(r.Operations),
+			strconv.Itoa(r.Succeeded),
+			strconv.Itoa(r.TotalFailed()),
+			strconv.FormatFloat(r.Throughput, 'f', 2, 64),
+			ms(r.Latency.P50),
+			ms(r.Latency.P95),
+			ms(r.Latency.P99),
+			ms(r.Latency.Max),
+			strconv.Itoa(r.Commits),
+			strconv.Itoa(r.TotalAborts()),
+			pairs(r.Aborts, ";"),
+			pairs(r.Retries, ";"),
+			ms(r.CommitLatency.P50),
+			ms(r.CommitLatency.P99),
+			ms(r.AbortLatency.P50),
+			ms(r.AbortLatency.P99),
+		}, append(openLoop, strconv.FormatBool(r.Correct), r.Detail)...))
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMarkdown renders one row per result so variants of a scenario can be
+// compared side by side.
+func WriteMarkdown(w io.Writer, results ...Result) error {
+	var b strings.Builder
+	b.WriteString("| scenario | variant | ops/s | p50 | p95 | p99 | commits | aborts | retries | commit p99 | abort p99 | failed | dropped | correct |\n")
+	b.WriteString("|---|---|--:|--:|--:|--:|--:|---|---|--:|--:|--:|--:|---|\n")
+	for _, r := range results {
+		correct := "yes"
+		if !r.Correct {
+			correct = "**no**: " + strings.ReplaceAll(r.Detail, "|", `\|`)
+		}
+		dropped := "-"
+		if r.OpenLoop != nil {
+			dropped = strconv.Itoa(r.OpenLoop.Dropped)
+		}
+		fmt.Fprintf(&b, "| %s | %s | %.1f | %s | %s | %s | %d | %s | %s | %s | %s | %d | %s | %s |\n",
+			r.Scenario,
+			r.Variant,
+			r.Throughput,
+			round(r.Latency.P50),
+			round(r.Latency.P95),
+			round(r.Latency.P99),
+			r.Commits,
+			orDash(pairs(r.Aborts, ", ")),
+			orDash(pairs(r.Retries, ", ")),
+			orDash(latency(r.CommitLatency.P99, r.CommitLatency.Count)),
+			orDash(latency(r.AbortLatency.P99, r.AbortLatency.Count)),
+			r.TotalFailed(),
+			dropped,
+			correct,
+		)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func WriteText(w io.Writer, results ...Result) error {
+	var b strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "scenario:    %s (%s)\n", r.Scenario, r.Variant)
+		fmt.Fprintf(&b, "elapsed:     %s\n", round(r.Elapsed))
+		fmt.Fprintf(&b, "operations:  %d (%d succeeded, %d failed)\n", r.Operations, r.Succeeded, r.TotalFailed())
+		for _, reason := range sortedKeys(r.Failed) {
+			fmt.Fprintf(&b, "  %6d  %s\n", r.Failed[reason], reason)
+		}
+		fmt.Fprintf(&b, "throughput:  %.1f ops/s\n", r.Throughput)
+		if o := r.OpenLoop; o != nil {
+			fmt.Fprintf(&b, "open loop:   %d scheduled at %.1f ops/s, %d queued (max depth %d), %d dropped\n", o.Scheduled, o.Rate, o.Queued, o.MaxQueueDepth, o.Dropped)
+		}
+		fmt.Fprintf(&b, "latency:     %s\n", summary(r.Latency))
+		fmt.Fprintf(&b, "commits:     %d, %s\n", r.Commits, summary(r.CommitLatency))
+		fmt.Fprintf(&b, "aborts:      %d, %s\n", r.TotalAborts(), summary(r.AbortLatency))
+		for _, code := range sortedKeys(r.Aborts) {
+			fmt.Fprintf(&b, "  %6d  %s\n", r.Aborts[code], code)
+		}
+		fmt.Fprintf(&b, "retries:     %s\n", orDash(pairs(r.Retries, ", ")))
+
+		verdict := "CORRECT"
+		if !r.Correct {
+			verdict = "INCORRECT"
+		}
+		fmt.Fprintf(&b, "correctness: %s, %s\n", verdict, r.Detail)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func pairs[K string | int](m map[K]int, sep string) string {
+	parts := make([]string, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		parts = append(parts, fmt.Sprintf("%v=%d", k, m[k]))
+	}
+	return strings.Join(parts, sep)
+}
+
+func summary(l Latency) string {
+	if l.Count == 0 {
+		return "no latency recorded"
+	}
+	return fmt.Sprintf("mean %s, p50 %s, p95 %s, p99 %s, max %s", round(l.Mean), round(l.P50), round(l.P95), round(l.P99), round(l.Max))
+}
+
+func latency(d time.Duration, count int64) string {
+	if count == 0 {
+		return ""
+	}
+	return round(d).String()
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
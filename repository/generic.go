@@ -0,0 +1,405 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+// Table describes the table a Repo reads and writes.
+type Table struct {
+	Name string
+	// Key is the primary key column, id when empty.
+	Key string
+	// Version is the column bumped by every update and compared by
+	// CompareAndSet, none when empty.
+	Version string
+	// CreatedOn is set to the current time on insert when zero, UpdatedOn
+	// on every update.
+	CreatedOn string
+	UpdatedOn string
+	// Immutable columns are written on insert only.
+	Immutable []string
+}
+
+// Statements are the SQL a Repo generated for its table.
+type Statements struct {
+	Insert       string
+	Get          string
+	GetForUpdate string
+	Update       string
+	UpdateCAS    string
+	Delete       string
+}
+
+type field struct {
+	column string
+	index  []int
+}
+
+// Repo reads and writes T, a struct whose db tags name the columns of one
+// table. The SQL is generated once by NewRepo; queries it cannot express are
+// still written by hand against Columns.
+type Repo[T any] struct {
+	table      Table
+	fields     []field
+	updated    []field
+	key        field
+	version    *field
+	createdOn  *field
+	updatedOn  *field
+	statements Statements
+	now        func() time.Time
+	// patches caches the update of every field mask Patch was called with
+	patches sync.Map
+}
+
+// NewRepo builds the Repo of T for table. It panics when T is not a struct
+// or lacks a field for the key, version or timestamp columns of table.
+func NewRepo[T any](table Table) *Repo[T] {
+	if table.Key == "" {
+		table.Key = "id"
+	}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("repository: %s is not a struct", t))
+	}
+
+	r := &Repo[T]{table: table, now: time.Now}
+	for i := 0; i < t.NumField(); i++ {
+		column := t.Field(i).Tag.Get("db")
+		if column == "" || column == "-" {
+			continue
+		}
+		r.fields = append(r.fields, field{column: column, index: t.Field(i).Index})
+	}
+
+	find := func(column string) *field {
+		if column == "" {
+			return nil
+		}
+		for i := range r.fields {
+			if r.fields[i].column == column {
+				return &r.fields[i]
+			}
+		}
+		panic(fmt.Sprintf("repository: %s has no field tagged db:%q", t, column))
+	}
+	r.key = *find(table.Key)
+	r.version = find(table.Version)
+	r.createdOn = find(table.CreatedOn)
+	r.updatedOn = find(table.UpdatedOn)
+	for _, column := range table.Immutable {
+		find(column)
+	}
+
+	for _, f := range r.fields {
+		if f.column == table.Key || f.column == table.Version || f.column == table.CreatedOn || slices.Contains(table.Immutable, f.column) {
+			continue
+		}
+		r.updated = append(r.updated, f)
+	}
+	r.statements = r.generate()
+	return r
+}
+
+func (r *Repo[T]) generate() Statements {
+	columns := r.Columns()
+
+	placeholders := make([]string, len(r.fields))
+	for i := range r.fields {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	update := r.update(r.updated)
+	s := Statements{
+		Insert:    fmt.Sprintf("INSERT INTO %s (%s)\nVALUES (%s)", r.table.Name, columns, strings.Join(placeholders, ", ")),
+		Get:       fmt.Sprintf("SELECT %s\nFROM %s\nWHERE %s = $1", columns, r.table.Name, r.table.Key),
+		Update:    update.sql,
+		UpdateCAS: update.cas,
+		Delete:    fmt.Sprintf("DELETE FROM %s WHERE %s = $1", r.table.Name, r.table.Key),
+	}
+	s.GetForUpdate = s.Get + "\nFOR UPDATE"
+	return s
+}
+
+// update is an UPDATE of some columns, with and without the version check.
+type update struct {
+	fields []field
+	sql    string
+	cas    string
+}
+
+func (r *Repo[T]) update(fields []field) update {
+	// $1 is the key, the updated columns follow in order
+	set := make([]string, 0, len(fields)+1)
+	for i, f := range fields {
+		set = append(set, fmt.Sprintf("%s = $%d", f.column, i+2))
+	}
+	returning := ""
+	if r.version != nil {
+		set = append(set, fmt.Sprintf("%s = %s + 1", r.version.column, r.version.column))
+		returning = "\nRETURNING " + r.version.column
+	}
+	sql := fmt.Sprintf("UPDATE %s SET\n\t%s\nWHERE %s = $1", r.table.Name, strings.Join(set, ",\n\t"), r.table.Key)
+
+	u := update{fields: fields, sql: sql + returning}
+	if r.version != nil {
+		u.cas = fmt.Sprintf("%s AND %s = $%d%s", sql, r.version.column, len(fields)+2, returning)
+	}
+	return u
+}
+
+// Columns returns the columns of T in field order, comma separated, for the
+// select list of hand-written queries.
+func (r *Repo[T]) Columns() string {
+	columns := make([]string, len(r.fields))
+	for i, f := range r.fields {
+		columns[i] = f.column
+	}
+	return strings.Join(columns, ", ")
+}
+
+// Statements returns the generated SQL.
+func (r *Repo[T]) Statements() Statements {
+	return r.statements
+}
+
+// Insert writes v, setting its CreatedOn column first when zero.
+func (r *Repo[T]) Insert(ctx context.Context, conn db.Connection, v *T) error {
+	rv := reflect.ValueOf(v).Elem()
+	if r.createdOn != nil {
+		setTime(rv.FieldByIndex(r.createdOn.index), r.now(), false)
+	}
+
+	args := make([]any, len(r.fields))
+	for i, f := range r.fields {
+		args[i] = rv.FieldByIndex(f.index).Interface()
+	}
+
+	tag, err := conn.Exec(ctx, r.statements.Insert, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return errors.New("nothing was inserted, something went wrong")
+	}
+
+	return nil
+}
+
+// CopyFrom writes vs with COPY, setting their CreatedOn column first when
+// zero. It is much faster than Insert for seeding, but fails as a whole on
+// the first bad row and writes nothing but the rows: hand-written inserts
+// such as CreateThread also emit outbox events and bump counters.
+func (r *Repo[T]) CopyFrom(ctx context.Context, conn db.Connection, vs []T) (int64, error) {
+	columns := make([]string, len(r.fields))
+	for i, f := range r.fields {
+		columns[i] = f.column
+	}
+
+	now := r.now()
+	rows := make([][]any, len(vs))
+	for i := range vs {
+		rv := reflect.ValueOf(&vs[i]).Elem()
+		if r.createdOn != nil {
+			setTime(rv.FieldByIndex(r.createdOn.index), now, false)
+		}
+
+		row := make([]any, len(r.fields))
+		for j, f := range r.fields {
+			row[j] = rv.FieldByIndex(f.index).Interface()
+		}
+		rows[i] = row
+	}
+
+	// the tables are created unquoted, COPY quotes the name it is given
+	n, err := conn.CopyFrom(ctx, pgx.Identifier{strings.ToLower(r.table.Name)}, columns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	if n != int64(len(vs)) {
+		return n, errors.New("not everything was copied, something went wrong")
+	}
+	return n, nil
+}
+
+type GetOption struct {
+	ForUpdate bool
+}
+
+// Get reads the row whose key is id.
+func (r *Repo[T]) Get(ctx context.Context, conn db.Connection, id any, opts ...GetOption) (T, error) {
+	query := r.statements.Get
+	if len(opts) > 0 && opts[0].ForUpdate {
+		query = r.statements.GetForUpdate
+	}
+
+	var v T
+	err := pgxscan.Get(ctx, conn, &v, query, id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return v, nil
+}
+
+// Select runs a hand-written query returning the columns of T.
+func (r *Repo[T]) Select(ctx context.Context, conn db.Connection, query string, args ...any) ([]T, error) {
+	var vs []T
+	err := pgxscan.Select(ctx, conn, &vs, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return vs, nil
+}
+
+type UpdateOption struct {
+	CompareAndSet *CompareAndSetOption
+}
+
+// Update writes the mutable columns of v, sets its UpdatedOn column to the
+// current time and bumps its version. With CompareAndSet it only updates a
+// row still at that version, db.ErrVersionMisMatch otherwise.
+func (r *Repo[T]) Update(ctx context.Context, conn db.Connection, v *T, opts ...UpdateOption) error {
+	return r.exec(ctx, conn, v, update{fields: r.updated, sql: r.statements.Update, cas: r.statements.UpdateCAS}, opts)
+}
+
+// Patch is Update limited to the columns of mask, so concurrent patches of
+// different columns do not overwrite each other. UpdatedOn is written along,
+// mask may not name the key, version, CreatedOn or Immutable columns.
+func (r *Repo[T]) Patch(ctx context.Context, conn db.Connection, v *T, mask []string, opts ...UpdateOption) error {
+	u, err := r.patch(mask)
+	if err != nil {
+		return err
+	}
+	return r.exec(ctx, conn, v, u, opts)
+}
+
+// PatchStatements returns the SQL Patch runs for mask, without and with the
+// version check.
+func (r *Repo[T]) PatchStatements(mask []string) (string, string, error) {
+	u, err := r.patch(mask)
+	return u.sql, u.cas, err
+}
+
+func (r *Repo[T]) patch(mask []string) (update, error) {
+	if len(mask) == 0 {
+		return update{}, errors.New("repository: empty patch mask")
+	}
+	for _, column := range mask {
+		if !slices.ContainsFunc(r.updated, func(f field) bool { return f.column == column }) {
+			return update{}, fmt.Errorf("repository: cannot patch column %q of %s", column, r.table.Name)
+		}
+	}
+
+	// the statements follow field order whatever the order of mask
+	var fields []field
+	for _, f := range r.updated {
+		if slices.Contains(mask, f.column) || (r.updatedOn != nil && f.column == r.updatedOn.column) {
+			fields = append(fields, f)
+		}
+	}
+	columns := make([]string, len(fields))
+	for i, f := range fields {
+		columns[i] = f.column
+	}
+	key := strings.Join(columns, ",")
+
+	if u, ok := r.patches.Load(key); ok {
+		return u.(update), nil
+	}
+	u, _ := r.patches.LoadOrStore(key, r.update(fields))
+	return u.(update), nil
+}
+
+func (r *Repo[T]) exec(ctx context.Context, conn db.Connection, v *T, u update, opts []UpdateOption) error {
+	rv := reflect.ValueOf(v).Elem()
+	if r.updatedOn != nil {
+		setTime(rv.FieldByIndex(r.updatedOn.index), r.now(), true)
+	}
+
+	query := u.sql
+	args := make([]any, 0, len(u.fields)+2)
+	args = append(args, rv.FieldByIndex(r.key.index).Interface())
+	for _, f := range u.fields {
+		args = append(args, rv.FieldByIndex(f.index).Interface())
+	}
+
+	cas := len(opts) > 0 && opts[0].CompareAndSet != nil
+	if cas {
+		if r.version == nil {
+			return fmt.Errorf("repository: %s has no version column to compare", r.table.Name)
+		}
+		query = u.cas
+		args = append(args, opts[0].CompareAndSet.Version)
+	}
+
+	if r.version == nil {
+		tag, err := conn.Exec(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() != 1 {
+			return errors.New("nothing was updated, something went wrong")
+		}
+		return nil
+	}
+
+	var version int64
+	err := conn.QueryRow(ctx, query, args...).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if cas {
+			return db.ErrVersionMisMatch
+		}
+		return errors.New("nothing was updated, something went wrong")
+	}
+	if err != nil {
+		return err
+	}
+
+	rv.FieldByIndex(r.version.index).SetInt(version)
+	return nil
+}
+
+// Delete removes the row whose key is id.
+func (r *Repo[T]) Delete(ctx context.Context, conn db.Connection, id any) error {
+	tag, err := conn.Exec(ctx, r.statements.Delete, id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return errors.New("nothing was deleted, something went wrong")
+	}
+	return nil
+}
+
+// setTime sets v, a time.Time or *time.Time, to now when it is zero or when
+// always is set.
+func setTime(v reflect.Value, now time.Time, always bool) {
+	switch t := v.Addr().Interface().(type) {
+	case *time.Time:
+		if always || t.IsZero() {
+			*t = now
+		}
+	case **time.Time:
+		if always || *t == nil || (*t).IsZero() {
+			*t = &now
+		}
+	}
+}
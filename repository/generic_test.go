@@ -0,0 +1,257 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+type note struct {
+	Id        string     `db:"id"`
+	Author    string     `db:"author"`
+	Body      string     `db:"body"`
+	Internal  string     `db:"-"`
+	CreatedOn time.Time  `db:"created_on"`
+	UpdatedOn *time.Time `db:"updated_on"`
+	Version   int        `db:"version"`
+}
+
+func TestRepoStatements(t *testing.T) {
+	notes := repository.NewRepo[note](repository.Table{
+		Name:      "NOTE",
+		Version:   "version",
+		CreatedOn: "created_on",
+		UpdatedOn: "updated_on",
+		Immutable: []string{"author"},
+	})
+
+	assert.Equal(t, "id, author, body, created_on, updated_on, version", notes.Columns())
+	assert.Equal(t, repository.Statements{
+		Insert:       "INSERT INTO NOTE (id, author, body, created_on, updated_on, version)\nVALUES ($1, $2, $3, $4, $5, $6)",
+		Get:          "SELECT id, author, body, created_on, updated_on, version\nFROM NOTE\nWHERE id = $1",
+		GetForUpdate: "SELECT id, author, body, created_on, updated_on, version\nFROM NOTE\nWHERE id = $1\nFOR UPDATE",
+		Update:       "UPDATE NOTE SET\n\tbody = $2,\n\tupdated_on = $3,\n\tversion = version + 1\nWHERE id = $1\nRETURNING version",
+		UpdateCAS:    "UPDATE NOTE SET\n\tbody = $2,\n\tupdated_on = $3,\n\tversion = version + 1\nWHERE id = $1 AND version = $4\nRETURNING version",
+		Delete:       "DELETE FROM NOTE WHERE id = $1",
+	}, notes.Statements())
+
+	unversioned := repository.NewRepo[note](repository.Table{Name: "NOTE"})
+	assert.Equal(t, "UPDATE NOTE SET\n\tauthor = $2,\n\tbody = $3,\n\tcreated_on = $4,\n\tupdated_on = $5,\n\tversion = $6\nWHERE id = $1", unversioned.Statements().Update)
+	assert.Empty(t, unversioned.Statements().UpdateCAS)
+
+	assert.Panics(t, func() { repository.NewRepo[note](repository.Table{Name: "NOTE", Version: "revision"}) })
+	assert.Panics(t, func() { repository.NewRepo[string](repository.Table{Name: "NOTE"}) })
+}
+
+func TestRepo(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	account := repository.Account{
+		Id:             helper.AccountId(),
+		Username:       helper.ToPointer(helper.AccountId()),
+		HashedPassword: "hashed",
+		Version:        1,
+	}
+	require.NoError(t, repository.Accounts.Insert(ctx, conn, &account))
+	assert.False(t, account.CreatedOn.IsZero())
+
+	got, err := repository.Accounts.Get(ctx, conn, account.Id)
+	require.NoError(t, err)
+	assert.Equal(t, account.Username, got.Username)
+	assert.Nil(t, got.UpdatedOn)
+
+	email := account.Id + "@example.com"
+	got.Email = &email
+	require.NoError(t, repository.Accounts.Update(ctx, conn, &got, repository.UpdateOption{
+		CompareAndSet: &repository.CompareAndSetOption{Version: 1},
+	}))
+	assert.Equal(t, 2, got.Version)
+	assert.NotNil(t, got.UpdatedOn)
+
+	err = repository.Accounts.Update(ctx, conn, &got, repository.UpdateOption{
+		CompareAndSet: &repository.CompareAndSetOption{Version: 1},
+	})
+	assert.ErrorIs(t, err, db.ErrVersionMisMatch)
+
+	stored, err := repository.Accounts.Get(ctx, conn, account.Id)
+	require.NoError(t, err)
+	assert.Equal(t, email, *stored.Email)
+	assert.Equal(t, 2, stored.Version)
+
+	accounts, err := repository.Accounts.Select(ctx, conn,
+		`SELECT `+repository.Accounts.Columns()+` FROM ACCOUNT WHERE email = $1`, email)
+	require.NoError(t, err)
+	assert.Len(t, accounts, 1)
+
+	require.NoError(t, repository.Accounts.Delete(ctx, conn, account.Id))
+	assert.Error(t, repository.Accounts.Delete(ctx, conn, account.Id))
+}
+
+func TestRepoPatchStatements(t *testing.T) {
+	notes := repository.NewRepo[note](repository.Table{
+		Name:      "NOTE",
+		Version:   "version",
+		CreatedOn: "created_on",
+		UpdatedOn: "updated_on",
+		Immutable: []string{"author"},
+	})
+
+	sql, cas, err := notes.PatchStatements([]string{"body"})
+	require.NoError(t, err)
+	assert.Equal(t, "UPDATE NOTE SET\n\tbody = $2,\n\tupdated_on = $3,\n\tversion = version + 1\nWHERE id = $1\nRETURNING version", sql)
+	assert.Equal(t, "UPDATE NOTE SET\n\tbody = $2,\n\tupdated_on = $3,\n\tversion = version + 1\nWHERE id = $1 AND version = $4\nRETURNING version", cas)
+
+	tests := []struct {
+		name string
+		mask []string
+	}{
+		{name: "empty", mask: nil},
+		{name: "unknown column", mask: []string{"title"}},
+		{name: "key", mask: []string{"id"}},
+		{name: "immutable", mask: []string{"author"}},
+		{name: "version", mask: []string{"version"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := notes.PatchStatements(tt.mask)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRepoPatch(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	userId, err := helper.CreateUser()
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(userId)
+	require.NoError(t, err)
+
+	// two edits read the same version, then write different columns
+	editor, err := repository.Threads.Get(ctx, conn, threadId)
+	require.NoError(t, err)
+	reactor := editor
+
+	editor.Title = "edited"
+	require.NoError(t, repository.Threads.Patch(ctx, conn, &editor, []string{"title"}))
+	reactor.TotalReaction++
+	require.NoError(t, repository.Threads.Patch(ctx, conn, &reactor, []string{"total_reaction"}))
+
+	thread, err := repository.Threads.Get(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, "edited", thread.Title)
+	assert.Equal(t, 1, thread.TotalReaction)
+	assert.Equal(t, 3, thread.Version)
+	assert.Equal(t, thread.Version, reactor.Version)
+
+	stale := thread
+	stale.TotalReaction++
+	err = repository.Threads.Patch(ctx, conn, &stale, []string{"total_reaction"}, repository.UpdateOption{
+		CompareAndSet: &repository.CompareAndSetOption{Version: thread.Version - 1},
+	})
+	assert.ErrorIs(t, err, db.ErrVersionMisMatch)
+}
+
+func TestRepoCopyFrom(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	accounts := make([]repository.Account, 3)
+	for i := range accounts {
+		accounts[i] = repository.Account{
+			Id:             helper.AccountId(),
+			Username:       helper.ToPointer(helper.AccountId()),
+			HashedPassword: "hashed",
+			Version:        1,
+		}
+	}
+	n, err := repository.Accounts.CopyFrom(ctx, conn, accounts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	for _, account := range accounts {
+		assert.False(t, account.CreatedOn.IsZero())
+		got, err := repository.Accounts.Get(ctx, conn, account.Id)
+		require.NoError(t, err)
+		assert.Equal(t, account.Username, got.Username)
+	}
+
+	// COPY is all or nothing
+	_, err = repository.Accounts.CopyFrom(ctx, conn, []repository.Account{
+		{Id: helper.AccountId(), HashedPassword: "hashed", Version: 1},
+		accounts[0],
+	})
+	assert.Error(t, err)
+}
+
+func TestCreateReactions(t *testing.T) {
+	ctx := context.Background()
+
+	userIds, err := helper.CreateUsers(3)
+	require.NoError(t, err)
+	threadId, err := helper.CreateThread(userIds[0])
+	require.NoError(t, err)
+
+	reactions := make([]repository.Reaction, len(userIds))
+	for i, userId := range userIds {
+		reactions[i] = repository.Reaction{
+			Id:        helper.ReactionId(),
+			AccountId: userId,
+			ThreadId:  &threadId,
+			Content:   "like",
+			CreatedOn: time.Now(),
+			Version:   1,
+		}
+	}
+
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		return repository.CreateReactions(ctx, tx, reactions)
+	})
+	require.NoError(t, err)
+
+	conn, err := db.GetConnection(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	count, err := repository.CountThreadReactions(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, len(reactions), count)
+
+	// a duplicate fails the batch and rolls back the rest with it
+	err = db.Atomic(ctx, pgx.TxOptions{}, func(tx db.Connection) error {
+		return repository.CreateReactions(ctx, tx, []repository.Reaction{
+			{Id: helper.ReactionId(), AccountId: userIds[0], ThreadId: &threadId, Content: "like", CreatedOn: time.Now(), Version: 1},
+			reactions[0],
+		})
+	})
+	assert.Error(t, err)
+
+	count, err = repository.CountThreadReactions(ctx, conn, threadId)
+	require.NoError(t, err)
+	assert.Equal(t, len(reactions), count)
+}
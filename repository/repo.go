@@ -5,8 +5,9 @@ import (
 	"errors"
 	"time"
 
-	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
 	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/outbox"
 )
 
 type Account struct {
@@ -21,38 +22,15 @@ type Account struct {
 	Version        int        `db:"version"`
 }
 
-func CreateAccount(ctx context.Context, conn db.Connection, payload Account) error {
-	tag, err := conn.Exec(ctx, `INSERT INTO ACCOUNT (
-		id, 
-		username, 
-		phone_number, 
-		email, 
-		hashed_password, 
-		is_deleted, 
-		created_on, 
-		updated_on,
-		version
-		) VALUES 
-		($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
-		payload.Id,
-		payload.Username,
-		payload.Email,
-		payload.PhoneNumber,
-		payload.HashedPassword,
-		payload.IsDeleted,
-		payload.CreatedOn,
-		payload.UpdatedOn,
-		payload.Version,
-	)
-	if err != nil {
-		return err
-	}
-
-	if tag.RowsAffected() != 1 {
-		return errors.New("nothing was inserted, something went wrong")
-	}
+var Accounts = NewRepo[Account](Table{
+	Name:      "ACCOUNT",
+	Version:   "version",
+	CreatedOn: "created_on",
+	UpdatedOn: "updated_on",
+})
 
-	return nil
+func CreateAccount(ctx context.Context, conn db.Connection, payload Account) error {
+	return Accounts.Insert(ctx, conn, &payload)
 }
 
 func CheckAccountUsernameAvailability(ctx context.Context, conn db.Connection, username string) error {
@@ -116,57 +94,12 @@ func CheckAccountPhoneNumberAvailability(ctx context.Context, conn db.Connection
 	return nil
 }
 func GetAccount(ctx context.Context, conn db.Connection, id string) (Account, error) {
-	var account Account
-	err := pgxscan.Get(ctx, conn, &account, `SELECT
-		id, 
-		username, 
-		phone_number, 
-		email, 
-		hashed_password, 
-		is_deleted, 
-		created_on, 
-		updated_on,
-		version
-		FROM ACCOUNT
-		WHERE id = $1`, id,
-	)
-	if err != nil {
-		return Account{}, err
-	}
-
-	return account, nil
+	return Accounts.Get(ctx, conn, id)
 }
 
-func UpdateAccount(ctx context.Context, conn db.Connection, payload Account) error {
-	tag, err := conn.Exec(ctx, `
-	UPDATE ACCOUNT SET
-		username = $2, 
-		phone_number = $3, 
-		email = $4, 
-		hashed_password = $5, 
-		is_deleted = $6, 
-		updated_on = $7,
-		version = version + 1
-	WHERE id = $1
-	
-	`,
-		payload.Id,
-		payload.Username,
-		payload.Email,
-		payload.PhoneNumber,
-		payload.HashedPassword,
-		payload.IsDeleted,
-		payload.UpdatedOn,
-	)
-	if err != nil {
-		return err
-	}
-
-	if tag.RowsAffected() != 1 {
-		return errors.New("nothing was inserted, something went wrong")
-	}
-
-	return nil
+// UpdateAccount writes every mutable column of payload, see Repo.Update.
+func UpdateAccount(ctx context.Context, conn db.Connection, payload Account, opts ...UpdateOption) error {
+	return Accounts.Update(ctx, conn, &payload, opts...)
 }
 
 type Thread struct {
@@ -183,8 +116,16 @@ type Thread struct {
 	Version       int        `db:"version"`
 }
 
+var Threads = NewRepo[Thread](Table{
+	Name:      "THREAD",
+	Version:   "version",
+	CreatedOn: "created_on",
+	UpdatedOn: "updated_on",
+	Immutable: []string{"created_by"},
+})
+
 func CreateThread(ctx context.Context, conn db.Connection, payload Thread) error {
-	tag, err := conn.Exec(ctx, `INSERT INTO THREAD (
+	query, args, err := outbox.Attach(`INSERT INTO THREAD (
 		id, 
 		title,
 		body,
@@ -195,21 +136,36 @@ func CreateThread(ctx context.Context, conn db.Connection, payload Thread) error
 		is_deleted,
 		version
 		) VALUES 
-		($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
-		payload.Id,
-		payload.Title,
-		payload.Body,
-		payload.CreatedBy,
-		payload.CreatedOn,
-		payload.UpdatedBy,
-		payload.UpdatedOn,
-		payload.IsDeleted,
-		payload.Version,
+		($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		RETURNING id`,
+		[]any{
+			payload.Id,
+			payload.Title,
+			payload.Body,
+			payload.CreatedBy,
+			payload.CreatedOn,
+			payload.UpdatedBy,
+			payload.UpdatedOn,
+			payload.IsDeleted,
+			payload.Version,
+		},
+		outbox.ThreadCreated{
+			ThreadId:  payload.Id,
+			CreatedBy: payload.CreatedBy,
+			Title:     payload.Title,
+			CreatedOn: payload.CreatedOn,
+			Version:   payload.Version,
+		},
 	)
 	if err != nil {
 		return err
 	}
 
+	tag, err := conn.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
 	if tag.RowsAffected() != 1 {
 		return errors.New("nothing was inserted, something went wrong")
 	}
@@ -222,89 +178,20 @@ type GetThreadOption struct {
 }
 
 func GetThread(ctx context.Context, conn db.Connection, id string, opts ...GetThreadOption) (Thread, error) {
-	const getThread = `
-	SELECT
-		id, 
-		title,
-		body,
-		total_comment,
-		total_reaction,
-		created_by,
-		created_on,
-		updated_by,
-		updated_on,
-		is_deleted,
-		version
-	FROM THREAD
-	WHERE id = $1`
-
-	query := getThread
-	if len(opts) > 0 && opts[0].ForUpdate {
-		query += "\n FOR UPDATE"
+	var opt GetOption
+	if len(opts) > 0 {
+		opt.ForUpdate = opts[0].ForUpdate
 	}
-	var thread Thread
-	err := pgxscan.Get(ctx, conn,
-		&thread,
-		query,
-		id,
-	)
-	if err != nil {
-		return Thread{}, err
-	}
-
-	return thread, nil
+	return Threads.Get(ctx, conn, id, opt)
 }
 
 type CompareAndSetOption struct {
 	Version int
 }
-type UpdateThreadOption struct {
-	CompareAndSet *CompareAndSetOption
-}
 
-func UpdateThread(ctx context.Context, conn db.Connection, payload Thread, opts ...UpdateThreadOption) error {
-	const updateThread = `
-	UPDATE THREAD SET
-		title = $2,
-		body = $3,
-		total_comment = $4,
-		total_reaction = $5,
-		updated_by = $6,
-		updated_on = $7,
-		is_deleted = $8,
-		version = version +1
-	WHERE id = $1`
-
-	query := updateThread
-	args := []any{
-		payload.Id,
-		payload.Title,
-		payload.Body,
-		payload.TotalComment,
-		payload.TotalReaction,
-		payload.UpdatedBy,
-		payload.UpdatedOn,
-		payload.IsDeleted,
-	}
-
-	if len(opts) > 0 && opts[0].CompareAndSet != nil && opts[0].CompareAndSet.Version != 0 {
-		query += " AND\nversion = $9"
-		args = append(args, opts[0].CompareAndSet.Version)
-	}
-	tag, err := conn.Exec(ctx, query, args...)
-	if err != nil {
-		return err
-	}
-
-	if tag.RowsAffected() != 1 {
-		if len(opts) > 0 && opts[0].CompareAndSet != nil {
-			return db.ErrVersionMisMatch
-		}
-
-		return errors.New("nothing was updated, something went wrong")
-	}
-
-	return nil
+// UpdateThread writes every mutable column of payload, see Repo.Update.
+func UpdateThread(ctx context.Context, conn db.Connection, payload Thread, opts ...UpdateOption) error {
+	return Threads.Update(ctx, conn, &payload, opts...)
 }
 
 type Comment struct {
@@ -321,8 +208,16 @@ type Comment struct {
 	Version       int        `db:"version"`
 }
 
+var Comments = NewRepo[Comment](Table{
+	Name:      "COMMENT",
+	Version:   "version",
+	CreatedOn: "created_on",
+	UpdatedOn: "updated_on",
+	Immutable: []string{"thread_id", "user_id", "reply_to"},
+})
+
 func CreateComment(ctx context.Context, conn db.Connection, payload Comment) error {
-	tag, err := conn.Exec(ctx, `INSERT INTO COMMENT (
+	query, args, err := outbox.Attach(`INSERT INTO COMMENT (
 		id,
 		thread_id,
 		user_id,
@@ -333,21 +228,37 @@ func CreateComment(ctx context.Context, conn db.Connection, payload Comment) err
 		is_deleted,
 		version
 		) VALUES 
-		($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
-		payload.Id,
-		payload.ThreadId,
-		payload.UserId,
-		payload.ReplyTo,
-		payload.Content,
-		payload.CreatedOn,
-		payload.UpdatedOn,
-		payload.IsDeleted,
-		payload.Version,
+		($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		RETURNING id`,
+		[]any{
+			payload.Id,
+			payload.ThreadId,
+			payload.UserId,
+			payload.ReplyTo,
+			payload.Content,
+			payload.CreatedOn,
+			payload.UpdatedOn,
+			payload.IsDeleted,
+			payload.Version,
+		},
+		outbox.CommentPosted{
+			CommentId: payload.Id,
+			ThreadId:  payload.ThreadId,
+			UserId:    payload.UserId,
+			ReplyTo:   payload.ReplyTo,
+			CreatedOn: payload.CreatedOn,
+			Version:   payload.Version,
+		},
 	)
 	if err != nil {
 		return err
 	}
 
+	tag, err := conn.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
 	if tag.RowsAffected() != 1 {
 		return errors.New("nothing was inserted, something went wrong")
 	}
@@ -356,30 +267,24 @@ func CreateComment(ctx context.Context, conn db.Connection, payload Comment) err
 }
 
 func GetComment(ctx context.Context, conn db.Connection, id string) (Comment, error) {
-	var comment Comment
-	err := pgxscan.Get(ctx, conn, &comment,
+	return Comments.Get(ctx, conn, id)
+}
+
+func CountThreadComments(ctx context.Context, conn db.Connection, threadId string) (int, error) {
+	commentCount := 0
+	err := conn.QueryRow(ctx,
 		`
-		SELECT
-			id,
-			thread_id,
-			user_id,
-			reply_to,
-			content,
-			total_reply,
-			total_reaction,
-			created_on,
-			updated_on,
-			is_deleted,
-			version
-		FROM COMMENT
-		WHERE id = $1`,
-		id,
-	)
+		SELECT 
+			count(1)
+		FROM COMMENT 
+		where thread_id = $1`,
+		threadId,
+	).Scan(&commentCount)
 	if err != nil {
-		return Comment{}, err
+		return 0, err
 	}
 
-	return comment, nil
+	return commentCount, nil
 }
 
 func UpdateComment(ctx context.Context, conn db.Connection, payload Comment) error {
@@ -394,6 +299,8 @@ func UpdateComment(ctx context.Context, conn db.Connection, payload Comment) err
 	WHERE id = $1`,
 		payload.Id,
 		payload.Content,
+		payload.TotalReply,
+		payload.TotalReaction,
 		payload.UpdatedOn,
 		payload.IsDeleted,
 	)
@@ -419,8 +326,63 @@ type Reaction struct {
 	Version   int        `db:"version"`
 }
 
+var Reactions = NewRepo[Reaction](Table{
+	Name:      "REACTION",
+	Version:   "version",
+	CreatedOn: "created_on",
+	UpdatedOn: "updated_on",
+	Immutable: []string{"account_id", "thread_id", "comment_id"},
+})
+
 func CreateReaction(ctx context.Context, conn db.Connection, payload Reaction) error {
-	tag, err := conn.Exec(ctx, `INSERT INTO REACTION (
+	query, args, err := createReactionQuery(payload)
+	if err != nil {
+		return err
+	}
+
+	tag, err := conn.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return errors.New("nothing was inserted, something went wrong")
+	}
+
+	return nil
+}
+
+// CreateReactions is CreateReaction for every payload, sent as one batch so
+// seeding pays a single round trip. Run it in a transaction to get all or
+// none of them.
+func CreateReactions(ctx context.Context, conn db.Connection, payloads []Reaction) error {
+	batch := &pgx.Batch{}
+	for _, payload := range payloads {
+		query, args, err := createReactionQuery(payload)
+		if err != nil {
+			return err
+		}
+		batch.Queue(query, args...)
+	}
+
+	results := conn.SendBatch(ctx, batch)
+	defer results.Close()
+
+	for range payloads {
+		tag, err := results.Exec()
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() != 1 {
+			return errors.New("nothing was inserted, something went wrong")
+		}
+	}
+
+	return results.Close()
+}
+
+func createReactionQuery(payload Reaction) (string, []any, error) {
+	return outbox.Attach(`INSERT INTO REACTION (
 		id,
 		account_id,
 		thread_id,
@@ -430,25 +392,45 @@ func CreateReaction(ctx context.Context, conn db.Connection, payload Reaction) e
 		updated_on,
 		version
 		) VALUES 
-		($1,$2,$3,$4,$5,$6,$7,$8)`,
-		payload.Id,
-		payload.AccountId,
-		payload.ThreadId,
-		payload.CommentId,
-		payload.Content,
-		payload.CreatedOn,
-		payload.UpdatedOn,
-		payload.Version,
+		($1,$2,$3,$4,$5,$6,$7,$8)
+		RETURNING id`,
+		[]any{
+			payload.Id,
+			payload.AccountId,
+			payload.ThreadId,
+			payload.CommentId,
+			payload.Content,
+			payload.CreatedOn,
+			payload.UpdatedOn,
+			payload.Version,
+		},
+		outbox.ReactionAdded{
+			ReactionId: payload.Id,
+			AccountId:  payload.AccountId,
+			ThreadId:   payload.ThreadId,
+			CommentId:  payload.CommentId,
+			Content:    payload.Content,
+			CreatedOn:  payload.CreatedOn,
+			Version:    payload.Version,
+		},
 	)
-	if err != nil {
-		return err
-	}
+}
 
-	if tag.RowsAffected() != 1 {
-		return errors.New("nothing was inserted, something went wrong")
+func CountThreadReactions(ctx context.Context, conn db.Connection, threadId string) (int, error) {
+	reactionCount := 0
+	err := conn.QueryRow(ctx,
+		`
+		SELECT 
+			count(1)
+		FROM REACTION 
+		where thread_id = $1`,
+		threadId,
+	).Scan(&reactionCount)
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return reactionCount, nil
 }
 
 func UpdateReaction(ctx context.Context, conn db.Connection, payload Reaction) error {
@@ -474,14 +456,34 @@ func UpdateReaction(ctx context.Context, conn db.Connection, payload Reaction) e
 	return nil
 }
 
+// DeleteReaction removes the reaction and emits ReactionRemoved, so the
+// ReactionAdded its creation emitted is undone downstream as well.
 func DeleteReaction(ctx context.Context, conn db.Connection, id string) error {
-	tag, err := conn.Exec(ctx, `DELETE FROM REACTION WHERE id = $1`, id)
+	reaction, err := Reactions.Get(ctx, conn, id)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := outbox.Attach(Reactions.Statements().Delete+" RETURNING id",
+		[]any{id},
+		outbox.ReactionRemoved{
+			ReactionId: reaction.Id,
+			AccountId:  reaction.AccountId,
+			ThreadId:   reaction.ThreadId,
+			CommentId:  reaction.CommentId,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	tag, err := conn.Exec(ctx, query, args...)
 	if err != nil {
 		return err
 	}
 
 	if tag.RowsAffected() != 1 {
-		return errors.New("nothing was inserted, something went wrong")
+		return errors.New("nothing was deleted, something went wrong")
 	}
 	return nil
 }
@@ -0,0 +1,190 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+)
+
+const (
+	StatusRunning      = "running"
+	StatusCompleted    = "completed"
+	StatusCompensating = "compensating"
+	StatusCompensated  = "compensated"
+	StatusFailed       = "failed"
+)
+
+// Data is persisted after every step, so anything a later step or a
+// compensation needs (e.g. the id of a created row) must be stored in it.
+type Data map[string]string
+
+type StepFunc func(ctx context.Context, conn db.Connection, data Data) error
+
+// Step actions are retried on db.ErrVersionMisMatch and may run again after a
+// crash, so they have to be idempotent. Compensate is optional; it may also run
+// for the step a crashed executor was in, whose action may or may not have
+// committed, so it has to tolerate finding nothing to undo.
+type Step struct {
+	Name       string
+	Action     StepFunc
+	Compensate StepFunc
+}
+
+type Definition struct {
+	Name  string
+	Steps []Step
+}
+
+type Result struct {
+	Id     string
+	Name   string
+	Status string
+	Data   Data
+	Err    error
+}
+
+// Lease is how long a saga stays claimed by its executor after each persisted
+// step; once it expires Recover considers the executor crashed.
+var Lease = 30 * time.Second
+
+func Run(ctx context.Context, def Definition, data Data) (Result, error) {
+	if data == nil {
+		data = Data{}
+	}
+
+	s := state{
+		Id:      helper.SagaId(),
+		Name:    def.Name,
+		Status:  StatusRunning,
+		Data:    data,
+		Version: 1,
+	}
+	err := create(ctx, s)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return execute(ctx, def, s)
+}
+
+type RecoverMode int
+
+const (
+	// Resume continues crashed sagas from their last persisted step.
+	Resume RecoverMode = iota
+	// Compensate rolls crashed sagas back instead of finishing them.
+	Compensate
+)
+
+// Recover finishes every saga whose executor stopped before reaching a final
+// status. Sagas already compensating are always compensated further.
+func Recover(ctx context.Context, mode RecoverMode, defs ...Definition) ([]Result, error) {
+	byName := make(map[string]Definition, len(defs))
+	names := make([]string, 0, len(defs))
+	for _, def := range defs {
+		byName[def.Name] = def
+		names = append(names, def.Name)
+	}
+
+	var results []Result
+	for {
+		s, err := claimAbandoned(ctx, names)
+		if err != nil {
+			if errors.Is(err, errNothingToClaim) {
+				return results, nil
+			}
+			return results, err
+		}
+
+		if mode == Compensate && s.Status == StatusRunning {
+			// the action of the step the executor was in may have committed
+			// before it crashed, so that step is compensated too
+			s.CurrentStep = min(s.CurrentStep+1, len(byName[s.Name].Steps))
+			s.Status = StatusCompensating
+			s.LastError = "compensated on recovery"
+			if err := save(ctx, &s); err != nil {
+				return results, err
+			}
+		}
+
+		result, err := execute(ctx, byName[s.Name], s)
+		if err != nil && result.Id == "" {
+			return results, err
+		}
+		results = append(results, result)
+	}
+}
+
+func execute(ctx context.Context, def Definition, s state) (Result, error) {
+	if s.Status == StatusRunning && len(def.Steps) == 0 {
+		s.Status = StatusCompleted
+		if err := save(ctx, &s); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if s.Status == StatusRunning {
+		for i := s.CurrentStep; i < len(def.Steps); i++ {
+			step := def.Steps[i]
+			err := retry(ctx, step.Action, s.Data)
+			if err != nil {
+				s.Status = StatusCompensating
+				s.LastError = fmt.Sprintf("step %s: %s", step.Name, err)
+				if saveErr := save(ctx, &s); saveErr != nil {
+					return Result{}, fmt.Errorf("cannot persist saga %s %w: %w", s.Id, saveErr, err)
+				}
+
+				break
+			}
+
+			s.CurrentStep = i + 1
+			if i == len(def.Steps)-1 {
+				s.Status = StatusCompleted
+			}
+			if err := save(ctx, &s); err != nil {
+				return Result{}, err
+			}
+		}
+	}
+
+	if s.Status == StatusCompensating {
+		for i := s.CurrentStep - 1; i >= 0; i-- {
+			step := def.Steps[i]
+			if step.Compensate != nil {
+				err := retry(ctx, step.Compensate, s.Data)
+				if err != nil {
+					s.Status = StatusFailed
+					s.LastError = fmt.Sprintf("%s; compensate %s: %s", s.LastError, step.Name, err)
+					if saveErr := save(ctx, &s); saveErr != nil {
+						return Result{}, fmt.Errorf("cannot persist saga %s %w: %w", s.Id, saveErr, err)
+					}
+
+					return s.result(), errors.New(s.LastError)
+				}
+			}
+
+			s.CurrentStep = i
+			if err := save(ctx, &s); err != nil {
+				return Result{}, err
+			}
+		}
+
+		s.Status = StatusCompensated
+		if err := save(ctx, &s); err != nil {
+			return Result{}, err
+		}
+	}
+
+	result := s.result()
+	return result, result.Err
+}
+
+func retry(ctx context.Context, fn StepFunc, data Data) error {
+	return db.RetryMatchAndSet(ctx, func(conn db.Connection) error {
+		return fn(ctx, conn, data)
+	})
+}
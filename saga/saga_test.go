@@ -0,0 +1,196 @@
+package saga_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/saga"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+type journal []string
+
+func (j *journal) step(name string, err error) saga.StepFunc {
+	return func(ctx context.Context, conn db.Connection, data saga.Data) error {
+		*j = append(*j, name)
+		data[name] = "done"
+		return err
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("completed", func(t *testing.T) {
+		var j journal
+		def := saga.Definition{
+			Name: helper.SagaId(),
+			Steps: []saga.Step{
+				{Name: "a", Action: j.step("a", nil), Compensate: j.step("undo-a", nil)},
+				{Name: "b", Action: j.step("b", nil), Compensate: j.step("undo-b", nil)},
+			},
+		}
+
+		result, err := saga.Run(ctx, def, nil)
+		require.NoError(t, err)
+		assert.Equal(t, saga.StatusCompleted, result.Status)
+		assert.Equal(t, journal{"a", "b"}, j)
+		assert.Equal(t, saga.Data{"a": "done", "b": "done"}, result.Data)
+	})
+
+	t.Run("compensated in reverse order", func(t *testing.T) {
+		var j journal
+		def := saga.Definition{
+			Name: helper.SagaId(),
+			Steps: []saga.Step{
+				{Name: "a", Action: j.step("a", nil), Compensate: j.step("undo-a", nil)},
+				{Name: "b", Action: j.step("b", nil), Compensate: j.step("undo-b", nil)},
+				{Name: "c", Action: j.step("c", errors.New("boom"))},
+			},
+		}
+
+		result, err := saga.Run(ctx, def, nil)
+		require.Error(t, err)
+		assert.Equal(t, saga.StatusCompensated, result.Status)
+		assert.Equal(t, journal{"a", "b", "c", "undo-b", "undo-a"}, j)
+
+		conn, err := db.GetConnection(ctx)
+		require.NoError(t, err)
+		defer conn.Release()
+
+		stored, err := saga.Get(ctx, conn, result.Id)
+		require.NoError(t, err)
+		assert.Equal(t, saga.StatusCompensated, stored.Status)
+		assert.EqualError(t, stored.Err, "step c: boom")
+	})
+
+	t.Run("retry on version mismatch", func(t *testing.T) {
+		attempt := 0
+		def := saga.Definition{
+			Name: helper.SagaId(),
+			Steps: []saga.Step{
+				{Name: "cas", Action: func(ctx context.Context, conn db.Connection, data saga.Data) error {
+					attempt++
+					if attempt < 3 {
+						return db.ErrVersionMisMatch
+					}
+					return nil
+				}},
+			},
+		}
+
+		result, err := saga.Run(ctx, def, nil)
+		require.NoError(t, err)
+		assert.Equal(t, saga.StatusCompleted, result.Status)
+		assert.Equal(t, 3, attempt)
+	})
+}
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+	lease := saga.Lease
+	saga.Lease = 0
+	defer func() { saga.Lease = lease }()
+
+	crash := func(def saga.Definition) {
+		defer func() { _ = recover() }()
+		_, _ = saga.Run(ctx, def, nil)
+	}
+
+	tests := []struct {
+		name    string
+		mode    saga.RecoverMode
+		status  string
+		journal journal
+	}{
+		{
+			name:    "resume from last persisted step",
+			mode:    saga.Resume,
+			status:  saga.StatusCompleted,
+			journal: journal{"a", "b"},
+		},
+		{
+			name:    "compensate completed steps",
+			mode:    saga.Compensate,
+			status:  saga.StatusCompensated,
+			journal: journal{"a", "undo-a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var j journal
+			name := helper.SagaId()
+			crash(saga.Definition{
+				Name: name,
+				Steps: []saga.Step{
+					{Name: "a", Action: j.step("a", nil), Compensate: j.step("undo-a", nil)},
+					{Name: "b", Action: func(context.Context, db.Connection, saga.Data) error { panic("crash") }},
+				},
+			})
+			time.Sleep(10 * time.Millisecond)
+
+			results, err := saga.Recover(ctx, tt.mode, saga.Definition{
+				Name: name,
+				Steps: []saga.Step{
+					{Name: "a", Action: j.step("a", nil), Compensate: j.step("undo-a", nil)},
+					{Name: "b", Action: j.step("b", nil)},
+				},
+			})
+			require.NoError(t, err)
+			require.Len(t, results, 1)
+			assert.Equal(t, tt.status, results[0].Status)
+			assert.Equal(t, tt.journal, j)
+		})
+	}
+}
+
+func TestRecoverCompensateCrashedStep(t *testing.T) {
+	ctx := context.Background()
+	lease := saga.Lease
+	saga.Lease = 0
+	defer func() { saga.Lease = lease }()
+
+	var j journal
+	name := helper.SagaId()
+	func() {
+		defer func() { _ = recover() }()
+		// a commits then the executor crashes before persisting its progress
+		_, _ = saga.Run(ctx, saga.Definition{
+			Name: name,
+			Steps: []saga.Step{
+				{Name: "a", Action: func(ctx context.Context, conn db.Connection, data saga.Data) error {
+					_ = j.step("a", nil)(ctx, conn, data)
+					panic("crash")
+				}},
+			},
+		}, nil)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	results, err := saga.Recover(ctx, saga.Compensate, saga.Definition{
+		Name: name,
+		Steps: []saga.Step{
+			{Name: "a", Action: j.step("a", nil), Compensate: j.step("undo-a", nil)},
+			{Name: "b", Action: j.step("b", nil), Compensate: j.step("undo-b", nil)},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, saga.StatusCompensated, results[0].Status)
+	assert.Equal(t, journal{"a", "undo-a"}, j)
+}
@@ -0,0 +1,198 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+)
+
+type state struct {
+	Id          string `db:"id"`
+	Name        string `db:"name"`
+	Status      string `db:"status"`
+	CurrentStep int    `db:"current_step"`
+	Data        Data   `db:"data"`
+	LastError   string `db:"last_error"`
+	Version     int    `db:"version"`
+}
+
+func (s state) result() Result {
+	result := Result{
+		Id:     s.Id,
+		Name:   s.Name,
+		Status: s.Status,
+		Data:   s.Data,
+	}
+	if s.Status != StatusCompleted && s.LastError != "" {
+		result.Err = errors.New(s.LastError)
+	}
+
+	return result
+}
+
+var errNothingToClaim = errors.New("no abandoned saga")
+
+func create(ctx context.Context, s state) error {
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	data, err := json.Marshal(s.Data)
+	if err != nil {
+		return err
+	}
+
+	tag, err := conn.Exec(ctx, `INSERT INTO SAGA (
+		id,
+		name,
+		status,
+		current_step,
+		data,
+		locked_until,
+		created_on,
+		version
+		) VALUES
+		($1,$2,$3,$4,$5,$6,$7,$8)`,
+		s.Id,
+		s.Name,
+		s.Status,
+		s.CurrentStep,
+		data,
+		time.Now().Add(Lease),
+		time.Now(),
+		1,
+	)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return errors.New("nothing was inserted, something went wrong")
+	}
+
+	return nil
+}
+
+// save persists the progress and renews the lease. The version check makes a
+// stale executor (whose lease expired and was taken over) fail loudly instead
+// of overwriting the new owner's progress.
+func save(ctx context.Context, s *state) error {
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	data, err := json.Marshal(s.Data)
+	if err != nil {
+		return err
+	}
+
+	var lockedUntil *time.Time
+	if s.Status == StatusRunning || s.Status == StatusCompensating {
+		lockedUntil = helper.ToPointer(time.Now().Add(Lease))
+	}
+
+	tag, err := conn.Exec(ctx, `
+	UPDATE SAGA SET
+		status = $2,
+		current_step = $3,
+		data = $4,
+		last_error = NULLIF($5, ''),
+		locked_until = $6,
+		updated_on = now(),
+		version = version + 1
+	WHERE id = $1 AND version = $7`,
+		s.Id,
+		s.Status,
+		s.CurrentStep,
+		data,
+		s.LastError,
+		lockedUntil,
+		s.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return db.ErrVersionMisMatch
+	}
+	s.Version++
+
+	return nil
+}
+
+func claimAbandoned(ctx context.Context, names []string) (state, error) {
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return state{}, err
+	}
+	defer conn.Release()
+
+	var s state
+	err = pgxscan.Get(ctx, conn, &s, `
+	UPDATE SAGA SET
+		locked_until = $1,
+		version = version + 1
+	WHERE id = (
+		SELECT id
+		FROM SAGA
+		WHERE status IN ($2, $3)
+		AND name = ANY($4)
+		AND (locked_until IS NULL OR locked_until < now())
+		ORDER BY created_on ASC
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING
+		id,
+		name,
+		status,
+		current_step,
+		data,
+		coalesce(last_error, '') AS last_error,
+		version`,
+		time.Now().Add(Lease),
+		StatusRunning,
+		StatusCompensating,
+		names,
+	)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return state{}, errNothingToClaim
+		}
+		return state{}, err
+	}
+
+	return s, nil
+}
+
+func Get(ctx context.Context, conn db.Connection, id string) (Result, error) {
+	var s state
+	err := pgxscan.Get(ctx, conn, &s, `
+	SELECT
+		id,
+		name,
+		status,
+		current_step,
+		data,
+		coalesce(last_error, '') AS last_error,
+		version
+	FROM SAGA
+	WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return s.result(), nil
+}
@@ -0,0 +1,455 @@
+package schemacheck
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query is one SQL statement found in Go source with the arguments passed
+// along with it.
+type Query struct {
+	Func string
+	Pos  token.Position
+	SQL  string
+	// Args are the argument expressions, nil when they could not be
+	// resolved statically, e.g. a slice built with append.
+	Args []Arg
+}
+
+// Arg is one query argument. Column is the db tag of the struct field it
+// reads, empty when it is not a field of a tagged struct parameter.
+type Arg struct {
+	Expr   string
+	Column string
+}
+
+// sqlArg locates the SQL and its arguments in the calls that run queries:
+// the index of the SQL argument, and whether the arguments follow it or are
+// the []any literal right after it, like outbox.Attach.
+type sqlArg struct {
+	index int
+	slice bool
+}
+
+var queryCalls = map[string]sqlArg{
+	"Exec":     {index: 1},
+	"Query":    {index: 1},
+	"QueryRow": {index: 1},
+	"Get":      {index: 3},
+	"Select":   {index: 3},
+	"Attach":   {index: 0, slice: true},
+}
+
+// Queries parses the Go files of dir, tests excluded, and returns the SQL
+// literals passed to Exec, Query, QueryRow, pgxscan.Get, pgxscan.Select and
+// outbox.Attach. SQL held in constants or variables is resolved when it is a
+// literal; queries built any other way are skipped.
+func Queries(dir string) ([]Query, error) {
+	fset := token.NewFileSet()
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed []*ast.File
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(fset, file, src, 0)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, f)
+	}
+
+	pkg := newPackageScope(parsed)
+	var queries []Query
+	for _, f := range parsed {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			queries = append(queries, funcQueries(fset, pkg, fn)...)
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].Pos.Filename != queries[j].Pos.Filename {
+			return queries[i].Pos.Filename < queries[j].Pos.Filename
+		}
+		return queries[i].Pos.Line < queries[j].Pos.Line
+	})
+	return queries, nil
+}
+
+// packageScope holds what the queries of a function may refer to outside of
+// it: package level constants and the db tags of the package's structs.
+type packageScope struct {
+	consts map[string]ast.Expr
+	tags   map[string]map[string]string
+}
+
+func newPackageScope(files []*ast.File) packageScope {
+	pkg := packageScope{consts: make(map[string]ast.Expr), tags: make(map[string]map[string]string)}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for i, name := range spec.Names {
+						if i < len(spec.Values) {
+							pkg.consts[name.Name] = spec.Values[i]
+						}
+					}
+				case *ast.TypeSpec:
+					st, ok := spec.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					pkg.tags[spec.Name.Name] = structTags(st)
+				}
+			}
+		}
+	}
+	return pkg
+}
+
+func structTags(st *ast.StructType) map[string]string {
+	tags := make(map[string]string)
+	for _, field := range st.Fields.List {
+		if field.Tag == nil {
+			continue
+		}
+		raw, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			continue
+		}
+		column := reflect.StructTag(raw).Get("db")
+		if column == "" || column == "-" {
+			continue
+		}
+		for _, name := range field.Names {
+			tags[name.Name] = column
+		}
+	}
+	return tags
+}
+
+func funcQueries(fset *token.FileSet, pkg packageScope, fn *ast.FuncDecl) []Query {
+	// locals holds the first value given to every local name, which is
+	// what the queries of this repository start from before appending to
+	// them conditionally
+	locals := make(map[string]ast.Expr)
+	params := make(map[string]string)
+	for _, field := range fn.Type.Params.List {
+		typ, ok := field.Type.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		for _, name := range field.Names {
+			params[name.Name] = typ.Name
+		}
+	}
+
+	var queries []Query
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE && len(n.Lhs) == len(n.Rhs) {
+				for i, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok {
+						if _, seen := locals[id.Name]; !seen {
+							locals[id.Name] = n.Rhs[i]
+						}
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			for i, name := range n.Names {
+				if i < len(n.Values) {
+					locals[name.Name] = n.Values[i]
+				}
+			}
+		case *ast.CallExpr:
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			at, ok := queryCalls[sel.Sel.Name]
+			if !ok || len(n.Args) <= at.index {
+				return true
+			}
+			r := resolver{pkg: pkg, locals: locals, params: params}
+			sql, ok := r.string(n.Args[at.index])
+			if !ok {
+				return true
+			}
+
+			q := Query{Func: fn.Name.Name, Pos: fset.Position(n.Pos()), SQL: sql}
+			if at.slice {
+				if len(n.Args) > at.index+1 {
+					q.Args = r.slice(n.Args[at.index+1])
+				}
+			} else {
+				q.Args = r.args(n.Args[at.index+1:], n.Ellipsis.IsValid())
+			}
+			queries = append(queries, q)
+		}
+		return true
+	})
+	return queries
+}
+
+func (q Query) location() string {
+	if q.Pos.Filename == "" {
+		return q.Func
+	}
+	return fmt.Sprintf("%s (%s:%d)", q.Func, filepath.Base(q.Pos.Filename), q.Pos.Line)
+}
+
+type resolver struct {
+	pkg    packageScope
+	locals map[string]ast.Expr
+	params map[string]string
+}
+
+func (r resolver) lookup(name string) (ast.Expr, bool) {
+	if e, ok := r.locals[name]; ok {
+		return e, true
+	}
+	e, ok := r.pkg.consts[name]
+	return e, ok
+}
+
+func (r resolver) string(e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		s, err := strconv.Unquote(e.Value)
+		return s, err == nil
+	case *ast.Ident:
+		v, ok := r.lookup(e.Name)
+		if !ok {
+			return "", false
+		}
+		return r.string(v)
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+		x, ok := r.string(e.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := r.string(e.Y)
+		return x + y, ok
+	}
+	return "", false
+}
+
+func (r resolver) args(exprs []ast.Expr, ellipsis bool) []Arg {
+	if !ellipsis {
+		return r.list(exprs)
+	}
+	// only f(ctx, sql, args...) with args a slice literal resolves
+	if len(exprs) != 1 {
+		return nil
+	}
+	return r.slice(exprs[0])
+}
+
+func (r resolver) slice(e ast.Expr) []Arg {
+	if id, ok := e.(*ast.Ident); ok {
+		v, ok := r.lookup(id.Name)
+		if !ok {
+			return nil
+		}
+		e = v
+	}
+	lit, ok := e.(*ast.CompositeLit)
+	if !ok {
+		return nil
+	}
+	if _, ok := lit.Type.(*ast.ArrayType); !ok {
+		return nil
+	}
+	return r.list(lit.Elts)
+}
+
+func (r resolver) list(exprs []ast.Expr) []Arg {
+	args := make([]Arg, 0, len(exprs))
+	for _, e := range exprs {
+		args = append(args, Arg{Expr: exprString(e), Column: r.column(e)})
+	}
+	return args
+}
+
+// column returns the db tag of the field e reads when e is param.Field and
+// param a struct declared in the package.
+func (r resolver) column(e ast.Expr) string {
+	sel, ok := e.(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	return r.pkg.tags[r.params[x.Name]][sel.Sel.Name]
+}
+
+func exprString(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return exprString(e.X) + "." + e.Sel.Name
+	case *ast.BasicLit:
+		return e.Value
+	case *ast.StarExpr:
+		return "*" + exprString(e.X)
+	case *ast.UnaryExpr:
+		return e.Op.String() + exprString(e.X)
+	case *ast.CallExpr:
+		return exprString(e.Fun) + "(...)"
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+var (
+	placeholder = regexp.MustCompile(`\$(\d+)`)
+	assignment  = regexp.MustCompile(`(?i)\b([a-z_][a-z0-9_]*)\s*=\s*\$(\d+)\b`)
+	insert      = regexp.MustCompile(`(?is)\binsert\s+into\s+([a-z_][a-z0-9_]*)\s*\(([^)]*)\)\s*values\s*\(([^)]*)\)`)
+	table       = regexp.MustCompile(`(?i)\b(?:from|into|update)\s+([a-z_][a-z0-9_]*)`)
+	selectList  = regexp.MustCompile(`(?is)^\s*select\s+(.*?)\s+from\s+([a-z_][a-z0-9_]*)`)
+	identifier  = regexp.MustCompile(`(?i)^[a-z_][a-z0-9_]*$`)
+)
+
+// Placeholders returns the highest $n of sql.
+func Placeholders(sql string) int {
+	highest := 0
+	for _, m := range placeholder.FindAllStringSubmatch(sql, -1) {
+		n, _ := strconv.Atoi(m[1])
+		highest = max(highest, n)
+	}
+	return highest
+}
+
+// Binding is a column written or compared to a placeholder.
+type Binding struct {
+	Column      string
+	Placeholder int
+}
+
+// Bindings returns the column = $n pairs of sql, including the ones of an
+// INSERT column list and its VALUES.
+func Bindings(sql string) []Binding {
+	var bindings []Binding
+	if m := insert.FindStringSubmatch(sql); m != nil {
+		columns := splitList(m[2])
+		values := splitList(m[3])
+		for i := range columns {
+			if i >= len(values) {
+				break
+			}
+			if p := placeholder.FindStringSubmatch(values[i]); p != nil && p[0] == values[i] {
+				n, _ := strconv.Atoi(p[1])
+				bindings = append(bindings, Binding{Column: unquote(columns[i]), Placeholder: n})
+			}
+		}
+	}
+	for _, m := range assignment.FindAllStringSubmatch(sql, -1) {
+		n, _ := strconv.Atoi(m[2])
+		bindings = append(bindings, Binding{Column: strings.ToLower(m[1]), Placeholder: n})
+	}
+	return bindings
+}
+
+// Table returns the first table sql reads or writes, lower cased.
+func Table(sql string) string {
+	m := table.FindStringSubmatch(sql)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// SelectedColumns returns the plain columns of the select list of sql,
+// expressions like count(1) are left out.
+func SelectedColumns(sql string) []string {
+	m := selectList.FindStringSubmatch(sql)
+	if m == nil {
+		return nil
+	}
+	var columns []string
+	for _, c := range splitList(m[1]) {
+		c = unquote(c)
+		if identifier.MatchString(c) {
+			columns = append(columns, strings.ToLower(c))
+		}
+	}
+	return columns
+}
+
+func splitList(s string) []string {
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+func unquote(identifier string) string {
+	return strings.ToLower(strings.Trim(identifier, `"`))
+}
+
+// CheckQuery reports the placeholders of q without an argument, the
+// arguments without a placeholder and the struct fields bound to another
+// column than the one their db tag names.
+func CheckQuery(q Query) []Mismatch {
+	if q.Args == nil {
+		return nil
+	}
+	where := q.location()
+
+	var mismatches []Mismatch
+	if n := Placeholders(q.SQL); n != len(q.Args) {
+		mismatches = append(mismatches, Mismatch{
+			Where:   where,
+			Problem: fmt.Sprintf("query uses %d placeholders but gets %d arguments", n, len(q.Args)),
+		})
+	}
+	for _, b := range Bindings(q.SQL) {
+		if b.Placeholder < 1 || b.Placeholder > len(q.Args) {
+			continue
+		}
+		arg := q.Args[b.Placeholder-1]
+		if arg.Column != "" && arg.Column != b.Column {
+			mismatches = append(mismatches, Mismatch{
+				Where:   where,
+				Problem: fmt.Sprintf("column %s gets $%d = %s, tagged db:%q", b.Column, b.Placeholder, arg.Expr, arg.Column),
+			})
+		}
+	}
+	return mismatches
+}
@@ -0,0 +1,122 @@
+package schemacheck_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/schemacheck"
+)
+
+func TestCheckQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "matching insert",
+			src: `func Create(ctx context.Context, conn db.Connection, payload Account) error {
+				_, err := conn.Exec(ctx, "INSERT INTO ACCOUNT (id, email) VALUES ($1, $2)", payload.Id, payload.Email)
+				return err
+			}`,
+		},
+		{
+			name: "swapped insert arguments",
+			src: `func Create(ctx context.Context, conn db.Connection, payload Account) error {
+				_, err := conn.Exec(ctx, "INSERT INTO ACCOUNT (id, phone_number, email) VALUES ($1, $2, $3)", payload.Id, payload.Email, payload.PhoneNumber)
+				return err
+			}`,
+			want: []string{
+				`Create (repo.go:3): column phone_number gets $2 = payload.Email, tagged db:"email"`,
+				`Create (repo.go:3): column email gets $3 = payload.PhoneNumber, tagged db:"phone_number"`,
+			},
+		},
+		{
+			name: "missing update arguments",
+			src: `func Update(ctx context.Context, conn db.Connection, payload Account) error {
+				_, err := conn.Exec(ctx, ` + "`UPDATE ACCOUNT SET email = $2, phone_number = $3 WHERE id = $1`" + `, payload.Id, payload.PhoneNumber)
+				return err
+			}`,
+			want: []string{
+				"Update (repo.go:3): query uses 3 placeholders but gets 2 arguments",
+				`Update (repo.go:3): column email gets $2 = payload.PhoneNumber, tagged db:"phone_number"`,
+			},
+		},
+		{
+			name: "query and args held in variables",
+			src: `func Update(ctx context.Context, conn db.Connection, payload Account, version int) error {
+				const update = "UPDATE ACCOUNT SET email = $2 WHERE id = $1"
+				query := update
+				args := []any{payload.Id, payload.Email}
+				if version != 0 {
+					query += " AND version = $3"
+					args = append(args, version)
+				}
+				_, err := conn.Exec(ctx, query, args...)
+				return err
+			}`,
+		},
+		{
+			name: "arguments of outbox.Attach",
+			src: `func Create(ctx context.Context, conn db.Connection, payload Account) error {
+				query, args, err := outbox.Attach("INSERT INTO ACCOUNT (id, email) VALUES ($1, $2)", []any{payload.Id}, nil)
+				if err != nil {
+					return err
+				}
+				_, err = conn.Exec(ctx, query, args...)
+				return err
+			}`,
+			want: []string{"Create (repo.go:3): query uses 2 placeholders but gets 1 arguments"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := "package repository\n" + tt.src + `
+type Account struct {
+	Id          string  ` + "`db:\"id\"`" + `
+	Email       *string ` + "`db:\"email\"`" + `
+	PhoneNumber *string ` + "`db:\"phone_number\"`" + `
+}
+`
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "repo.go"), []byte(src), 0o644))
+
+			queries, err := schemacheck.Queries(dir)
+			require.NoError(t, err)
+			require.Len(t, queries, 1)
+
+			var got []string
+			for _, m := range schemacheck.CheckQuery(queries[0]) {
+				got = append(got, m.String())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRepositoryQueries(t *testing.T) {
+	queries, err := schemacheck.Queries("../repository")
+	require.NoError(t, err)
+	require.NotEmpty(t, queries)
+
+	for _, q := range queries {
+		for _, m := range schemacheck.CheckQuery(q) {
+			t.Error(m)
+		}
+	}
+}
+
+func TestBindings(t *testing.T) {
+	sql := `INSERT INTO FAKE_TABLE (id, "number", created_on) VALUES ($1, $2, now()) ON CONFLICT (id) DO UPDATE SET "number" = EXCLUDED."number", version = $3`
+	assert.Equal(t, []schemacheck.Binding{
+		{Column: "id", Placeholder: 1},
+		{Column: "number", Placeholder: 2},
+		{Column: "version", Placeholder: 3},
+	}, schemacheck.Bindings(sql))
+	assert.Equal(t, 3, schemacheck.Placeholders(sql))
+	assert.Equal(t, "fake_table", schemacheck.Table(sql))
+	assert.Equal(t, []string{"id", "number"}, schemacheck.SelectedColumns(`SELECT id, "number", count(1) FROM FAKE_TABLE`))
+}
@@ -0,0 +1,199 @@
+package schemacheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/xyedo/db-concurency-problem/db"
+)
+
+// Mismatch is one difference between the database and the Go code.
+type Mismatch struct {
+	Where   string
+	Problem string
+}
+
+func (m Mismatch) String() string {
+	return m.Where + ": " + m.Problem
+}
+
+// Column is a column as information_schema describes it.
+type Column struct {
+	Table    string `db:"table_name"`
+	Name     string `db:"column_name"`
+	DataType string `db:"data_type"`
+	UDT      string `db:"udt_name"`
+	Nullable bool   `db:"nullable"`
+}
+
+// Schema is the columns of every table of the current schema by table then
+// column name, both lower cased.
+type Schema map[string]map[string]Column
+
+// Load reads the columns of the tables of the current schema.
+func Load(ctx context.Context, conn db.Connection) (Schema, error) {
+	var columns []Column
+	err := pgxscan.Select(ctx, conn, &columns, `
+		SELECT
+			table_name,
+			column_name,
+			data_type,
+			udt_name,
+			is_nullable = 'YES' AS nullable
+		FROM information_schema.columns
+		WHERE table_schema = current_schema()
+		ORDER BY table_name, ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := make(Schema)
+	for _, c := range columns {
+		if schema[c.Table] == nil {
+			schema[c.Table] = make(map[string]Column)
+		}
+		schema[c.Table][c.Name] = c
+	}
+	return schema, nil
+}
+
+// CheckStruct compares the db tagged fields of v, a struct, with the columns
+// of table: every field must have a column of a compatible type, pointers
+// must map nullable columns and the other fields NOT NULL ones, and every
+// column must have a field.
+func (s Schema) CheckStruct(table string, v any) []Mismatch {
+	t := reflect.TypeOf(v)
+	where := fmt.Sprintf("%s (%s)", t.Name(), table)
+
+	columns, ok := s[strings.ToLower(table)]
+	if !ok {
+		return []Mismatch{{Where: where, Problem: "table does not exist"}}
+	}
+
+	var mismatches []Mismatch
+	mapped := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("db")
+		if name == "" || name == "-" {
+			continue
+		}
+		mapped[name] = true
+
+		c, ok := columns[name]
+		if !ok {
+			mismatches = append(mismatches, Mismatch{Where: where, Problem: fmt.Sprintf("field %s: column %s does not exist", field.Name, name)})
+			continue
+		}
+		if !compatible(field.Type, c) {
+			mismatches = append(mismatches, Mismatch{Where: where, Problem: fmt.Sprintf("field %s: %s cannot hold column %s of type %s", field.Name, field.Type, name, c.DataType)})
+		}
+		nullable := nilable(field.Type)
+		if c.Nullable && !nullable {
+			mismatches = append(mismatches, Mismatch{Where: where, Problem: fmt.Sprintf("field %s: column %s is nullable but %s cannot hold NULL", field.Name, name, field.Type)})
+		}
+		if !c.Nullable && field.Type.Kind() == reflect.Pointer {
+			mismatches = append(mismatches, Mismatch{Where: where, Problem: fmt.Sprintf("field %s: column %s is NOT NULL but the field is a pointer", field.Name, name)})
+		}
+	}
+
+	var unmapped []string
+	for name := range columns {
+		if !mapped[name] {
+			unmapped = append(unmapped, name)
+		}
+	}
+	sort.Strings(unmapped)
+	for _, name := range unmapped {
+		mismatches = append(mismatches, Mismatch{Where: where, Problem: fmt.Sprintf("column %s has no field", name)})
+	}
+	return mismatches
+}
+
+// CheckColumns reports the tables and columns q refers to that do not exist.
+func (s Schema) CheckColumns(q Query) []Mismatch {
+	table := Table(q.SQL)
+	if table == "" {
+		return nil
+	}
+	where := q.location()
+
+	columns, ok := s[table]
+	if !ok {
+		return []Mismatch{{Where: where, Problem: fmt.Sprintf("table %s does not exist", table)}}
+	}
+
+	var mismatches []Mismatch
+	seen := make(map[string]bool)
+	check := func(column string) {
+		if seen[column] {
+			return
+		}
+		seen[column] = true
+		if _, ok := columns[column]; !ok {
+			mismatches = append(mismatches, Mismatch{Where: where, Problem: fmt.Sprintf("column %s.%s does not exist", table, column)})
+		}
+	}
+	for _, b := range Bindings(q.SQL) {
+		check(b.Column)
+	}
+	for _, c := range SelectedColumns(q.SQL) {
+		check(c)
+	}
+	return mismatches
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// compatible tells whether a field of type t can scan and encode column c.
+// Types this check does not know about are accepted.
+func compatible(t reflect.Type, c Column) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch c.DataType {
+	case "text", "character varying", "character":
+		return t.Kind() == reflect.String
+	case "smallint", "integer", "bigint":
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return true
+		}
+		return false
+	case "numeric", "real", "double precision":
+		return t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+	case "boolean":
+		return t.Kind() == reflect.Bool
+	case "timestamp with time zone", "timestamp without time zone", "date":
+		return t == timeType
+	case "json", "jsonb":
+		switch t.Kind() {
+		case reflect.Map, reflect.Struct, reflect.Interface, reflect.Slice:
+			return true
+		}
+		return t == rawMessageType
+	case "USER-DEFINED":
+		if c.UDT == "citext" {
+			return t.Kind() == reflect.String
+		}
+	}
+	return true
+}
+
+func nilable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package schemacheck_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/repository"
+	"github.com/xyedo/db-concurency-problem/schemacheck"
+)
+
+func init() {
+	config.Get("../.env")
+}
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestSchemaDrift(t *testing.T) {
+	isolated := dbtest.Isolate(t)
+	schema, err := schemacheck.Load(context.Background(), isolated)
+	require.NoError(t, err)
+
+	t.Run("repository structs", func(t *testing.T) {
+		structs := []struct {
+			table string
+			v     any
+		}{
+			{table: "ACCOUNT", v: repository.Account{}},
+			{table: "THREAD", v: repository.Thread{}},
+			{table: "COMMENT", v: repository.Comment{}},
+			{table: "REACTION", v: repository.Reaction{}},
+		}
+		for _, s := range structs {
+			for _, m := range schema.CheckStruct(s.table, s.v) {
+				t.Error(m)
+			}
+		}
+	})
+
+	t.Run("queries", func(t *testing.T) {
+		for _, dir := range []string{"../repository", "../saga", "../queue", "../outbox", "../skew-write-problem", "../helper", "../db", "../eventsource"} {
+			queries, err := schemacheck.Queries(dir)
+			require.NoError(t, err)
+			for _, q := range queries {
+				for _, m := range schema.CheckColumns(q) {
+					t.Errorf("%s: %s", dir, m)
+				}
+			}
+		}
+	})
+
+	t.Run("generated statements", func(t *testing.T) {
+		repos := map[string]repository.Statements{
+			"Accounts":  repository.Accounts.Statements(),
+			"Threads":   repository.Threads.Statements(),
+			"Comments":  repository.Comments.Statements(),
+			"Reactions": repository.Reactions.Statements(),
+		}
+		for name, s := range repos {
+			for _, sql := range []string{s.Insert, s.Get, s.Update, s.UpdateCAS, s.Delete} {
+				for _, m := range schema.CheckColumns(schemacheck.Query{Func: name, SQL: sql}) {
+					t.Error(m)
+				}
+			}
+		}
+	})
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/require"
 	"github.com/xyedo/db-concurency-problem/config"
+	"github.com/xyedo/db-concurency-problem/dbtest"
+	"github.com/xyedo/db-concurency-problem/interleave"
 	skewwriteproblem "github.com/xyedo/db-concurency-problem/skew-write-problem"
 )
 
@@ -18,6 +20,10 @@ func init() {
 	config.Get("../.env")
 }
 
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
 func TestInsertNewAccount(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -44,42 +50,38 @@ func TestInsertNewAccount(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			userName := faker.Username(options.WithGenerateUniqueValues(true))
+			scheduler, err := interleave.New(
+				"T1 read",
+				"T2 read",
+				"T1 write",
+				"T2 write",
+				"T1 commit",
+				"T2 commit",
+			)
+			require.NoError(t, err)
+
 			var wg sync.WaitGroup
 			errs := make([]error, 2)
-
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				errs[0] = skewwriteproblem.InsertNewAccount(context.Background(), tt.txOpt, skewwriteproblem.Account{
-					Username: &userName,
-					Password: faker.Password(),
-				})
-			}()
-
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				errs[1] = skewwriteproblem.InsertNewAccount(context.Background(), tt.txOpt, skewwriteproblem.Account{
-					Username: &userName,
-					Password: faker.Password(),
-				})
-			}()
-
+			for i, txn := range []string{"T1", "T2"} {
+				wg.Add(1)
+				go func(i int, txn string) {
+					defer wg.Done()
+					errs[i] = skewwriteproblem.InsertNewAccount(scheduler.Context(context.Background(), txn), tt.txOpt, skewwriteproblem.Account{
+						Username: &userName,
+						Password: faker.Password(),
+					})
+				}(i, txn)
+			}
 			wg.Wait()
 
-			var vErr error
-			for _, err := range errs {
-				if err != nil {
-					vErr = err
-				}
-			}
+			require.NoError(t, errs[0])
 			if tt.wantErr {
-				require.Error(t, vErr)
+				require.Error(t, errs[1])
 				var pgErr *pgconn.PgError
-				require.ErrorAs(t, vErr, &pgErr)
+				require.ErrorAs(t, errs[1], &pgErr)
 				require.Equal(t, "23505", pgErr.Code)
 			} else {
-				require.Equal(t, "username already taken", vErr.Error())
+				require.EqualError(t, errs[1], "username already taken")
 			}
 
 		})
@@ -8,7 +8,9 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/dbtest"
 	"github.com/xyedo/db-concurency-problem/helper"
+	"github.com/xyedo/db-concurency-problem/interleave"
 	skewwriteproblem "github.com/xyedo/db-concurency-problem/skew-write-problem"
 )
 
@@ -16,34 +18,47 @@ func TestInsertNewFakeTable(t *testing.T) {
 	tests := []struct {
 		name  string
 		txOpt pgx.TxOptions
+		want  []string
 	}{
 		{
 			name:  "no error but duplicate numbering on default / read commited",
 			txOpt: pgx.TxOptions{},
+			want:  []string{"ft-001", "ft-001"},
 		},
 		{
-			name:  "mo error but duplicate numbering in repeatable read",
+			name:  "no error but duplicate numbering in repeatable read",
 			txOpt: pgx.TxOptions{IsoLevel: pgx.RepeatableRead},
+			want:  []string{"ft-001", "ft-001"},
 		},
 		{
 			name:  "no error and sequential numbering when in serializable",
 			txOpt: pgx.TxOptions{IsoLevel: pgx.Serializable},
+			want:  []string{"ft-001", "ft-002"},
 		},
 	}
-	const maxIteration = 10
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			var wg sync.WaitGroup
-			errs := make([]error, maxIteration)
+			dbtest.Isolate(t)
+
+			scheduler, err := interleave.New(
+				"T1 read",
+				"T2 read",
+				"T1 write",
+				"T2 write",
+				"T1 commit",
+				"T2 commit",
+			)
+			require.NoError(t, err)
 
-			wg.Add(maxIteration)
-			for i := 0; i < maxIteration; i++ {
-				go func(i int) {
+			var wg sync.WaitGroup
+			errs := make([]error, 2)
+			for i, txn := range []string{"T1", "T2"} {
+				wg.Add(1)
+				go func(i int, txn string) {
 					defer wg.Done()
-					errs[i] = skewwriteproblem.InsertNewFakeTable(context.Background(), tt.txOpt)
-				}(i)
+					errs[i] = skewwriteproblem.InsertNewFakeTable(scheduler.Context(context.Background(), txn), tt.txOpt)
+				}(i, txn)
 			}
-
 			wg.Wait()
 
 			for _, err := range errs {
@@ -52,10 +67,7 @@ func TestInsertNewFakeTable(t *testing.T) {
 			s, err := helper.SelectFakeTable(context.Background())
 			assert.NoError(t, err)
 
-			assert.Equal(t, []string{"ft-001", "ft-002", "ft-003", "ft-004", "ft-005", "ft-006", "ft-007", "ft-008", "ft-009"}, s)
-
-			err = helper.DeleteFakeTable(context.Background())
-			require.NoError(t, err)
+			assert.Equal(t, tt.want, s)
 		})
 	}
 
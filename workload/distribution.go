@@ -0,0 +1,116 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Distribution picks the index of the next key among n keys. Implementations
+// are not safe for concurrent use.
+type Distribution interface {
+	Next() int
+}
+
+type uniform struct {
+	r *rand.Rand
+	n int
+}
+
+func Uniform(r *rand.Rand, n int) Distribution {
+	return uniform{r, n}
+}
+
+func (u uniform) Next() int {
+	return u.r.Intn(u.n)
+}
+
+type zipf struct {
+	z *rand.Zipf
+}
+
+// Zipf favours the lowest indexes, key k is picked with a probability
+// proportional to 1/(k+1)^s. s must be greater than 1, the higher the more
+// skewed.
+func Zipf(r *rand.Rand, n int, s float64) (Distribution, error) {
+	z := rand.NewZipf(r, s, 1, uint64(n-1))
+	if z == nil {
+		return nil, fmt.Errorf("workload: zipf exponent must be greater than 1, got %v", s)
+	}
+
+	return zipf{z}, nil
+}
+
+func (z zipf) Next() int {
+	return int(z.z.Uint64())
+}
+
+type hotspot struct {
+	r           *rand.Rand
+	n           int
+	hot         int
+	probability float64
+}
+
+// Hotspot sends probability of the picks to the first fraction of the keys,
+// and spreads the rest uniformly over the other keys.
+func Hotspot(r *rand.Rand, n int, fraction, probability float64) (Distribution, error) {
+	if fraction <= 0 || fraction > 1 {
+		return nil, fmt.Errorf("workload: hotspot fraction must be in (0, 1], got %v", fraction)
+	}
+	if probability < 0 || probability > 1 {
+		return nil, fmt.Errorf("workload: hotspot probability must be in [0, 1], got %v", probability)
+	}
+
+	hot := max(1, int(fraction*float64(n)))
+	return hotspot{r, n, hot, probability}, nil
+}
+
+func (h hotspot) Next() int {
+	if h.hot == h.n || h.r.Float64() < h.probability {
+		return h.r.Intn(h.hot)
+	}
+	return h.hot + h.r.Intn(h.n-h.hot)
+}
+
+// ParseDistribution builds a distribution over n keys from a spec such as
+// "uniform", "zipf", "zipf:1.5", "hotspot" or "hotspot:0.1:0.9" (10% of the
+// keys receive 90% of the picks).
+func ParseDistribution(spec string, r *rand.Rand, n int) (Distribution, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("workload: need at least one key, got %d", n)
+	}
+
+	name, rest, _ := strings.Cut(spec, ":")
+	var params []float64
+	if rest != "" {
+		for _, p := range strings.Split(rest, ":") {
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return nil, fmt.Errorf("workload: invalid parameter %q in distribution %q", p, spec)
+			}
+			params = append(params, v)
+		}
+	}
+	param := func(i int, def float64) float64 {
+		if i < len(params) {
+			return params[i]
+		}
+		return def
+	}
+
+	switch name {
+	case "uniform":
+		return Uniform(r, n), nil
+	case "zipf":
+		if n == 1 {
+			return Uniform(r, n), nil
+		}
+		return Zipf(r, n, param(0, 1.1))
+	case "hotspot":
+		return Hotspot(r, n, param(0, 0.1), param(1, 0.9))
+	default:
+		return nil, fmt.Errorf("workload: unknown distribution %q, want uniform, zipf[:s] or hotspot[:fraction:probability]", spec)
+	}
+}
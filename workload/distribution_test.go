@@ -0,0 +1,87 @@
+package workload_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/workload"
+)
+
+func histogram(t *testing.T, spec string, n, picks int) []int {
+	t.Helper()
+	d, err := workload.ParseDistribution(spec, rand.New(rand.NewSource(1)), n)
+	require.NoError(t, err)
+
+	counts := make([]int, n)
+	for i := 0; i < picks; i++ {
+		k := d.Next()
+		require.GreaterOrEqual(t, k, 0)
+		require.Less(t, k, n)
+		counts[k]++
+	}
+	return counts
+}
+
+func TestUniform(t *testing.T) {
+	counts := histogram(t, "uniform", 10, 100000)
+	for k, c := range counts {
+		assert.InDelta(t, 10000, c, 500, "key %d", k)
+	}
+}
+
+func TestZipf(t *testing.T) {
+	counts := histogram(t, "zipf:1.5", 100, 100000)
+	for k := 1; k < 5; k++ {
+		assert.Greater(t, counts[k-1], counts[k], "key %d must be picked more than key %d", k-1, k)
+	}
+	// 1/(k+1)^1.5 gives the first key about 41% of the picks over 100 keys
+	assert.InDelta(t, 0.41, float64(counts[0])/100000, 0.02)
+
+	steeper := histogram(t, "zipf:3", 100, 100000)
+	assert.Greater(t, steeper[0], counts[0])
+}
+
+func TestHotspot(t *testing.T) {
+	counts := histogram(t, "hotspot:0.1:0.9", 100, 100000)
+	hot := 0
+	for _, c := range counts[:10] {
+		hot += c
+	}
+	assert.InDelta(t, 0.9, float64(hot)/100000, 0.01)
+	for k, c := range counts[10:] {
+		assert.Positive(t, c, "cold key %d never picked", k+10)
+	}
+}
+
+func TestParseDistribution(t *testing.T) {
+	tests := []struct {
+		spec    string
+		n       int
+		wantErr string
+	}{
+		{spec: "uniform", n: 1},
+		{spec: "zipf", n: 1},
+		{spec: "zipf", n: 10},
+		{spec: "hotspot", n: 10},
+		{spec: "hotspot:0.5", n: 1},
+		{spec: "zipf:1", n: 10, wantErr: "must be greater than 1"},
+		{spec: "zipf:x", n: 10, wantErr: `invalid parameter "x"`},
+		{spec: "hotspot:0:0.5", n: 10, wantErr: "fraction must be in (0, 1]"},
+		{spec: "hotspot:0.1:2", n: 10, wantErr: "probability must be in [0, 1]"},
+		{spec: "gaussian", n: 10, wantErr: "unknown distribution"},
+		{spec: "uniform", n: 0, wantErr: "at least one key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			d, err := workload.ParseDistribution(tt.spec, rand.New(rand.NewSource(1)), tt.n)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Less(t, d.Next(), tt.n)
+		})
+	}
+}
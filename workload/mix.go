@@ -0,0 +1,84 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+type Op string
+
+const (
+	Read    Op = "read"
+	React   Op = "react"
+	Comment Op = "comment"
+)
+
+const opsCount = 3
+
+var ops = [opsCount]Op{Read, React, Comment}
+
+// Mix holds the relative weight of every operation.
+type Mix struct {
+	Read    int
+	React   int
+	Comment int
+}
+
+func (m Mix) weights() [opsCount]int {
+	return [opsCount]int{m.Read, m.React, m.Comment}
+}
+
+func (m Mix) pick(r *rand.Rand) Op {
+	weights := m.weights()
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+
+	n := r.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return ops[i]
+		}
+		n -= w
+	}
+	return ops[opsCount-1]
+}
+
+func (m Mix) String() string {
+	return fmt.Sprintf("read:%d,react:%d,comment:%d", m.Read, m.React, m.Comment)
+}
+
+// ParseMix reads weights such as "read:80,react:15,comment:5", missing
+// operations get a weight of 0.
+func ParseMix(spec string) (Mix, error) {
+	var m Mix
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return Mix{}, fmt.Errorf("workload: mix entry %q must be \"<op>:<weight>\"", part)
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 {
+			return Mix{}, fmt.Errorf("workload: invalid weight %q for %s", value, name)
+		}
+
+		switch Op(name) {
+		case Read:
+			m.Read = weight
+		case React:
+			m.React = weight
+		case Comment:
+			m.Comment = weight
+		default:
+			return Mix{}, fmt.Errorf("workload: unknown op %q, want read, react or comment", name)
+		}
+	}
+
+	if m.Read+m.React+m.Comment == 0 {
+		return Mix{}, fmt.Errorf("workload: mix %q has no weight", spec)
+	}
+	return m, nil
+}
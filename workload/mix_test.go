@@ -0,0 +1,44 @@
+package workload_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xyedo/db-concurency-problem/workload"
+)
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    workload.Mix
+		wantErr string
+	}{
+		{spec: "read:80,react:15,comment:5", want: workload.Mix{Read: 80, React: 15, Comment: 5}},
+		{spec: "react:1", want: workload.Mix{React: 1}},
+		{spec: "read:1, comment:1", want: workload.Mix{Read: 1, Comment: 1}},
+		{spec: "read", wantErr: `must be "<op>:<weight>"`},
+		{spec: "read:-1", wantErr: "invalid weight"},
+		{spec: "write:1", wantErr: "unknown op"},
+		{spec: "read:0", wantErr: "has no weight"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := workload.ParseMix(tt.spec)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, must(workload.ParseMix(got.String())))
+		})
+	}
+}
+
+func must(m workload.Mix, err error) workload.Mix {
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
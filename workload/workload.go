@@ -0,0 +1,261 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xyedo/db-concurency-problem/db"
+	"github.com/xyedo/db-concurency-problem/helper"
+	isolationlevelbenchmark "github.com/xyedo/db-concurency-problem/isolation-level-benchmark"
+	"github.com/xyedo/db-concurency-problem/loadgen"
+	"github.com/xyedo/db-concurency-problem/report"
+	"github.com/xyedo/db-concurency-problem/repository"
+)
+
+// Reaction is a lost update strategy, see lostupdatebenchmark.
+type Reaction interface {
+	Do(ctx context.Context, threadId, userId string) error
+}
+
+type Config struct {
+	// Distribution picks the thread of every operation, see ParseDistribution.
+	Distribution string
+	Mix          Mix
+	Reaction     Reaction
+	// CommentTxOptions is used by the transaction adding a comment and
+	// bumping the thread counter.
+	CommentTxOptions pgx.TxOptions
+
+	// Concurrency workers loop over operations (closed loop) unless Rate is
+	// set, then operations arrive at Rate per second whatever the latency
+	// (open loop) and MaxInFlight and MaxQueue bound the backlog, see
+	// loadgen.Config.
+	Concurrency int
+	Rate        float64
+	MaxInFlight int
+	MaxQueue    int
+	Duration    time.Duration
+	Seed        int64
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Mix.Read+c.Mix.React+c.Mix.Comment <= 0:
+		return errors.New("workload: mix has no weight")
+	case c.Mix.React > 0 && c.Reaction == nil:
+		return errors.New("workload: mix reacts but no reaction strategy is set")
+	case c.Rate <= 0 && c.Concurrency <= 0:
+		return errors.New("workload: need a concurrency (closed loop) or a rate (open loop)")
+	case c.Duration <= 0:
+		return errors.New("workload: duration must be positive")
+	}
+	return nil
+}
+
+type Fixture struct {
+	ThreadIds []string
+	UserIds   []string
+}
+
+// Seed creates users and threads, each thread written by one of the users.
+func Seed(ctx context.Context, threads, users int) (Fixture, error) {
+	if threads <= 0 || users <= 0 {
+		return Fixture{}, errors.New("workload: need at least one thread and one user")
+	}
+
+	userIds, err := helper.CreateUsers(users)
+	if err != nil {
+		return Fixture{}, err
+	}
+
+	fixture := Fixture{UserIds: userIds}
+	for i := 0; i < threads; i++ {
+		if err := ctx.Err(); err != nil {
+			return Fixture{}, err
+		}
+		threadId, err := helper.CreateThread(fixture.UserIds[i%users])
+		if err != nil {
+			return Fixture{}, err
+		}
+		fixture.ThreadIds = append(fixture.ThreadIds, threadId)
+	}
+
+	return fixture, nil
+}
+
+type operation struct {
+	op       Op
+	threadId string
+	userId   string
+}
+
+type generator struct {
+	mu      sync.Mutex
+	r       *rand.Rand
+	threads Distribution
+	mix     Mix
+	fixture Fixture
+	hits    []int
+}
+
+func (g *generator) next() operation {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	thread := g.threads.Next()
+	g.hits[thread]++
+	return operation{
+		op:       g.mix.pick(g.r),
+		threadId: g.fixture.ThreadIds[thread],
+		userId:   g.fixture.UserIds[g.r.Intn(len(g.fixture.UserIds))],
+	}
+}
+
+// hottest returns the share of the operations the most picked thread got.
+func (g *generator) hottest() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	hits := append([]int(nil), g.hits...)
+	sort.Sort(sort.Reverse(sort.IntSlice(hits)))
+	total := 0
+	for _, n := range hits {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(hits[0]) / float64(total)
+}
+
+type Summary struct {
+	// Hottest is the share of the operations that hit the most contended
+	// thread.
+	Hottest  float64
+	OpenLoop *report.OpenLoop
+}
+
+// Run drives the fixture with cfg until cfg.Duration elapses or ctx is done,
+// recording every operation in rec.
+func Run(ctx context.Context, cfg Config, fixture Fixture, rec *report.Recorder) (Summary, error) {
+	if err := cfg.validate(); err != nil {
+		return Summary{}, err
+	}
+	if len(fixture.ThreadIds) == 0 || len(fixture.UserIds) == 0 {
+		return Summary{}, errors.New("workload: empty fixture")
+	}
+
+	r := rand.New(rand.NewSource(cfg.Seed))
+	threads, err := ParseDistribution(cfg.Distribution, r, len(fixture.ThreadIds))
+	if err != nil {
+		return Summary{}, err
+	}
+	g := &generator{r: r, threads: threads, mix: cfg.Mix, fixture: fixture, hits: make([]int, len(fixture.ThreadIds))}
+
+	if cfg.Rate > 0 {
+		stats, err := loadgen.Run(ctx, loadgen.Config{
+			Rate:        cfg.Rate,
+			Duration:    cfg.Duration,
+			MaxInFlight: cfg.MaxInFlight,
+			MaxQueue:    cfg.MaxQueue,
+		}, rec, func(ctx context.Context, _ int) error {
+			return cfg.execute(ctx, g.next())
+		})
+		if err != nil {
+			return Summary{}, err
+		}
+
+		return Summary{Hottest: g.hottest(), OpenLoop: &stats}, nil
+	}
+
+	// operations in flight when the duration elapses still run to completion
+	running, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for running.Err() == nil {
+				o := g.next()
+				_ = rec.Do(ctx, func(ctx context.Context) error {
+					return cfg.execute(ctx, o)
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	return Summary{Hottest: g.hottest()}, nil
+}
+
+func (c Config) execute(ctx context.Context, o operation) error {
+	switch o.op {
+	case React:
+		return c.Reaction.Do(ctx, o.threadId, o.userId)
+	case Comment:
+		return isolationlevelbenchmark.ReadModifyWriteComment(ctx, c.CommentTxOptions, o.userId, o.threadId)
+	default:
+		conn, err := db.GetConnection(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Release()
+
+		_, err = repository.GetThread(ctx, conn, o.threadId)
+		return err
+	}
+}
+
+// Verify compares the counters of every thread with the reactions and
+// comments actually stored, and describes the first mismatches.
+func Verify(ctx context.Context, fixture Fixture) (bool, string, error) {
+	conn, err := db.GetConnection(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	defer conn.Release()
+
+	var mismatches []string
+	for _, threadId := range fixture.ThreadIds {
+		thread, err := repository.GetThread(ctx, conn, threadId)
+		if err != nil {
+			return false, "", err
+		}
+		reactions, err := repository.CountThreadReactions(ctx, conn, threadId)
+		if err != nil {
+			return false, "", err
+		}
+		comments, err := repository.CountThreadComments(ctx, conn, threadId)
+		if err != nil {
+			return false, "", err
+		}
+
+		if thread.TotalReaction != reactions || thread.TotalComment != comments {
+			mismatches = append(mismatches, fmt.Sprintf(
+				"thread %s total_reaction=%d/%d total_comment=%d/%d",
+				threadId, thread.TotalReaction, reactions, thread.TotalComment, comments,
+			))
+		}
+	}
+
+	if len(mismatches) == 0 {
+		return true, fmt.Sprintf("%d threads, every counter matches", len(fixture.ThreadIds)), nil
+	}
+	detail := fmt.Sprintf("%d of %d threads lost updates (counter/stored)", len(mismatches), len(fixture.ThreadIds))
+	for i, m := range mismatches {
+		if i == 3 {
+			detail += ", ..."
+			break
+		}
+		detail += ", " + m
+	}
+	return false, detail, nil
+}